@@ -0,0 +1,230 @@
+package wikiparse
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// An AuthoredToken is a token of a revision's text along with the
+// revision that first added it.
+type AuthoredToken struct {
+	Text        string
+	RevisionID  uint64
+	Contributor Contributor
+}
+
+// AuthorStats sums up one contributor's part in a page.
+type AuthorStats struct {
+	Contributor Contributor
+	// Tokens is the number of tokens of the latest revision the
+	// contributor added, and Share is the fraction of the latest
+	// revision's tokens that is.
+	Tokens int
+	Share  float64
+	// Added is the number of tokens the contributor added over all
+	// revisions, including ones since removed.
+	Added int
+	// Revisions is the number of revisions the contributor made.
+	Revisions int
+}
+
+// An Authorship tracks who wrote each token of a page's text as its
+// revisions are added in order.  Tokens are words and single
+// punctuation or markup characters; whitespace isn't attributed.
+//
+// Tokens that survive an edit keep their author, including tokens
+// moved elsewhere in the text.  Inserted tokens belong to the
+// revision inserting them, unless they're on a line that was in an
+// earlier revision: then they go back to that line's authors, so
+// reverting vandalism doesn't take credit from the original authors.
+// Any reinserted line counts, so a common one such as a heading goes
+// back to whoever first wrote it, but lines of only markup like "}}"
+// or "|}" are left out.  Every distinct line is remembered, so memory
+// grows with the size of the page's history.
+type Authorship struct {
+	revs    []authorRevision
+	text    string
+	tokens  []string
+	origins []int32
+	// Each line ever seen, and the origin of its tokens.
+	lines map[string][]int32
+}
+
+type authorRevision struct {
+	id          uint64
+	contributor Contributor
+	added       int
+}
+
+// NewAuthorship gets an Authorship with no revisions.
+func NewAuthorship() *Authorship {
+	return &Authorship{lines: map[string][]int32{}}
+}
+
+// PageAuthorship tracks the authorship of all of a page's revisions.
+func PageAuthorship(p *Page) *Authorship {
+	a := NewAuthorship()
+	for i := range p.Revisions {
+		a.Add(&p.Revisions[i])
+	}
+	return a
+}
+
+func isSpaceToken(tok string) bool {
+	r, _ := utf8.DecodeRuneInString(tok)
+	return unicode.IsSpace(r)
+}
+
+// Add attributes the text of the next revision.
+func (a *Authorship) Add(r *Revision) {
+	cur := int32(len(a.revs))
+	rev := authorRevision{id: r.ID, contributor: r.Contributor}
+
+	td := diffText(a.text, r.Text)
+	// The line and offset within it of each new token.
+	lineOf := make([]int, 0, len(td.newWords))
+	offset := make([]int, 0, len(td.newWords))
+	for l, n := range td.newCounts {
+		for i := 0; i < n; i++ {
+			lineOf = append(lineOf, l)
+			offset = append(offset, i)
+		}
+	}
+
+	origins := make([]int32, 0, len(td.newWords))
+	var o int
+	var ins, del []int
+	for _, run := range td.words {
+		switch run.op {
+		case DiffEqual:
+			origins = append(origins, a.origins[o:o+run.n]...)
+			o += run.n
+		case DiffDelete:
+			for i := 0; i < run.n; i++ {
+				del = append(del, o)
+				o++
+			}
+		case DiffInsert:
+			for i := 0; i < run.n; i++ {
+				n := len(origins)
+				if prev, ok := a.lines[td.newLines[lineOf[n]]]; ok {
+					origins = append(origins, prev[offset[n]])
+					continue
+				}
+				ins = append(ins, n)
+				origins = append(origins, cur)
+			}
+		}
+	}
+
+	// Tokens moved from elsewhere in the text keep their origin.
+	if len(ins) > 0 && len(del) > 0 {
+		oldToks := make([]string, len(del))
+		for i, o := range del {
+			oldToks[i] = a.tokens[o]
+		}
+		newToks := make([]string, len(ins))
+		for i, n := range ins {
+			newToks[i] = td.newWords[n]
+		}
+		var i, j int
+		for _, run := range diffTokens(oldToks, newToks) {
+			switch run.op {
+			case DiffEqual:
+				for k := 0; k < run.n; k++ {
+					origins[ins[j+k]] = a.origins[del[i+k]]
+				}
+				i, j = i+run.n, j+run.n
+			case DiffDelete:
+				i += run.n
+			case DiffInsert:
+				j += run.n
+			}
+		}
+	}
+	for _, n := range ins {
+		if origins[n] == cur && !isSpaceToken(td.newWords[n]) {
+			rev.added++
+		}
+	}
+
+	var start int
+	for l, n := range td.newCounts {
+		line := td.newLines[l]
+		if _, ok := a.lines[line]; !ok && !markupLine(line) {
+			// Clone the line so the revision's text can be freed.
+			a.lines[strings.Clone(line)] = append([]int32(nil), origins[start:start+n]...)
+		}
+		start += n
+	}
+
+	a.revs = append(a.revs, rev)
+	a.text, a.tokens, a.origins = r.Text, td.newWords, origins
+}
+
+// markupLine reports whether a line has no words, only markup and
+// space.
+func markupLine(line string) bool {
+	return strings.IndexFunc(line, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}) < 0
+}
+
+// Tokens gets the latest revision's tokens and their authors.
+func (a *Authorship) Tokens() []AuthoredToken {
+	var rv []AuthoredToken
+	for i, tok := range a.tokens {
+		if isSpaceToken(tok) {
+			continue
+		}
+		r := a.revs[a.origins[i]]
+		rv = append(rv, AuthoredToken{Text: tok, RevisionID: r.id, Contributor: r.contributor})
+	}
+	return rv
+}
+
+// Stats gets each contributor's part in the page, with those who
+// wrote the most of the latest revision first.  Contributors are
+// identified by name (or IP address for anonymous contributors).
+func (a *Authorship) Stats() []AuthorStats {
+	byName := map[string]*AuthorStats{}
+	var rv []*AuthorStats
+	get := func(c Contributor) *AuthorStats {
+		s, ok := byName[c.Name()]
+		if !ok {
+			s = &AuthorStats{Contributor: c}
+			byName[c.Name()] = s
+			rv = append(rv, s)
+		}
+		return s
+	}
+	for _, r := range a.revs {
+		s := get(r.contributor)
+		s.Revisions++
+		s.Added += r.added
+	}
+	total := 0
+	for i, tok := range a.tokens {
+		if !isSpaceToken(tok) {
+			get(a.revs[a.origins[i]].contributor).Tokens++
+			total++
+		}
+	}
+
+	sort.SliceStable(rv, func(i, j int) bool {
+		if rv[i].Tokens != rv[j].Tokens {
+			return rv[i].Tokens > rv[j].Tokens
+		}
+		return rv[i].Added > rv[j].Added
+	})
+	stats := make([]AuthorStats, len(rv))
+	for i, s := range rv {
+		if total > 0 {
+			s.Share = float64(s.Tokens) / float64(total)
+		}
+		stats[i] = *s
+	}
+	return stats
+}
@@ -0,0 +1,78 @@
+package wikiparse
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func authorshipPage() *Page {
+	ed := Contributor{ID: 1, Username: "Ed"}
+	al := Contributor{ID: 2, Username: "Al"}
+	anon := Contributor{IP: "10.0.0.1"}
+	return &Page{Revisions: []Revision{
+		{ID: 1, Contributor: ed, Text: "Halifax is a city.\nIt has a harbour.\n"},
+		{ID: 2, Contributor: al, Text: "Halifax is a big city.\nIt has a harbour.\n"},
+		{ID: 3, Contributor: anon, Text: "Halifax is lame.\n"},
+		{ID: 4, Contributor: ed, Text: "Halifax is a big city.\nIt has a harbour.\n"},
+		{ID: 5, Contributor: al, Text: "It has a harbour.\nHalifax is a big city in [[Nova Scotia]].\n"},
+	}}
+}
+
+func TestAuthorship(t *testing.T) {
+	t.Parallel()
+	a := PageAuthorship(authorshipPage())
+
+	var got []string
+	for _, tok := range a.Tokens() {
+		got = append(got, fmt.Sprintf("%s/%d", tok.Text, tok.RevisionID))
+	}
+	exp := "It/1 has/1 a/1 harbour/1 ./1 Halifax/1 is/1 a/1 big/2 city/1 in/5 [/5 [/5 Nova/5 Scotia/5 ]/5 ]/5 ./1"
+	if strings.Join(got, " ") != exp {
+		t.Errorf("Expected %v, got %v", exp, strings.Join(got, " "))
+	}
+
+	stats := a.Stats()
+	var summary []string
+	for _, s := range stats {
+		summary = append(summary, fmt.Sprintf("%s:%d:%.2f:%d:%d",
+			s.Contributor.Name(), s.Tokens, s.Share, s.Added, s.Revisions))
+	}
+	expStats := []string{"Ed:10:0.56:10:2", "Al:8:0.44:8:2", "10.0.0.1:0:0.00:1:1"}
+	if !reflect.DeepEqual(summary, expStats) {
+		t.Errorf("Expected %v, got %v", expStats, summary)
+	}
+}
+
+func TestAuthorshipMarkupLines(t *testing.T) {
+	t.Parallel()
+	a := NewAuthorship()
+	for i, text := range []string{
+		"{{Infobox\n| a\n}}\n",
+		"Text\n",
+		"{{Other\n| b\n}}\n",
+	} {
+		a.Add(&Revision{ID: uint64(i + 1), Text: text})
+	}
+	var got []string
+	for _, tok := range a.Tokens() {
+		got = append(got, fmt.Sprintf("%s/%d", tok.Text, tok.RevisionID))
+	}
+	exp := "{/3 {/3 Other/3 |/3 b/3 }/3 }/3"
+	if strings.Join(got, " ") != exp {
+		t.Errorf("Expected %v, got %v", exp, strings.Join(got, " "))
+	}
+}
+
+func TestAuthorshipEmpty(t *testing.T) {
+	t.Parallel()
+	a := NewAuthorship()
+	if toks, stats := a.Tokens(), a.Stats(); len(toks) != 0 || len(stats) != 0 {
+		t.Errorf("Expected nothing, got %v and %v", toks, stats)
+	}
+	a.Add(&Revision{ID: 1, Contributor: Contributor{Username: "Ed"}})
+	if stats := a.Stats(); len(stats) != 1 || stats[0].Share != 0 || stats[0].Revisions != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
@@ -2,11 +2,11 @@ package wikiparse
 
 import (
 	"errors"
-	"fmt"
 	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 var coordRE, nowikiRE, commentRE *regexp.Regexp
@@ -15,7 +15,9 @@ var coordRE, nowikiRE, commentRE *regexp.Regexp
 // coordinate date found.
 var ErrNoCoordFound = errors.New("no coord data found")
 
-var errNotSexagesimal = errors.New("not a sexagesimal value")
+// ErrNonEarthGlobe is returned from ParseCoords when the coordinate
+// is on another body, such as the moon or Mars.
+var ErrNonEarthGlobe = errors.New("coordinate is not on earth")
 
 func init() {
 	coordRE = regexp.MustCompile(`(?mi){{coord\|(.[^}]*)}}`)
@@ -26,158 +28,395 @@ func init() {
 // Coord is Longitude/latitude pair from a coordinate match.
 type Coord struct {
 	Lon, Lat float64
+	// Precision is the uncertainty implied by how the coordinate
+	// was written, in degrees.  e.g. 1/3600 for whole seconds or
+	// 0.01 for a decimal with two places.
+	Precision float64
 }
 
-func dms(parts []string) (float64, error) {
-	if len(parts) != 4 {
-		return 0, fmt.Errorf("Wrong number of elements: %#v", parts)
-	}
-	rv, err := strconv.ParseFloat(parts[0], 64)
-	if err != nil {
-		return rv, err
-	}
-	f, err := strconv.ParseFloat(parts[1], 64)
-	if err != nil {
-		return rv, err
-	}
-	rv += f / 60.0
-	f, err = strconv.ParseFloat(parts[2], 64)
-	if err != nil {
-		return rv, err
-	}
-	rv += f / 3600.0
+// Errors wrapped by a CoordError.
+var (
+	// ErrCoordFormat means the parts of a coordinate weren't in any
+	// of the documented {{coord}} layouts.
+	ErrCoordFormat = errors.New("unrecognized coordinate format")
+	// ErrCoordValue means a degree, minute or second value is
+	// malformed, e.g. minutes of 60 or more.
+	ErrCoordValue = errors.New("bad coordinate value")
+	// ErrCoordSign means negative degrees were combined with an N
+	// or E hemisphere.
+	ErrCoordSign = errors.New("negative degrees with N or E hemisphere")
+	// ErrCoordRange means a latitude or longitude is out of range.
+	ErrCoordRange = errors.New("coordinate out of range")
+)
 
-	if parts[3] == "S" || parts[3] == "W" {
-		rv = -rv
-	}
-	return rv, err
+// A CoordError describes a coordinate that couldn't be parsed.
+type CoordError struct {
+	// Field is "latitude" or "longitude", or "coordinate" when the
+	// layout as a whole is wrong.
+	Field string
+	// Value is the offending value.
+	Value string
+	// Err is one of the ErrCoord errors.
+	Err error
 }
 
-func dm(parts []string) (float64, error) {
-	if len(parts) != 3 {
-		return 0, fmt.Errorf("Wrong number of elements: %#v", parts)
-	}
-	rv, err := strconv.ParseFloat(parts[0], 64)
-	if err != nil {
-		return rv, err
+func (e *CoordError) Error() string {
+	msg := "invalid " + e.Field + ": " + e.Value
+	if e.Err != ErrCoordRange {
+		msg += " (" + e.Err.Error() + ")"
 	}
-	f, err := strconv.ParseFloat(parts[1], 64)
-	if err != nil {
-		return rv, err
-	}
-	rv += f / 60.0
-	if parts[2] == "S" || parts[2] == "W" {
-		rv = -rv
+	return msg
+}
+
+func (e *CoordError) Unwrap() error {
+	return e.Err
+}
+
+type coordTokenKind int
+
+const (
+	blankToken coordTokenKind = iota
+	numberToken
+	hemisphereToken
+	otherToken
+)
+
+type coordToken struct {
+	kind coordTokenKind
+	text string
+}
+
+// tokenizeCoordParts splits coordinate parts into numbers and
+// hemisphere letters.  A single part may hold several values, as in
+// "40°26′46″N".  Blank parts are kept since {{coord|42||N|...}} leaves
+// the minutes blank, but parameters such as "type:city" are opaque.
+func tokenizeCoordParts(parts []string) []coordToken {
+	var rv []coordToken
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "":
+			rv = append(rv, coordToken{kind: blankToken})
+			continue
+		case strings.ContainsAny(p, ":="):
+			rv = append(rv, coordToken{otherToken, p})
+			continue
+		}
+		var cur []rune
+		kind := otherToken
+		flush := func() {
+			if len(cur) == 0 {
+				return
+			}
+			t := coordToken{kind, string(cur)}
+			if kind == otherToken && len(cur) == 1 && strings.ContainsRune("NSEW", unicode.ToUpper(cur[0])) {
+				t = coordToken{hemisphereToken, strings.ToUpper(t.text)}
+			}
+			rv = append(rv, t)
+			cur = nil
+		}
+		for _, r := range p {
+			if r == '\u2212' {
+				// The typographic minus sign.
+				r = '-'
+			}
+			switch {
+			case unicode.IsDigit(r) || strings.ContainsRune(".+-", r):
+				if kind != numberToken {
+					flush()
+				}
+				kind = numberToken
+			case unicode.IsLetter(r):
+				if kind != otherToken {
+					flush()
+				}
+				kind = otherToken
+			default:
+				// Degree, minute and second symbols and spaces
+				// only separate values.
+				flush()
+				continue
+			}
+			cur = append(cur, r)
+		}
+		flush()
 	}
-	return rv, err
+	return rv
 }
 
-func parseSexagesimal(parts []string) (Coord, error) {
-	if len(parts) < 8 {
-		return Coord{}, errNotSexagesimal
+// decimalPlaces counts the digits after the decimal point.
+func decimalPlaces(s string) int {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
 	}
-	if parts[3] != "N" && parts[3] != "S" {
-		return Coord{}, errNotSexagesimal
+	return 0
+}
+
+// parseAngle parses the degrees and optional minutes and seconds of
+// a latitude or longitude followed by a hemisphere letter (if hemi
+// isn't empty), returning the value and its precision.
+func parseAngle(field string, vals []coordToken, hemi string) (float64, float64, error) {
+	bad := func(t coordToken, err error) (float64, float64, error) {
+		return 0, 0, &CoordError{Field: field, Value: t.text, Err: err}
 	}
-	if parts[7] != "E" && parts[7] != "W" {
-		return Coord{}, errNotSexagesimal
+	if len(vals) == 0 || len(vals) > 3 || vals[0].kind != numberToken {
+		v := ""
+		if len(vals) > 0 {
+			v = vals[0].text
+		}
+		return bad(coordToken{text: v}, ErrCoordFormat)
 	}
 
-	lat, err := dms(parts[0:4])
-	if err != nil {
-		return Coord{}, err
+	last := 0
+	for i, t := range vals {
+		if t.kind == numberToken {
+			last = i
+		} else if t.kind != blankToken {
+			return bad(t, ErrCoordValue)
+		}
 	}
 
-	lon, err := dms(parts[4:8])
-
-	rv := Coord{
-		Lat: lat,
-		Lon: lon,
+	var rv, precision float64
+	neg := false
+	for i, t := range vals {
+		if t.kind == blankToken {
+			continue
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return bad(t, ErrCoordValue)
+		}
+		switch {
+		case i == 0:
+			neg = strings.HasPrefix(t.text, "-")
+			f = math.Abs(f)
+		case f < 0 || f >= 60 || strings.ContainsAny(t.text, "+-"):
+			return bad(t, ErrCoordValue)
+		}
+		if i < last && decimalPlaces(t.text) > 0 {
+			// Only the last value may have a fraction.
+			return bad(t, ErrCoordValue)
+		}
+		unit := math.Pow(60, -float64(i))
+		rv += f * unit
+		if i == last {
+			precision = unit * math.Pow(10, -float64(decimalPlaces(t.text)))
+		}
 	}
 
-	return rv, err
+	switch {
+	case neg && (hemi == "N" || hemi == "E"):
+		return bad(vals[0], ErrCoordSign)
+	case neg, hemi == "S", hemi == "W":
+		rv = -rv
+	}
+	return rv, precision, nil
 }
 
-
-func parseSexagesimal2(parts []string) (Coord, error) {
-	if len(parts) < 6 {
-		return Coord{}, errNotSexagesimal
+// parseCoordParts parses the positional parts of a coord template.
+//
+// The documented layouts are signed decimal degrees ({{coord|dd.dd|
+// ddd.dd}}) or, for each of the latitude and longitude, degrees with
+// optional minutes and seconds followed by a hemisphere letter
+// ({{coord|dd|mm|ss.s|N|ddd|mm|ss.s|W}}).  Any parts before the first
+// number are skipped, as are the parameters after the coordinate.
+func parseCoordParts(parts []string) (Coord, error) {
+	tokens := tokenizeCoordParts(parts)
+	for len(tokens) > 0 && tokens[0].kind != numberToken {
+		tokens = tokens[1:]
 	}
-	if parts[2] != "N" && parts[2] != "S" {
-		return Coord{}, errNotSexagesimal
-	}
-	if parts[5] != "E" && parts[5] != "W" {
-		return Coord{}, errNotSexagesimal
+	if len(tokens) == 0 {
+		return Coord{}, ErrNoCoordFound
 	}
 
-	lat, err := dm(parts[0:3])
-	if err != nil {
-		return Coord{}, err
+	// findHemi gets the position of a hemisphere letter among the
+	// first few tokens.
+	findHemi := func(ts []coordToken, letters string) int {
+		for i := 0; i < len(ts) && i < 4; i++ {
+			if ts[i].kind == hemisphereToken {
+				if strings.Contains(letters, ts[i].text) {
+					return i
+				}
+				return -1
+			}
+		}
+		return -1
 	}
 
-	lon, err := dm(parts[3:6])
+	var rv Coord
+	var latPrec, lonPrec float64
+	var err error
+	if i := findHemi(tokens, "NS"); i >= 0 {
+		rv.Lat, latPrec, err = parseAngle("latitude", tokens[:i], tokens[i].text)
+		if err != nil {
+			return Coord{}, err
+		}
+		tokens = tokens[i+1:]
+		j := findHemi(tokens, "EW")
+		if j < 0 {
+			v := ""
+			if len(tokens) > 0 {
+				v = tokens[0].text
+			}
+			return Coord{}, &CoordError{Field: "longitude", Value: v, Err: ErrCoordFormat}
+		}
+		rv.Lon, lonPrec, err = parseAngle("longitude", tokens[:j], tokens[j].text)
+		if err != nil {
+			return Coord{}, err
+		}
+	} else {
+		if len(tokens) < 2 || tokens[1].kind != numberToken {
+			return Coord{}, &CoordError{Field: "coordinate", Value: tokens[0].text, Err: ErrCoordFormat}
+		}
+		if len(tokens) > 2 && tokens[2].kind != blankToken && tokens[2].kind != otherToken {
+			return Coord{}, &CoordError{Field: "coordinate", Value: tokens[2].text, Err: ErrCoordFormat}
+		}
+		rv.Lat, latPrec, err = parseAngle("latitude", tokens[:1], "")
+		if err != nil {
+			return Coord{}, err
+		}
+		rv.Lon, lonPrec, err = parseAngle("longitude", tokens[1:2], "")
+		if err != nil {
+			return Coord{}, err
+		}
+	}
+	rv.Precision = math.Max(latPrec, lonPrec)
 
-	rv := Coord{
-		Lat: lat,
-		Lon: lon,
+	if math.Abs(rv.Lat) > 90 {
+		return rv, &CoordError{Field: "latitude", Value: strconv.FormatFloat(rv.Lat, 'g', -1, 64), Err: ErrCoordRange}
+	}
+	if math.Abs(rv.Lon) > 180 {
+		return rv, &CoordError{Field: "longitude", Value: strconv.FormatFloat(rv.Lon, 'g', -1, 64), Err: ErrCoordRange}
 	}
+	return rv, nil
+}
 
-	return rv, err
+// CoordParams are the coordinate parameters that may follow a
+// coordinate, e.g. "type:city(50000)_region:US-NY_scale:50000".
+type CoordParams struct {
+	// Type is the kind of place, e.g. "city", "mountain" or
+	// "landmark".
+	Type string
+	// Population is the population given with a city type.
+	Population int
+	// Region is an ISO 3166 country or subdivision code.
+	Region string
+	// Scale is the map scale (e.g. 50000 for 1:50000), or 0.
+	Scale int
+	// Dim is the approximate size of the place in meters, or 0.
+	Dim float64
+	// Globe is the lowercased body the place is on, or "" for
+	// earth.
+	Globe  string
+	Source string
 }
 
-func parseFloat(parts []string) (Coord, error) {
-	if len(parts) < 2 {
-		return Coord{}, ErrNoCoordFound
-	}
+// Default map scales for place types, per
+// http://en.wikipedia.org/wiki/Template:Coord#type:T
+var coordTypeScales = map[string]int{
+	"country": 10000000, "satellite": 10000000, "state": 3000000,
+	"adm1st": 1000000, "adm2nd": 300000, "adm3rd": 100000,
+	"city": 100000, "airport": 30000, "mountain": 100000,
+	"isle": 100000, "waterbody": 100000, "forest": 50000,
+	"river": 100000, "glacier": 300000, "event": 50000, "edu": 10000,
+	"pass": 10000, "railwaystation": 10000, "landmark": 10000,
+}
 
-	offset := 0
+// OnEarth reports whether the coordinate is on earth.
+func (p CoordParams) OnEarth() bool {
+	return p.Globe == "" || p.Globe == "earth"
+}
 
-	lat, err := strconv.ParseFloat(parts[offset], 64)
-	if err != nil {
-		return Coord{}, err
+// MapScale gets a map scale suitable for showing the place, from
+// the explicit scale, the place's dimension, or its type.
+func (p CoordParams) MapScale() int {
+	switch {
+	case p.Scale > 0:
+		return p.Scale
+	case p.Dim > 0:
+		return int(p.Dim * 10)
+	case p.Type == "city" && p.Population > 0:
+		switch {
+		case p.Population >= 1000000:
+			return 200000
+		case p.Population < 100000:
+			return 50000
+		}
 	}
-	offset++
-
-	switch parts[offset] {
-	case "S":
-		lat = -lat
-		fallthrough
-	case "N":
-		offset++
+	if s, ok := coordTypeScales[p.Type]; ok {
+		return s
 	}
+	return 300000
+}
 
-	lon, err := strconv.ParseFloat(parts[offset], 64)
-	offset++
-	if len(parts) > offset && parts[offset] == "W" {
-		lon = -lon
+// parseCoordDim parses a dim value such as "10km" into meters.
+func parseCoordDim(s string) float64 {
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(s, "km"):
+		s, mult = strings.TrimSuffix(s, "km"), 1000
+	case strings.HasSuffix(s, "m"):
+		s = strings.TrimSuffix(s, "m")
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0
 	}
-	return Coord{Lat: lat, Lon: lon}, err
+	return f * mult
 }
 
-func cleanCoordParts(in []string) []string {
-	out := make([]string, 0, len(in))
-
-	firstnumber := 0
-	var part string
-	for firstnumber, part = range in {
-		_, e := strconv.ParseFloat(part, 64)
-		if e == nil {
-			break
+func (p *CoordParams) set(key, val string) {
+	key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+	switch key {
+	case "type":
+		p.Type = strings.ToLower(val)
+		if i := strings.IndexByte(val, '('); i >= 0 {
+			p.Type = strings.ToLower(val[:i])
+			p.Population, _ = strconv.Atoi(strings.Replace(
+				strings.TrimSuffix(val[i+1:], ")"), ",", "", -1))
 		}
+	case "region":
+		p.Region = strings.ToUpper(val)
+	case "scale":
+		p.Scale, _ = strconv.Atoi(val)
+	case "dim":
+		p.Dim = parseCoordDim(strings.ToLower(val))
+	case "globe":
+		if p.Globe = strings.ToLower(val); p.Globe == "earth" {
+			p.Globe = ""
+		}
+	case "source":
+		p.Source = val
 	}
+}
 
-	for _, p := range in[firstnumber:] {
-		t := strings.TrimSpace(p)
-		if t != "" {
-			out = append(out, t)
+// parseCoordParams finds coordinate parameters within the parts of a
+// coord template.  Parameters are either in the underscore separated
+// "key:value" form or given as named "key=value" parts.
+func parseCoordParams(parts []string) CoordParams {
+	var rv CoordParams
+	for _, part := range parts {
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			rv.set(part[:i], part[i+1:])
+			continue
+		}
+		if !strings.Contains(part, ":") {
+			continue
+		}
+		for _, kv := range strings.Split(part, "_") {
+			if i := strings.IndexByte(kv, ':'); i >= 0 {
+				rv.set(kv[:i], kv[i+1:])
+			}
 		}
 	}
-
-	return out
+	return rv
 }
 
 // ParseCoords parses geographical coordinates as specified in
 // http://en.wikipedia.org/wiki/Wikipedia:WikiProject_Geographical_coordinates
+//
+// Coordinates on other globes are returned along with
+// ErrNonEarthGlobe.
 func ParseCoords(text string) (Coord, error) {
 	cleaned := nowikiRE.ReplaceAllString(commentRE.ReplaceAllString(text, ""), "")
 	matches := coordRE.FindAllStringSubmatch(cleaned, 1)
@@ -186,22 +425,236 @@ func ParseCoords(text string) (Coord, error) {
 		return Coord{}, ErrNoCoordFound
 	}
 
-	parts := cleanCoordParts(strings.Split(matches[0][1], "|"))
+	parts := strings.Split(matches[0][1], "|")
+	rv, err := parseCoordParts(parts)
+	if err == nil && !parseCoordParams(parts).OnEarth() {
+		err = ErrNonEarthGlobe
+	}
+	return rv, err
+}
+
+// CoordSource describes where in an article a coordinate came from.
+type CoordSource int
+
+const (
+	// CoordTemplate is a {{coord}} template, or one of the older
+	// {{coor}} family.
+	CoordTemplate CoordSource = iota
+	// InfoboxFields is a set of latitude and longitude parameters of
+	// an infobox, e.g. latd, latm, latNS, longd, ...
+	InfoboxFields
+	// LocationMapTemplate is the lat and long parameters of a
+	// {{Location map}} template.
+	LocationMapTemplate
+	// GeoTemplate is a {{geo}} template.
+	GeoTemplate
+)
 
-	rv, err := parseSexagesimal(parts)
-	if err != nil {
-		rv, err = parseSexagesimal2(parts)
-		if err != nil {
-			rv, err = parseFloat(parts)
+func (s CoordSource) String() string {
+	switch s {
+	case CoordTemplate:
+		return "coord"
+	case InfoboxFields:
+		return "infobox"
+	case LocationMapTemplate:
+		return "location map"
+	case GeoTemplate:
+		return "geo"
+	}
+	return "CoordSource(" + strconv.Itoa(int(s)) + ")"
+}
+
+// A PageCoord is a coordinate found within an article.
+type PageCoord struct {
+	Coord
+	// Display is where the coordinate is shown, "inline", "title",
+	// or both (e.g. "inline,title").
+	Display string
+	// Name is the name of the place, if given.
+	Name   string
+	Params CoordParams
+	// Source is the kind of markup the coordinate came from, and
+	// Template is the name of the template.
+	Source   CoordSource
+	Template string
+	// Pos and End are the byte range of the coordinate's template.
+	Pos, End int
+}
+
+// IsTitle reports whether this is the article's primary coordinate,
+// displayed by the title.
+func (c PageCoord) IsTitle() bool {
+	return c.hasDisplay("title", "t")
+}
+
+// IsInline reports whether this coordinate is displayed within the
+// article's text.
+func (c PageCoord) IsInline() bool {
+	return c.Display == "" || c.hasDisplay("inline", "i")
+}
+
+func (c PageCoord) hasDisplay(long, short string) bool {
+	for _, d := range strings.Split(strings.ToLower(c.Display), ",") {
+		d = strings.TrimSpace(d)
+		if d == long || d == short || d == "it" || d == "ti" {
+			return true
 		}
 	}
+	return false
+}
 
-	if math.Abs(rv.Lat) > 90 {
-		return rv, fmt.Errorf("invalid latitude: %v", rv.Lat)
+// Infobox parameter names for the degrees, minutes, seconds and
+// hemisphere of latitude and then longitude.
+var coordFieldSets = [][8]string{
+	{"latd", "latm", "lats", "latNS", "longd", "longm", "longs", "longEW"},
+	{"lat_d", "lat_m", "lat_s", "lat_NS", "long_d", "long_m", "long_s", "long_EW"},
+	{"lat_deg", "lat_min", "lat_sec", "lat_dir", "lon_deg", "lon_min", "lon_sec", "lon_dir"},
+	{"lat_degrees", "lat_minutes", "lat_seconds", "lat_direction",
+		"long_degrees", "long_minutes", "long_seconds", "long_direction"},
+}
+
+// Infobox parameter names for decimal latitude and longitude.
+var coordDecimalFields = [][2]string{
+	{"latitude", "longitude"},
+	{"lat", "long"},
+	{"lat", "lon"},
+	{"lat_dec", "long_dec"},
+}
+
+// coordTemplateSource reports whether a template holds its
+// coordinate in positional parameters like {{coord}}, and if so, how
+// it's displayed by default.
+func coordTemplateSource(name string) (CoordSource, string, bool) {
+	lower := strings.ToLower(name)
+	switch {
+	case lower == "coord":
+		return CoordTemplate, "", true
+	case strings.HasPrefix(lower, "coor title "):
+		return CoordTemplate, "title", true
+	case strings.HasPrefix(lower, "coor "):
+		return CoordTemplate, "", true
+	case lower == "geo":
+		return GeoTemplate, "", true
 	}
-	if math.Abs(rv.Lon) > 180 {
-		return rv, fmt.Errorf("invalid longitude: %v", rv.Lon)
+	return 0, "", false
+}
+
+// templateCoord parses a template with {{coord}} style positional
+// parameters.
+func templateCoord(n *Node) (PageCoord, error) {
+	var parts, all []string
+	for _, p := range n.Params {
+		if _, err := strconv.Atoi(p.Name); err == nil {
+			parts = append(parts, p.Raw)
+			all = append(all, p.Raw)
+		} else {
+			all = append(all, p.Name+"="+p.Raw)
+		}
 	}
+	c, err := parseCoordParts(parts)
+	return PageCoord{
+		Coord:   c,
+		Display: n.ParamText("display"),
+		Name:    n.ParamText("name"),
+		Params:  parseCoordParams(all),
+	}, err
+}
 
-	return rv, err
+// fieldCoord parses latitude and longitude given as separate
+// parameters of a template.
+func fieldCoord(n *Node) (PageCoord, bool) {
+	field := func(name string) string {
+		return strings.TrimSpace(n.ParamText(name))
+	}
+	for _, set := range coordFieldSets {
+		if field(set[0]) == "" || field(set[4]) == "" {
+			continue
+		}
+		var parts []string
+		for i, name := range set {
+			v := strings.ToUpper(field(name))
+			switch {
+			case v != "" || i%4 != 3:
+				parts = append(parts, v)
+			case i == 3:
+				parts = append(parts, "N")
+			default:
+				parts = append(parts, "E")
+			}
+		}
+		if c, err := parseCoordParts(parts); err == nil {
+			return PageCoord{Coord: c}, true
+		}
+	}
+	for _, set := range coordDecimalFields {
+		lat, lon := field(set[0]), field(set[1])
+		if lat == "" || lon == "" {
+			continue
+		}
+		if c, err := parseCoordParts([]string{lat, lon}); err == nil {
+			return PageCoord{Coord: c}, true
+		}
+	}
+	return PageCoord{}, false
+}
+
+// FindCoords finds all of the {{coord}} coordinates in an article, in
+// the order they appear.  Coordinates that can't be parsed are
+// skipped, while those on other globes are included; check
+// Params.OnEarth.
+func FindCoords(text string) []PageCoord {
+	var rv []PageCoord
+	Walk(ParseWikitext(text), func(n *Node) bool {
+		if n.Kind == CommentNode {
+			return false
+		}
+		if n.Kind != TemplateNode || n.TemplateName() != "Coord" {
+			return true
+		}
+		if c, err := templateCoord(n); err == nil {
+			c.Template, c.Pos, c.End = n.TemplateName(), n.Pos, n.End
+			rv = append(rv, c)
+		}
+		return true
+	})
+	return rv
+}
+
+// FindLocations finds coordinates in all of the common forms used in
+// articles: {{coord}} and the older {{coor}} templates, {{geo}},
+// latitude and longitude parameters of infoboxes, and {{Location
+// map}}.  Check each coordinate's Source to see where it came from.
+func FindLocations(text string) []PageCoord {
+	var rv []PageCoord
+	Walk(ParseWikitext(text), func(n *Node) bool {
+		if n.Kind == CommentNode {
+			return false
+		}
+		if n.Kind != TemplateNode {
+			return true
+		}
+		name := n.TemplateName()
+		var c PageCoord
+		ok := false
+		if src, display, isCoord := coordTemplateSource(name); isCoord {
+			var err error
+			c, err = templateCoord(n)
+			ok = err == nil
+			c.Source = src
+			if c.Display == "" {
+				c.Display = display
+			}
+		} else if c, ok = fieldCoord(n); ok {
+			c.Source = InfoboxFields
+			if strings.HasPrefix(strings.ToLower(name), "location map") {
+				c.Source = LocationMapTemplate
+			}
+		}
+		if ok {
+			c.Template, c.Pos, c.End = name, n.Pos, n.End
+			rv = append(rv, c)
+		}
+		return true
+	})
+	return rv
 }
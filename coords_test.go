@@ -1,6 +1,7 @@
 package wikiparse
 
 import (
+	"errors"
 	"math"
 	"strings"
 	"testing"
@@ -128,18 +129,18 @@ var testdata = []testinput{
 		0,
 		"no coord data found",
 	},
-	// The following two fall back onto float parsing
+	// Unknown hemispheres are errors rather than a guess.
 	testinput{
 		"{{coord|27|59|16|J|86|56|40|E}}",
-		27,
-		59,
-		"",
+		0,
+		0,
+		"invalid coordinate: 16",
 	},
 	testinput{
 		"{{coord|27|59|16|N|86|56|40|J}}",
-		27,
-		59,
-		"",
+		0,
+		0,
+		"invalid longitude: 86",
 	},
 	testinput{
 		"{{Coord|50|40|N|1|16|W|region:GB_type:isle|display=title, inline}}",
@@ -205,45 +206,271 @@ func TestCoordMultiline(t *testing.T) {
 	}
 }
 
-func TestDMS(t *testing.T) {
+func TestCoordGrammar(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		parts     string
+		lat, lon  float64
+		precision float64
+	}{
+		// Signed decimal degrees.
+		{"1|2", 1, 2, 1},
+		{"41.762736| -72.674286", 41.762736, -72.674286, 0.000001},
+		{"-33.8568|151.2153", -33.8568, 151.2153, 0.0001},
+		{"−33.86|151.2", -33.86, 151.2, 0.1},
+		{"+12.5|+8.25", 12.5, 8.25, 0.1},
+		// Degrees with hemispheres.
+		{"45|N|114|W", 45, -114, 1},
+		{"29.5734571|N|2.3730469|E", 29.5734571, 2.3730469, 0.0000001},
+		{"12|s|77|w", -12, -77, 1},
+		// Degrees and minutes.
+		{"50|40|N|1|16|W", 50.666667, -1.266667, 1.0 / 60},
+		{"40|26.767|N|79|58.933|W", 40.446117, -79.982217, 0.001 / 60},
+		{"42||N|82||W", 42, -82, 1},
+		// Degrees, minutes and seconds.
+		{"27|59|16|N|86|56|40|E", 27.987778, 86.944444, 1.0 / 3600},
+		{"40|26|46.302|N|79|58|56.6|W", 40.446195, -79.982389, 0.1 / 3600},
+		{"57|18||N|4|27|30|W", 57.3, -4.458333, 1.0 / 60},
+		{"0|0|0|N|0|0|0|E", 0, 0, 1.0 / 3600},
+		// Negative degrees are redundant with S or W.
+		{"-33|52|S|-151|12|W", -33.866667, -151.2, 1.0 / 60},
+		{"-0|30|S|0|30|W", -0.5, -0.5, 1.0 / 60},
+		// Symbols within parts.
+		{"40°26′46″N|79°58′56″W", 40.446111, -79.982222, 1.0 / 3600},
+		{"40°26′46″N 79°58′56″W", 40.446111, -79.982222, 1.0 / 3600},
+		{`40° 26' 46" N, 79° 58' 56" W`, 40.446111, -79.982222, 1.0 / 3600},
+		{"51.5°N|0.13°W", 51.5, -0.13, 0.1},
+		// Leading junk and trailing parameters.
+		{"display=title|41|N|72|W|type:city", 41, -72, 1},
+		{"44.4365|-63.5636|region:CA-NS_type:landmark", 44.4365, -63.5636, 0.0001},
+		{"90|S|180|E", -90, 180, 1},
+	}
+	for _, test := range tests {
+		c, err := parseCoordParts(strings.Split(test.parts, "|"))
+		if err != nil {
+			t.Errorf("Unexpected error on %v: %v", test.parts, err)
+			continue
+		}
+		assertEpsilon(t, test.parts, "lat", test.lat, c.Lat)
+		assertEpsilon(t, test.parts, "lon", test.lon, c.Lon)
+		if math.Abs(c.Precision-test.precision) > 1e-12 {
+			t.Errorf("Expected precision %v for %v, got %v", test.precision, test.parts, c.Precision)
+		}
+	}
+}
+
+func TestCoordGrammarErrors(t *testing.T) {
+	t.Parallel()
 	tests := []struct {
-		input   []string
-		exp     float64
-		success bool
+		parts string
+		err   error
+		msg   string
+	}{
+		{"13", ErrCoordFormat, "invalid coordinate: 13"},
+		{"1|2|3", ErrCoordFormat, "invalid coordinate: 3"},
+		{"1||2", ErrCoordFormat, "invalid coordinate: 1"},
+		{"27|59|16|N|86|56|40", ErrCoordFormat, "invalid longitude: 86"},
+		{"27|59|16|N|86|56|40|N", ErrCoordFormat, "invalid longitude: 86"},
+		{"1|2|3|4|N|5|E", ErrCoordFormat, "invalid coordinate: 3"},
+		{"|N|5|E", ErrCoordFormat, "invalid coordinate: 5"},
+		{"0|X|0|N|0|0|0|W", ErrCoordValue, "invalid latitude: X"},
+		{"foo|59|foo|N|86|56|40|S", ErrCoordValue, "invalid latitude: foo"},
+		{"10|60|N|20|E", ErrCoordValue, "invalid latitude: 60"},
+		{"10|5|-3|N|20|E", ErrCoordValue, "invalid latitude: -3"},
+		{"10.5|30|N|20|E", ErrCoordValue, "invalid latitude: 10.5"},
+		{"10|20.5|30|N|20|E", ErrCoordValue, "invalid latitude: 20.5"},
+		{"1.2.3|4", ErrCoordValue, "invalid latitude: 1.2.3"},
+		{"-10|30|N|20|E", ErrCoordSign, "invalid latitude: -10"},
+		{"10|N|-20|E", ErrCoordSign, "invalid longitude: -20"},
+		{"97|59|16|S|86|56|40|W", ErrCoordRange, "invalid latitude: -97.98"},
+		{"27|S|186|W", ErrCoordRange, "invalid longitude: -186"},
+		{"91|0", ErrCoordRange, "invalid latitude: 91"},
+	}
+	for _, test := range tests {
+		c, err := parseCoordParts(strings.Split(test.parts, "|"))
+		if !errors.Is(err, test.err) {
+			t.Errorf("Expected %v for %v, got %v (%+v)", test.err, test.parts, err, c)
+			continue
+		}
+		var cerr *CoordError
+		if !errors.As(err, &cerr) {
+			t.Errorf("Expected a CoordError for %v, got %T", test.parts, err)
+		}
+		if !strings.HasPrefix(err.Error(), test.msg) {
+			t.Errorf("Expected %q for %v, got %q", test.msg, test.parts, err)
+		}
+	}
+
+	if _, err := parseCoordParts([]string{"type:city"}); err != ErrNoCoordFound {
+		t.Errorf("Expected %v, got %v", ErrNoCoordFound, err)
+	}
+}
+
+const lighthouses = `{{Coord|44|38|N|63|34|W|display=title|region:CA-NS}}
+{| class="wikitable"
+! Name !! Location
+|-
+| Sambro Island || {{coord|44.4365|-63.5636|name=Sambro Island Light}}
+|-
+| Peggy's Point || {{coord|44|29|30|N|63|55|8|W|display=inline|name=Peggys Point Lighthouse}}
+|-
+| Broken || {{coord|foo|bar}}
+|-
+| Both || {{coord|45|N|64|W|display=it}}
+|}
+<!-- {{coord|1|2}} --><nowiki>{{coord|3|4}}</nowiki>`
+
+func TestFindCoords(t *testing.T) {
+	t.Parallel()
+	found := FindCoords(lighthouses)
+	exp := []struct {
+		lat, lon      float64
+		name          string
+		title, inline bool
 	}{
-		{nil, 0, false},
-		{[]string{"x", "0", "0", "0"}, 0, false},
-		{[]string{"0", "x", "0", "0"}, 0, false},
-		{[]string{"0", "0", "x", "0"}, 0, false},
-		{[]string{"0", "0", "0", "0"}, 0, true},
+		{44.633333, -63.566667, "", true, false},
+		{44.4365, -63.5636, "Sambro Island Light", false, true},
+		{44.491667, -63.918889, "Peggys Point Lighthouse", false, true},
+		{45, -64, "", true, true},
+	}
+	if len(found) != len(exp) {
+		t.Fatalf("Expected %v coords, got %v: %+v", len(exp), len(found), found)
+	}
+	for i, e := range exp {
+		c := found[i]
+		assertEpsilon(t, lighthouses, "lat", e.lat, c.Lat)
+		assertEpsilon(t, lighthouses, "lon", e.lon, c.Lon)
+		if c.Name != e.name || c.IsTitle() != e.title || c.IsInline() != e.inline {
+			t.Errorf("Expected %+v, got %+v", e, c)
+		}
+		if !strings.HasPrefix(strings.ToLower(lighthouses[c.Pos:c.End]), "{{coord|") {
+			t.Errorf("Wrong range for %+v: %q", c, lighthouses[c.Pos:c.End])
+		}
+	}
+}
+
+func TestFindCoordsMatchesParseCoords(t *testing.T) {
+	t.Parallel()
+	for _, ti := range testdata {
+		if ti.err != "" {
+			continue
+		}
+		found := FindCoords(ti.input)
+		if len(found) != 1 {
+			t.Errorf("Expected one coord from %v, got %v", ti.input, found)
+			continue
+		}
+		assertEpsilon(t, ti.input, "lat", ti.lat, found[0].Lat)
+		assertEpsilon(t, ti.input, "lon", ti.lon, found[0].Lon)
 	}
+}
 
+func TestCoordParams(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in    string
+		exp   CoordParams
+		scale int
+	}{
+		{"{{coord|61.1631|-149.9721|type:landmark_globe:earth_region:US-AK_scale:150000_source:gnis|name=Kulis}}",
+			CoordParams{Type: "landmark", Region: "US-AK", Scale: 150000, Source: "gnis"}, 150000},
+		{"{{coord|40|43|N|74|0|W|type:city(8,336,817)_region:us-ny}}",
+			CoordParams{Type: "city", Population: 8336817, Region: "US-NY"}, 200000},
+		{"{{coord|1|2|dim:2.5km}}", CoordParams{Dim: 2500}, 25000},
+		{"{{coord|1|2|dim:300}}", CoordParams{Dim: 300}, 3000},
+		{"{{coord|1|2|type:mountain}}", CoordParams{Type: "mountain"}, 100000},
+		{"{{coord|1|2}}", CoordParams{}, 300000},
+		{"{{coord|1|2|globe:moon_type:landmark}}", CoordParams{Type: "landmark", Globe: "moon"}, 10000},
+		{"{{coord|1|2|globe=Mars|type=crater}}", CoordParams{Type: "crater", Globe: "mars"}, 300000},
+	}
 	for _, test := range tests {
-		f, err := dms(test.input)
-		if test.success && err != nil {
-			t.Errorf("Unexpected failure on %v: %v", test.input, err)
-		} else if test.success && f != test.exp {
-			t.Errorf("Expected %v for %v, got %v", test.exp, test.input, f)
+		found := FindCoords(test.in)
+		if len(found) != 1 {
+			t.Errorf("Expected one coord in %v, got %v", test.in, found)
+			continue
+		}
+		p := found[0].Params
+		if p != test.exp {
+			t.Errorf("Expected %+v for %v, got %+v", test.exp, test.in, p)
+		}
+		if p.OnEarth() != (test.exp.Globe == "") {
+			t.Errorf("Wrong OnEarth for %v", test.in)
 		}
+		if p.MapScale() != test.scale {
+			t.Errorf("Expected scale %v for %v, got %v", test.scale, test.in, p.MapScale())
+		}
+	}
+}
+
+func TestParseCoordsNonEarth(t *testing.T) {
+	t.Parallel()
+	c, err := ParseCoords("{{coord|9.7|S|20.1|W|globe:moon_type:landmark}}")
+	if err != ErrNonEarthGlobe {
+		t.Fatalf("Expected non-earth error, got %v", err)
 	}
+	assertEpsilon(t, "moon", "lat", -9.7, c.Lat)
+	assertEpsilon(t, "moon", "lon", -20.1, c.Lon)
 }
 
-func TestFloatErrorCases(t *testing.T) {
-	tests := [][]string{
-		[]string{"13"},
-		[]string{"X", "N"},
+func TestFindLocations(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in       string
+		lat, lon float64
+		src      CoordSource
+		template string
+	}{
+		{"{{Infobox settlement\n| name = Peggys Cove\n| latd = 44 |latm = 29 |lats = 32 |latNS = N\n| longd = 63 |longm = 55 |longs = 8 |longEW = W\n}}",
+			44.492222, -63.918889, InfoboxFields, "Infobox settlement"},
+		{"{{Infobox lake|lat_d=46|lat_m=30|lat_NS=s|long_d=170|long_m=15|long_EW=E}}",
+			-46.5, 170.25, InfoboxFields, "Infobox lake"},
+		{"{{Infobox park|lat_deg=40|lat_min=46|lat_dir=N|lon_deg=73|lon_min=58|lon_dir=W}}",
+			40.766667, -73.966667, InfoboxFields, "Infobox park"},
+		{"{{Infobox building|latitude=51.5007|longitude=-0.1246}}",
+			51.5007, -0.1246, InfoboxFields, "Infobox building"},
+		{"{{Location map|Italy|lat=41.9|long=12.5|caption=Rome}}",
+			41.9, 12.5, LocationMapTemplate, "Location map"},
+		{"{{Location map+|France|lat_deg=48|lat_min=51|lon_deg=2|lon_min=21}}",
+			48.85, 2.35, LocationMapTemplate, "Location map+"},
+		{"{{geo|35.3606|138.7274}}", 35.3606, 138.7274, GeoTemplate, "Geo"},
+		{"{{coor dms|40|26|46|N|79|58|56|W}}", 40.446111, -79.982222, CoordTemplate, "Coor dms"},
+		{"{{Infobox mountain|name=Fuji|coordinates={{coord|35|21|29|N|138|43|52|E}}}}",
+			35.358056, 138.731111, CoordTemplate, "Coord"},
 	}
 	for _, test := range tests {
-		f, err := parseFloat(test)
-		if err == nil {
-			t.Errorf("expected error for %v, got %v", test, f)
+		found := FindLocations(test.in)
+		if len(found) != 1 {
+			t.Errorf("Expected one location in %v, got %+v", test.in, found)
+			continue
 		}
+		c := found[0]
+		assertEpsilon(t, test.in, "lat", test.lat, c.Lat)
+		assertEpsilon(t, test.in, "lon", test.lon, c.Lon)
+		if c.Source != test.src || c.Template != test.template {
+			t.Errorf("Expected %v from %q for %v, got %v from %q",
+				test.src, test.template, test.in, c.Source, c.Template)
+		}
+		if test.in[c.Pos:c.End] == "" || !strings.HasPrefix(test.in[c.Pos:], "{{") {
+			t.Errorf("Wrong range for %v: %v-%v", test.in, c.Pos, c.End)
+		}
+	}
+}
+
+func TestFindLocationsSkipsEmpty(t *testing.T) {
+	t.Parallel()
+	in := "{{Infobox settlement|latd=|latm=|latNS=|longd=|longm=|longEW=|coordinates=}}{{Location map|Canada}}"
+	if found := FindLocations(in); len(found) != 0 {
+		t.Errorf("Expected no locations, got %+v", found)
 	}
 }
 
-func TestSexagesimalDMSErr(t *testing.T) {
-	v, err := parseSexagesimal([]string{"0", "X", "0", "N", "0", "0", "0", "W"})
-	if err == nil {
-		t.Fatalf("Expected error parsing junk, got %v", v)
+func TestFindLocationsTitle(t *testing.T) {
+	t.Parallel()
+	found := FindLocations("{{coor title dm|44|38|N|63|34|W}}")
+	if len(found) != 1 || !found[0].IsTitle() || found[0].IsInline() {
+		t.Errorf("Expected a title coordinate, got %+v", found)
+	}
+	if s := InfoboxFields.String(); s != "infobox" {
+		t.Errorf("Expected infobox, got %v", s)
 	}
 }
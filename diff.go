@@ -0,0 +1,436 @@
+package wikiparse
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxDiffEdits bounds the work (and memory) spent diffing.  Changed
+// regions needing more edits than this are reported as a deletion of
+// the old text followed by an insertion of the new.
+const maxDiffEdits = 1000
+
+// A DiffOp is what a DiffChunk does.
+type DiffOp int
+
+const (
+	// DiffEqual is text in both revisions.
+	DiffEqual DiffOp = iota
+	// DiffInsert is text only in the newer revision.
+	DiffInsert
+	// DiffDelete is text only in the older revision.
+	DiffDelete
+)
+
+func (o DiffOp) String() string {
+	switch o {
+	case DiffEqual:
+		return "="
+	case DiffInsert:
+		return "+"
+	case DiffDelete:
+		return "-"
+	}
+	return "?"
+}
+
+// A DiffChunk is a run of text that's equal, inserted or deleted.
+type DiffChunk struct {
+	Op   DiffOp
+	Text string
+}
+
+// A Diff is the sequence of chunks turning one text into another.
+// Adjacent chunks never have the same Op.
+type Diff []DiffChunk
+
+// Old gets the older text back from a diff.
+func (d Diff) Old() string {
+	return d.join(DiffDelete)
+}
+
+// New gets the newer text back from a diff.
+func (d Diff) New() string {
+	return d.join(DiffInsert)
+}
+
+func (d Diff) join(op DiffOp) string {
+	var b strings.Builder
+	for _, c := range d {
+		if c.Op == DiffEqual || c.Op == op {
+			b.WriteString(c.Text)
+		}
+	}
+	return b.String()
+}
+
+// Chunks gets the text of the chunks with the given Op.
+func (d Diff) Chunks(op DiffOp) []string {
+	var rv []string
+	for _, c := range d {
+		if c.Op == op {
+			rv = append(rv, c.Text)
+		}
+	}
+	return rv
+}
+
+// A diffRun is n tokens that are equal, inserted or deleted.
+type diffRun struct {
+	op DiffOp
+	n  int
+}
+
+func addRun(runs []diffRun, op DiffOp, n int) []diffRun {
+	if n == 0 {
+		return runs
+	}
+	if l := len(runs); l > 0 && runs[l-1].op == op {
+		runs[l-1].n += n
+		return runs
+	}
+	return append(runs, diffRun{op, n})
+}
+
+// toDiff joins the tokens of each run into chunks.
+func toDiff(runs []diffRun, a, b []string) Diff {
+	var d Diff
+	for _, r := range runs {
+		var toks []string
+		switch r.op {
+		case DiffInsert:
+			toks, b = b[:r.n], b[r.n:]
+		case DiffDelete:
+			toks, a = a[:r.n], a[r.n:]
+		default:
+			toks, a, b = a[:r.n], a[r.n:], b[r.n:]
+		}
+		d = append(d, DiffChunk{r.op, strings.Join(toks, "")})
+	}
+	return d
+}
+
+// diffTokens diffs two token sequences with Myers' algorithm.
+func diffTokens(a, b []string) []diffRun {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	runs := addRun(nil, DiffEqual, pre)
+	runs = myers(runs, a[pre:len(a)-suf], b[pre:len(b)-suf])
+	return addRun(runs, DiffEqual, suf)
+}
+
+func myers(runs []diffRun, a, b []string) []diffRun {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return addRun(addRun(runs, DiffDelete, n), DiffInsert, m)
+	}
+
+	max := n + m
+	if max > maxDiffEdits {
+		max = maxDiffEdits
+	}
+	// v[k] is the furthest x reached on diagonal k = x-y, and
+	// trace[e] is v[-e:e+1] after e edits.
+	off := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for e := 0; e <= max; e++ {
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || (k != e && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(runs, n, m, trace, e)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[off-e:off+e+1]...))
+	}
+	return addRun(addRun(runs, DiffDelete, n), DiffInsert, m)
+}
+
+// backtrack follows the path found by myers back from the end of
+// sequences of n and m tokens, where e edits were needed.
+func backtrack(runs []diffRun, n, m int, trace [][]int, e int) []diffRun {
+	var steps []diffRun
+	x, y := n, m
+	for ; e > 0; e-- {
+		prev := trace[e-1]
+		at := func(k int) int { return prev[k+e-1] }
+		k := x - y
+		var px, py int
+		if k == -e || (k != e && at(k-1) < at(k+1)) {
+			px = at(k + 1)
+			py = px - k - 1
+			steps = append(steps, diffRun{DiffEqual, x - px}, diffRun{DiffInsert, 1})
+		} else {
+			px = at(k - 1)
+			py = px - k + 1
+			steps = append(steps, diffRun{DiffEqual, x - px - 1}, diffRun{DiffDelete, 1})
+		}
+		x, y = px, py
+	}
+	steps = append(steps, diffRun{DiffEqual, x})
+	for i := len(steps) - 1; i >= 0; i-- {
+		runs = addRun(runs, steps[i].op, steps[i].n)
+	}
+	return runs
+}
+
+// splitLines splits text into lines, keeping their newlines.
+func splitLines(text string) []string {
+	rv := strings.SplitAfter(text, "\n")
+	if rv[len(rv)-1] == "" {
+		rv = rv[:len(rv)-1]
+	}
+	return rv
+}
+
+// splitWords splits text into words, runs of whitespace and single
+// punctuation characters.
+func splitWords(text string) []string {
+	var rv []string
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		var class func(rune) bool
+		switch {
+		case unicode.IsSpace(r):
+			class = unicode.IsSpace
+		case isWordRune(r):
+			class = isWordRune
+		}
+		if class != nil {
+			for size < len(text) {
+				r, n := utf8.DecodeRuneInString(text[size:])
+				if !class(r) {
+					break
+				}
+				size += n
+			}
+		}
+		rv = append(rv, text[:size])
+		text = text[size:]
+	}
+	return rv
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// A textDiff is the line and word diffs of two texts.  Words are
+// split line by line, so no word crosses a line.
+type textDiff struct {
+	oldLines, newLines []string
+	lines              []diffRun
+	oldWords, newWords []string
+	words              []diffRun
+	// newCounts is the number of words in each of the new lines.
+	newCounts []int
+}
+
+// diffText diffs two texts line by line, then word by word.  Only the
+// lines that changed are compared word by word, so unrelated edits far
+// apart don't get matched up.
+func diffText(old, new string) *textDiff {
+	td := &textDiff{oldLines: splitLines(old), newLines: splitLines(new)}
+	td.lines = diffTokens(td.oldLines, td.newLines)
+
+	oldCounts := make([]int, len(td.oldLines))
+	for i, l := range td.oldLines {
+		w := splitWords(l)
+		oldCounts[i] = len(w)
+		td.oldWords = append(td.oldWords, w...)
+	}
+	td.newCounts = make([]int, len(td.newLines))
+	for i, l := range td.newLines {
+		w := splitWords(l)
+		td.newCounts[i] = len(w)
+		td.newWords = append(td.newWords, w...)
+	}
+	sum := func(counts []int) int {
+		n := 0
+		for _, c := range counts {
+			n += c
+		}
+		return n
+	}
+
+	var ol, nl, ow, nw int // line and word positions
+	for i := 0; i < len(td.lines); i++ {
+		if r := td.lines[i]; r.op == DiffEqual {
+			n := sum(td.newCounts[nl : nl+r.n])
+			td.words = addRun(td.words, DiffEqual, n)
+			ol, nl, ow, nw = ol+r.n, nl+r.n, ow+n, nw+n
+			continue
+		}
+		var dl, il int
+		for ; i < len(td.lines) && td.lines[i].op != DiffEqual; i++ {
+			if td.lines[i].op == DiffDelete {
+				dl += td.lines[i].n
+			} else {
+				il += td.lines[i].n
+			}
+		}
+		i--
+		dw, iw := sum(oldCounts[ol:ol+dl]), sum(td.newCounts[nl:nl+il])
+		for _, r := range diffTokens(td.oldWords[ow:ow+dw], td.newWords[nw:nw+iw]) {
+			td.words = addRun(td.words, r.op, r.n)
+		}
+		ol, nl, ow, nw = ol+dl, nl+il, ow+dw, nw+iw
+	}
+	return td
+}
+
+// DiffLines diffs two texts line by line.
+func DiffLines(old, new string) Diff {
+	a, b := splitLines(old), splitLines(new)
+	return toDiff(diffTokens(a, b), a, b)
+}
+
+// DiffWords diffs two texts word by word.  Only the lines that
+// changed are compared word by word, so unrelated edits far apart
+// don't get matched up.
+func DiffWords(old, new string) Diff {
+	td := diffText(old, new)
+	return toDiff(td.words, td.oldWords, td.newWords)
+}
+
+// A RevisionDiff is what an edit changed.
+type RevisionDiff struct {
+	// From and To are the revision ids compared.  From is 0 for a
+	// page's first revision, which is compared to an empty page.
+	From, To uint64
+
+	// Lines and Words are the line and word diffs of the text.
+	Lines Diff
+	Words Diff
+	// WordsAdded and WordsRemoved count the words inserted and
+	// deleted, not counting whitespace or punctuation.
+	WordsAdded, WordsRemoved int
+
+	// The links, categories and templates the edit added and
+	// removed, sorted.  Links are canonical titles, and don't include
+	// categories.
+	LinksAdded, LinksRemoved           []string
+	CategoriesAdded, CategoriesRemoved []string
+	TemplatesAdded, TemplatesRemoved   []string
+}
+
+// revisionFeatures are the parts of a revision compared by a
+// RevisionDiff.
+type revisionFeatures struct {
+	id                           uint64
+	text                         string
+	links, categories, templates map[string]bool
+}
+
+func toSet(s []string) map[string]bool {
+	rv := make(map[string]bool, len(s))
+	for _, x := range s {
+		rv[x] = true
+	}
+	return rv
+}
+
+func newRevisionFeatures(r *Revision) *revisionFeatures {
+	if r == nil {
+		return &revisionFeatures{}
+	}
+	links := map[string]bool{}
+	for _, l := range FindLinks(r.Text) {
+		if i := strings.IndexByte(l, ':'); i >= 0 && strings.EqualFold(strings.TrimSpace(l[:i]), "category") {
+			continue
+		}
+		if t, _ := splitTarget(l); t != "" {
+			links[t] = true
+		}
+	}
+	return &revisionFeatures{
+		id:         r.ID,
+		text:       r.Text,
+		links:      links,
+		categories: toSet(FindCategories(r.Text)),
+		templates:  toSet(FindTemplates(r.Text)),
+	}
+}
+
+// setDiff gets the sorted members of a not in b.
+func setDiff(a, b map[string]bool) []string {
+	var rv []string
+	for k := range a {
+		if !b[k] {
+			rv = append(rv, k)
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func countWords(chunks []string) int {
+	n := 0
+	for _, c := range chunks {
+		for _, w := range splitWords(c) {
+			if r, _ := utf8.DecodeRuneInString(w); isWordRune(r) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func diffFeatures(old, new *revisionFeatures) RevisionDiff {
+	td := diffText(old.text, new.text)
+	lines := toDiff(td.lines, td.oldLines, td.newLines)
+	words := toDiff(td.words, td.oldWords, td.newWords)
+	return RevisionDiff{
+		From:              old.id,
+		To:                new.id,
+		Lines:             lines,
+		Words:             words,
+		WordsAdded:        countWords(words.Chunks(DiffInsert)),
+		WordsRemoved:      countWords(words.Chunks(DiffDelete)),
+		LinksAdded:        setDiff(new.links, old.links),
+		LinksRemoved:      setDiff(old.links, new.links),
+		CategoriesAdded:   setDiff(new.categories, old.categories),
+		CategoriesRemoved: setDiff(old.categories, new.categories),
+		TemplatesAdded:    setDiff(new.templates, old.templates),
+		TemplatesRemoved:  setDiff(old.templates, new.templates),
+	}
+}
+
+// DiffRevisions gets what changed from one revision to the next.  old
+// may be nil for a page's first revision.
+func DiffRevisions(old, new *Revision) RevisionDiff {
+	return diffFeatures(newRevisionFeatures(old), newRevisionFeatures(new))
+}
+
+// DiffPage calls fn with the diff of each of a page's revisions
+// against the one before, in order, stopping if fn returns an error.
+func DiffPage(p *Page, fn func(RevisionDiff) error) error {
+	prev := newRevisionFeatures(nil)
+	for i := range p.Revisions {
+		cur := newRevisionFeatures(&p.Revisions[i])
+		if err := fn(diffFeatures(prev, cur)); err != nil {
+			return err
+		}
+		prev = cur
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package wikiparse
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func (d Diff) String() string {
+	var b strings.Builder
+	for _, c := range d {
+		fmt.Fprintf(&b, "%v%q", c.Op, c.Text)
+	}
+	return b.String()
+}
+
+func TestDiffLines(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		old, new, exp string
+	}{
+		{"", "", ""},
+		{"a\nb\n", "a\nb\n", `="a\nb\n"`},
+		{"", "a\n", `+"a\n"`},
+		{"a\n", "", `-"a\n"`},
+		{"a\nb\nc\n", "a\nc\n", `="a\n"-"b\n"="c\n"`},
+		{"a\nb\nc\n", "a\nB\nc\nd", `="a\n"-"b\n"+"B\n"="c\n"+"d"`},
+		{"a\nb\nc", "c\nb\na", `-"a\n"+"c\n"="b\n"-"c"+"a"`},
+	}
+	for _, test := range tests {
+		if got := DiffLines(test.old, test.new).String(); got != test.exp {
+			t.Errorf("Expected %v for %q -> %q, got %v", test.exp, test.old, test.new, got)
+		}
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	t.Parallel()
+	d := DiffWords("A harbour city.\nUnchanged.\nIn [[Nova Scotia]].\n",
+		"A big harbour town.\nUnchanged.\nIn [[Canada]].\n")
+	exp := `="A "+"big "="harbour "-"city"+"town"=".\nUnchanged.\nIn [["-"Nova Scotia"+"Canada"="]].\n"`
+	if d.String() != exp {
+		t.Errorf("Expected %v, got %v", exp, d)
+	}
+	if got := d.Chunks(DiffInsert); !reflect.DeepEqual(got, []string{"big ", "town", "Canada"}) {
+		t.Errorf("Unexpected insertions: %q", got)
+	}
+}
+
+func TestDiffRoundTrip(t *testing.T) {
+	t.Parallel()
+	r := rand.New(rand.NewSource(1))
+	words := []string{"a", "b", "c", "d", " ", " ", "\n"}
+	text := func() string {
+		var b strings.Builder
+		for i := r.Intn(200); i > 0; i-- {
+			b.WriteString(words[r.Intn(len(words))])
+		}
+		return b.String()
+	}
+	for i := 0; i < 200; i++ {
+		old, new := text(), text()
+		for _, d := range []Diff{DiffLines(old, new), DiffWords(old, new)} {
+			if d.Old() != old || d.New() != new {
+				t.Fatalf("Diff %v doesn't turn %q into %q", d, old, new)
+			}
+			for j := 1; j < len(d); j++ {
+				if d[j].Op == d[j-1].Op {
+					t.Fatalf("Adjacent %v chunks in %v", d[j].Op, d)
+				}
+			}
+		}
+	}
+}
+
+func TestDiffTooManyEdits(t *testing.T) {
+	t.Parallel()
+	var a, b []string
+	for i := 0; i < maxDiffEdits; i++ {
+		a = append(a, "a")
+		b = append(b, "b")
+	}
+	runs := diffTokens(append(a, "x"), append(b, "x"))
+	exp := []diffRun{{DiffDelete, maxDiffEdits}, {DiffInsert, maxDiffEdits}, {DiffEqual, 1}}
+	if !reflect.DeepEqual(runs, exp) {
+		t.Errorf("Expected %v, got %v", exp, runs)
+	}
+}
+
+func TestDiffPage(t *testing.T) {
+	t.Parallel()
+	p := &Page{Revisions: []Revision{
+		{ID: 1, Text: "A city near [[Dartmouth]]. {{Infobox}} [[Category:Cities]]"},
+		{ID: 2, Text: "A big city near [[dartmouth|Dartmouth]] and [[Bedford#Basin]]. [[Category:Ports]]"},
+	}}
+	var diffs []RevisionDiff
+	err := DiffPage(p, func(d RevisionDiff) error {
+		diffs = append(diffs, d)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error diffing: %v", err)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("Expected 2 diffs, got %v", len(diffs))
+	}
+
+	first := diffs[0]
+	if first.From != 0 || first.To != 1 || first.WordsAdded != 7 || first.WordsRemoved != 0 ||
+		!reflect.DeepEqual(first.LinksAdded, []string{"Dartmouth"}) ||
+		!reflect.DeepEqual(first.TemplatesAdded, []string{"Infobox"}) {
+		t.Errorf("Unexpected first diff: %+v", first)
+	}
+
+	d := diffs[1]
+	if d.From != 1 || d.To != 2 || d.Lines.Old() != p.Revisions[0].Text || d.Words.New() != p.Revisions[1].Text {
+		t.Errorf("Unexpected second diff: %+v", d)
+	}
+	if d.WordsAdded != 6 || d.WordsRemoved != 2 {
+		t.Errorf("Expected 6 words added and 2 removed, got %v and %v", d.WordsAdded, d.WordsRemoved)
+	}
+	sets := [][]string{d.LinksAdded, d.LinksRemoved, d.CategoriesAdded, d.CategoriesRemoved,
+		d.TemplatesAdded, d.TemplatesRemoved}
+	exp := [][]string{{"Bedford"}, nil, {"Ports"}, {"Cities"}, nil, {"Infobox"}}
+	if !reflect.DeepEqual(sets, exp) {
+		t.Errorf("Expected %q, got %q", exp, sets)
+	}
+
+	if d := DiffRevisions(nil, nil); d.Lines != nil || d.LinksAdded != nil {
+		t.Errorf("Expected an empty diff, got %+v", d)
+	}
+}
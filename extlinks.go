@@ -0,0 +1,153 @@
+package wikiparse
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var bareURLRE *regexp.Regexp
+
+func init() {
+	bareURLRE = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s<>\[\]{}|"]+`)
+}
+
+// ExternalLinkSource describes how an external link was written.
+type ExternalLinkSource int
+
+const (
+	// BracketedLink is an [http://example.com label] link.
+	BracketedLink ExternalLinkSource = iota
+	// BareURL is a URL in running text or a template parameter.
+	BareURL
+	// TemplateURL is a URL from a template such as {{URL}} or
+	// {{Official website}}.
+	TemplateURL
+)
+
+// An ExternalLink is a link from an article to another site.
+type ExternalLink struct {
+	// URL is the link as written.
+	URL string
+	// Normalized is the URL after NormalizeURL.
+	Normalized string
+	// Domain is the lowercased host name without any "www." prefix.
+	Domain string
+	Label  string
+	Source ExternalLinkSource
+	// Pos and End are the byte range of the link in the article.
+	Pos, End int
+}
+
+// Templates whose first parameter (or url parameter) is a link.
+var urlTemplates = map[string]bool{
+	"Url": true, "URL": true, "Official website": true,
+	"Official URL": true, "Official site": true,
+}
+
+// FindExternalLinks finds all the links to other sites from within an
+// article body, including bare URLs and {{URL}} style templates.
+func FindExternalLinks(text string) []ExternalLink {
+	var rv []ExternalLink
+	add := func(l ExternalLink) {
+		l.Normalized = NormalizeURL(l.URL)
+		l.Domain = URLDomain(l.Normalized)
+		rv = append(rv, l)
+	}
+
+	Walk(ParseWikitext(text), func(n *Node) bool {
+		switch n.Kind {
+		case CommentNode:
+			return false
+		case ExtLinkNode:
+			add(ExternalLink{URL: n.Name, Label: inlineText(n.Children),
+				Source: BracketedLink, Pos: n.Pos, End: n.End})
+			return false
+		case TemplateNode:
+			if !urlTemplates[n.TemplateName()] {
+				return true
+			}
+			p, ok := n.Param("url")
+			if !ok {
+				p, ok = n.Param("1")
+			}
+			if u := strings.TrimSpace(p.Raw); ok && u != "" {
+				if !strings.Contains(u, "://") && !strings.HasPrefix(u, "//") {
+					u = "http://" + u
+				}
+				add(ExternalLink{URL: u, Label: n.ParamText("2"),
+					Source: TemplateURL, Pos: n.Pos, End: n.End})
+			}
+			return false
+		case TextNode:
+			for _, m := range bareURLRE.FindAllStringIndex(n.Text, -1) {
+				u := trimURLPunctuation(n.Text[m[0]:m[1]])
+				add(ExternalLink{URL: u, Source: BareURL,
+					Pos: n.Pos + m[0], End: n.Pos + m[0] + len(u)})
+			}
+		}
+		return true
+	})
+
+	return rv
+}
+
+// trimURLPunctuation removes sentence punctuation following a bare
+// URL, as MediaWiki does.
+func trimURLPunctuation(u string) string {
+	for len(u) > 0 {
+		switch c := u[len(u)-1]; {
+		case strings.IndexByte(",;.:!?'", c) >= 0:
+		case c == ')' && strings.Count(u, ")") > strings.Count(u, "("):
+		default:
+			return u
+		}
+		u = u[:len(u)-1]
+	}
+	return u
+}
+
+// NormalizeURL puts a URL in a canonical form for comparison:
+// entities are decoded, protocol relative URLs get https, the scheme
+// and host are lowercased, default ports and fragments are removed
+// and an empty path becomes "/".
+func NormalizeURL(raw string) string {
+	s := html.UnescapeString(strings.TrimSpace(raw))
+	if strings.HasPrefix(s, "//") {
+		s = "https:" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	switch {
+	case u.Scheme == "http" && strings.HasSuffix(u.Host, ":80"):
+		u.Host = strings.TrimSuffix(u.Host, ":80")
+	case u.Scheme == "https" && strings.HasSuffix(u.Host, ":443"):
+		u.Host = strings.TrimSuffix(u.Host, ":443")
+	}
+	if u.Host != "" && u.Path == "" && u.RawPath == "" {
+		u.Path = "/"
+	}
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
+
+// URLDomain gets the lowercased host name of a URL without any
+// "www." prefix, or the domain of a mailto: address.
+func URLDomain(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	host := u.Hostname()
+	if u.Scheme == "mailto" {
+		if i := strings.LastIndexByte(u.Opaque, '@'); i >= 0 {
+			host = u.Opaque[i+1:]
+		}
+	}
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
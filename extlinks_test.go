@@ -0,0 +1,70 @@
+package wikiparse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindExternalLinks(t *testing.T) {
+	t.Parallel()
+	text := `See [http://WWW.Example.com:80/a?b=1&amp;c=2#frag Example site] and
+[//example.org/x], or http://bare.example.net/path. (also https://x.example/a_(b)).
+{{URL|www.official.example|Official}} {{cite web|url=https://cite.example/p|title=T}}
+<!-- http://hidden.example/ --><nowiki>http://nowiki.example/</nowiki>
+[mailto:someone@Mail.Example.com mail]`
+
+	type link struct {
+		URL, Normalized, Domain, Label string
+		Source                         ExternalLinkSource
+	}
+	exp := []link{
+		{"http://WWW.Example.com:80/a?b=1&amp;c=2#frag", "http://www.example.com/a?b=1&c=2", "example.com", "Example site", BracketedLink},
+		{"//example.org/x", "https://example.org/x", "example.org", "", BracketedLink},
+		{"http://bare.example.net/path", "http://bare.example.net/path", "bare.example.net", "", BareURL},
+		{"https://x.example/a_(b)", "https://x.example/a_(b)", "x.example", "", BareURL},
+		{"http://www.official.example", "http://www.official.example/", "official.example", "Official", TemplateURL},
+		{"https://cite.example/p", "https://cite.example/p", "cite.example", "", BareURL},
+		{"mailto:someone@Mail.Example.com", "mailto:someone@Mail.Example.com", "mail.example.com", "mail", BracketedLink},
+	}
+
+	found := FindExternalLinks(text)
+	var got []link
+	for _, l := range found {
+		got = append(got, link{l.URL, l.Normalized, l.Domain, l.Label, l.Source})
+		if l.Source != TemplateURL && !strings.Contains(text[l.Pos:l.End], l.URL) {
+			t.Errorf("Wrong range for %v: %q", l.URL, text[l.Pos:l.End])
+		}
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected\n%v\ngot\n%v", exp, got)
+	}
+}
+
+func TestFindExternalLinksSponge(t *testing.T) {
+	t.Parallel()
+	domains := map[string]int{}
+	for _, l := range FindExternalLinks(sponge) {
+		domains[l.Domain]++
+	}
+	if domains["qm.qld.gov.au"] == 0 || len(domains) < 10 {
+		t.Errorf("Expected more domains, got %v", domains)
+	}
+}
+
+func TestTrimURLPunctuation(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, exp string
+	}{
+		{"http://x/a.", "http://x/a"},
+		{"http://x/a),", "http://x/a"},
+		{"http://x/(a)", "http://x/(a)"},
+		{"http://x/a!?", "http://x/a"},
+	}
+	for _, test := range tests {
+		if got := trimURLPunctuation(test.in); got != test.exp {
+			t.Errorf("Expected %q for %q, got %q", test.exp, test.in, got)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package wikiparse
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// A GeoPoint is a GeoJSON Point geometry.
+type GeoPoint struct {
+	Type string `json:"type"`
+	// Coordinates are the longitude and latitude, in that order.
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// GeoProperties describe the page a GeoFeature was found in.
+type GeoProperties struct {
+	Title     string  `json:"title"`
+	PageID    uint64  `json:"pageid,omitempty"`
+	URL       string  `json:"url,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	Display   string  `json:"display,omitempty"`
+	Source    string  `json:"source,omitempty"`
+	Type      string  `json:"type,omitempty"`
+	Region    string  `json:"region,omitempty"`
+	Scale     int     `json:"scale,omitempty"`
+	Dim       float64 `json:"dim,omitempty"`
+	Precision float64 `json:"precision,omitempty"`
+}
+
+// A GeoFeature is a GeoJSON Feature locating a page.
+type GeoFeature struct {
+	Type       string        `json:"type"`
+	Geometry   GeoPoint      `json:"geometry"`
+	Properties GeoProperties `json:"properties"`
+}
+
+// A GeoFeatureCollection is a GeoJSON FeatureCollection.
+type GeoFeatureCollection struct {
+	Type     string       `json:"type"`
+	Features []GeoFeature `json:"features"`
+}
+
+// NewGeoFeature builds a GeoJSON Feature for a coordinate found in a
+// page.  The site info is used to build the page's URL.
+func NewGeoFeature(p *Page, c PageCoord, si SiteInfo) GeoFeature {
+	return GeoFeature{
+		Type:     "Feature",
+		Geometry: GeoPoint{Type: "Point", Coordinates: []float64{c.Lon, c.Lat}},
+		Properties: GeoProperties{
+			Title:     p.Title,
+			PageID:    p.ID,
+			URL:       si.ArticleURL(p.Title),
+			Name:      c.Name,
+			Display:   c.Display,
+			Source:    c.Source.String(),
+			Type:      c.Params.Type,
+			Region:    c.Params.Region,
+			Scale:     c.Params.MapScale(),
+			Dim:       c.Params.Dim,
+			Precision: c.Precision,
+		},
+	}
+}
+
+// NewGeoFeatureCollection collects features into a FeatureCollection.
+func NewGeoFeatureCollection(features []GeoFeature) GeoFeatureCollection {
+	if features == nil {
+		features = []GeoFeature{}
+	}
+	return GeoFeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// A GeoEncoder writes a stream of features.
+type GeoEncoder interface {
+	Encode(f GeoFeature) error
+	// Close finishes the output, but doesn't close the underlying
+	// writer.
+	Close() error
+}
+
+// GeoJSONSeqEncoder writes features as GeoJSON text sequences (RFC
+// 8142), one record separator delimited feature per line.
+type GeoJSONSeqEncoder struct {
+	w io.Writer
+}
+
+// NewGeoJSONSeqEncoder gets a GeoJSONSeqEncoder writing to w.
+func NewGeoJSONSeqEncoder(w io.Writer) *GeoJSONSeqEncoder {
+	return &GeoJSONSeqEncoder{w}
+}
+
+// Encode writes a feature.
+func (e *GeoJSONSeqEncoder) Encode(f GeoFeature) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	rec := make([]byte, 0, len(b)+2)
+	rec = append(append(append(rec, 0x1e), b...), '\n')
+	_, err = e.w.Write(rec)
+	return err
+}
+
+// Close does nothing, as a sequence needs no trailer.
+func (e *GeoJSONSeqEncoder) Close() error {
+	return nil
+}
+
+const kmlHeader = xml.Header + `<kml xmlns="http://www.opengis.net/kml/2.2">` +
+	"\n<Document>\n"
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	XMLName     xml.Name  `xml:"Placemark"`
+	Name        string    `xml:"name"`
+	Description string    `xml:"description,omitempty"`
+	Data        []kmlData `xml:"ExtendedData>Data,omitempty"`
+	Coordinates string    `xml:"Point>coordinates"`
+}
+
+// KMLEncoder writes features as KML Placemarks within a single
+// Document.
+type KMLEncoder struct {
+	w       io.Writer
+	x       *xml.Encoder
+	started bool
+}
+
+// NewKMLEncoder gets a KMLEncoder writing to w.  Close must be called
+// to finish the document.
+func NewKMLEncoder(w io.Writer) *KMLEncoder {
+	return &KMLEncoder{w: w, x: xml.NewEncoder(w)}
+}
+
+func (e *KMLEncoder) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	_, err := io.WriteString(e.w, kmlHeader)
+	return err
+}
+
+// Encode writes a feature as a Placemark.
+func (e *KMLEncoder) Encode(f GeoFeature) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	p := f.Properties
+	pm := kmlPlacemark{Name: p.Title, Description: p.URL}
+	if p.Name != "" && p.Name != p.Title {
+		pm.Name += " (" + p.Name + ")"
+	}
+	add := func(name, value string) {
+		if value != "" && value != "0" {
+			pm.Data = append(pm.Data, kmlData{name, value})
+		}
+	}
+	add("pageid", strconv.FormatUint(p.PageID, 10))
+	add("source", p.Source)
+	add("type", p.Type)
+	add("region", p.Region)
+	add("scale", strconv.Itoa(p.Scale))
+	if len(f.Geometry.Coordinates) >= 2 {
+		pm.Coordinates = strconv.FormatFloat(f.Geometry.Coordinates[0], 'f', -1, 64) +
+			"," + strconv.FormatFloat(f.Geometry.Coordinates[1], 'f', -1, 64)
+	}
+	if err := e.x.Encode(pm); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n")
+	return err
+}
+
+// Close ends the KML document.
+func (e *KMLEncoder) Close() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "</Document>\n</kml>\n")
+	return err
+}
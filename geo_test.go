@@ -0,0 +1,145 @@
+package wikiparse
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+var geoSite = SiteInfo{Base: "https://en.wikipedia.org/wiki/Main_Page"}
+
+func geoFeatures(t *testing.T) []GeoFeature {
+	p := &Page{Title: "Nova Scotia lighthouses", ID: 42}
+	var rv []GeoFeature
+	for _, c := range FindCoords(lighthouses) {
+		rv = append(rv, NewGeoFeature(p, c, geoSite))
+	}
+	if len(rv) != 4 {
+		t.Fatalf("Expected 4 features, got %v", len(rv))
+	}
+	return rv
+}
+
+func TestNewGeoFeature(t *testing.T) {
+	t.Parallel()
+	f := geoFeatures(t)[1]
+	b, err := json.Marshal(f)
+	if err != nil {
+		t.Fatalf("Error marshaling %+v: %v", f, err)
+	}
+	exp := `{"type":"Feature","geometry":{"type":"Point","coordinates":[-63.5636,44.4365]},` +
+		`"properties":{"title":"Nova Scotia lighthouses","pageid":42,` +
+		`"url":"https://en.wikipedia.org/wiki/Nova_Scotia_lighthouses",` +
+		`"name":"Sambro Island Light","source":"coord","scale":300000,"precision":0.0001}}`
+	if string(b) != exp {
+		t.Errorf("Expected\n%s\ngot\n%s", exp, b)
+	}
+}
+
+func TestGeoFeatureCollection(t *testing.T) {
+	t.Parallel()
+	b, err := json.Marshal(NewGeoFeatureCollection(nil))
+	if err != nil || string(b) != `{"type":"FeatureCollection","features":[]}` {
+		t.Errorf("Unexpected empty collection: %s, %v", b, err)
+	}
+
+	fc := NewGeoFeatureCollection(geoFeatures(t))
+	b, err = json.Marshal(fc)
+	if err != nil {
+		t.Fatalf("Error marshaling: %v", err)
+	}
+	var got GeoFeatureCollection
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Error unmarshaling %s: %v", b, err)
+	}
+	if got.Type != "FeatureCollection" || len(got.Features) != 4 {
+		t.Errorf("Expected 4 features, got %+v", got)
+	}
+}
+
+func TestGeoJSONSeqEncoder(t *testing.T) {
+	t.Parallel()
+	buf := &bytes.Buffer{}
+	e := NewGeoJSONSeqEncoder(buf)
+	for _, f := range geoFeatures(t) {
+		if err := e.Encode(f); err != nil {
+			t.Fatalf("Error encoding: %v", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+
+	recs := strings.Split(buf.String(), "\x1e")
+	if recs[0] != "" || len(recs) != 5 {
+		t.Fatalf("Expected 4 records, got %q", recs)
+	}
+	for _, r := range recs[1:] {
+		var f GeoFeature
+		if !strings.HasSuffix(r, "}\n") {
+			t.Errorf("Expected a newline terminated record, got %q", r)
+		}
+		if err := json.Unmarshal([]byte(r), &f); err != nil || f.Type != "Feature" {
+			t.Errorf("Bad record %q: %v", r, err)
+		}
+	}
+}
+
+func TestKMLEncoder(t *testing.T) {
+	t.Parallel()
+	buf := &bytes.Buffer{}
+	e := NewKMLEncoder(buf)
+	features := geoFeatures(t)
+	features[0].Properties.Title = "Halifax & <Dartmouth>"
+	for _, f := range features {
+		if err := e.Encode(f); err != nil {
+			t.Fatalf("Error encoding: %v", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+
+	var doc struct {
+		Placemarks []struct {
+			Name        string `xml:"name"`
+			Coordinates string `xml:"Point>coordinates"`
+			Data        []struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:"value"`
+			} `xml:"ExtendedData>Data"`
+		} `xml:"Document>Placemark"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Error parsing KML: %v\n%s", err, buf)
+	}
+	if len(doc.Placemarks) != 4 {
+		t.Fatalf("Expected 4 placemarks, got %+v", doc)
+	}
+	pm := doc.Placemarks[0]
+	if pm.Name != "Halifax & <Dartmouth>" {
+		t.Errorf("Expected escaped name to round trip, got %q", pm.Name)
+	}
+	if pm.Coordinates != "-63.56666666666667,44.63333333333333" {
+		t.Errorf("Unexpected coordinates: %v", pm.Coordinates)
+	}
+	if len(pm.Data) == 0 || pm.Data[0].Name != "pageid" || pm.Data[0].Value != "42" {
+		t.Errorf("Unexpected extended data: %+v", pm.Data)
+	}
+	if pm := doc.Placemarks[1]; pm.Name != "Nova Scotia lighthouses (Sambro Island Light)" {
+		t.Errorf("Unexpected name: %v", pm.Name)
+	}
+}
+
+func TestKMLEncoderEmpty(t *testing.T) {
+	t.Parallel()
+	buf := &bytes.Buffer{}
+	if err := NewKMLEncoder(buf).Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+	if !strings.HasSuffix(buf.String(), "<Document>\n</Document>\n</kml>\n") {
+		t.Errorf("Unexpected empty document: %q", buf)
+	}
+}
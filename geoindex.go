@@ -0,0 +1,260 @@
+package wikiparse
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// EarthRadius is the mean radius of the earth in meters.
+const EarthRadius = 6371008.8
+
+// DefaultCellSize is the size of a GeoIndex grid cell in degrees,
+// about 11km at the equator.
+const DefaultCellSize = 0.1
+
+const geoIndexVersion = 1
+
+// The smallest cell size ReadGeoIndex accepts, about 10cm.  Smaller
+// cells would overflow the grid's cell numbers.
+const minGeoCellSize = 1e-6
+
+// ErrBadGeoIndex is returned when reading a GeoIndex that wasn't
+// written by GeoIndex.WriteTo.
+var ErrBadGeoIndex = errors.New("not a geo index")
+
+// Distance gets the great-circle distance between two coordinates in
+// meters using the haversine formula.
+func Distance(a, b Coord) float64 {
+	rad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLon := (b.Lon - a.Lon) * rad
+	h := math.Pow(math.Sin(dLat/2), 2) +
+		math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(math.Min(1, h)))
+}
+
+// A GeoEntry is a page's location in a GeoIndex.
+type GeoEntry struct {
+	Title  string
+	PageID uint64
+	Coord  Coord
+}
+
+// A GeoResult is an entry found by GeoIndex.Near.
+type GeoResult struct {
+	GeoEntry
+	// Distance is how far the entry is from the query point, in
+	// meters.
+	Distance float64
+}
+
+// A GeoIndex finds pages by location.  Entries are bucketed into a
+// grid of cells a fixed number of degrees on a side, so queries only
+// look at the cells they overlap.
+//
+// A GeoIndex is safe for concurrent queries, but not while entries
+// are being added.
+type GeoIndex struct {
+	cellSize float64
+	lonCells int
+	entries  []GeoEntry
+	cells    map[int][]int
+}
+
+// NewGeoIndex gets an empty GeoIndex with the given cell size in
+// degrees.  A cell size of 0 uses DefaultCellSize.
+func NewGeoIndex(cellSize float64) *GeoIndex {
+	if cellSize <= 0 {
+		cellSize = DefaultCellSize
+	}
+	return &GeoIndex{
+		cellSize: cellSize,
+		lonCells: int(math.Ceil(360 / cellSize)),
+		cells:    map[int][]int{},
+	}
+}
+
+// BuildGeoIndex indexes the primary coordinate of each page's latest
+// revision from a Parser, as found by ParseCoords.  Pages without a coordinate on
+// earth are skipped.
+func BuildGeoIndex(p Parser, cellSize float64) (*GeoIndex, error) {
+	g := NewGeoIndex(cellSize)
+	for {
+		page, err := p.Next()
+		if err == io.EOF {
+			return g, nil
+		}
+		if err != nil {
+			return g, err
+		}
+		if page.Redir.Title != "" || len(page.Revisions) == 0 {
+			continue
+		}
+		c, err := ParseCoords(page.Revisions[len(page.Revisions)-1].Text)
+		if err == nil {
+			g.Add(GeoEntry{Title: page.Title, PageID: page.ID, Coord: c})
+		}
+	}
+}
+
+func (g *GeoIndex) latCell(lat float64) int {
+	return int(math.Floor((math.Max(-90, math.Min(90, lat)) + 90) / g.cellSize))
+}
+
+func (g *GeoIndex) lonCell(lon float64) int {
+	i := int(math.Floor((lon + 180) / g.cellSize))
+	if i >= g.lonCells {
+		i = g.lonCells - 1
+	}
+	return i
+}
+
+func (g *GeoIndex) cell(c Coord) int {
+	return g.latCell(c.Lat)*g.lonCells + g.lonCell(c.Lon)
+}
+
+// Add adds an entry to the index.
+func (g *GeoIndex) Add(e GeoEntry) {
+	k := g.cell(e.Coord)
+	g.cells[k] = append(g.cells[k], len(g.entries))
+	g.entries = append(g.entries, e)
+}
+
+// Len gets the number of entries in the index.
+func (g *GeoIndex) Len() int {
+	return len(g.entries)
+}
+
+// InBox gets the entries within the box with the given south west
+// and north east corners.  The box crosses the antimeridian if the
+// south west corner is further east than the north east one.
+func (g *GeoIndex) InBox(sw, ne Coord) []GeoEntry {
+	var rv []GeoEntry
+	g.scanBox(sw, ne, func(e GeoEntry) {
+		rv = append(rv, e)
+	})
+	return rv
+}
+
+func inLonRange(lon, west, east float64) bool {
+	if west <= east {
+		return west <= lon && lon <= east
+	}
+	return lon >= west || lon <= east
+}
+
+func (g *GeoIndex) scanBox(sw, ne Coord, f func(GeoEntry)) {
+	if sw.Lat > ne.Lat {
+		return
+	}
+	match := func(e GeoEntry) {
+		if e.Coord.Lat >= sw.Lat && e.Coord.Lat <= ne.Lat &&
+			inLonRange(e.Coord.Lon, sw.Lon, ne.Lon) {
+			f(e)
+		}
+	}
+
+	lat0, lat1 := g.latCell(sw.Lat), g.latCell(ne.Lat)
+	lon0, lon1 := g.lonCell(sw.Lon), g.lonCell(ne.Lon)
+	lons := lon1 - lon0 + 1
+	if lon0 > lon1 || (lon0 == lon1 && sw.Lon > ne.Lon) {
+		lons += g.lonCells
+	}
+	if lons > g.lonCells {
+		lons = g.lonCells
+	}
+	if (lat1-lat0+1)*lons > len(g.cells) {
+		// Cheaper to look at everything than at every cell.
+		for _, e := range g.entries {
+			match(e)
+		}
+		return
+	}
+	for lat := lat0; lat <= lat1; lat++ {
+		for i := 0; i < lons; i++ {
+			for _, idx := range g.cells[lat*g.lonCells+(lon0+i)%g.lonCells] {
+				match(g.entries[idx])
+			}
+		}
+	}
+}
+
+// Near gets the entries within radius meters of a point, nearest
+// first.
+func (g *GeoIndex) Near(c Coord, radius float64) []GeoResult {
+	r := radius / EarthRadius
+	dLat := r * 180 / math.Pi
+	sw := Coord{Lat: c.Lat - dLat, Lon: -180}
+	ne := Coord{Lat: c.Lat + dLat, Lon: 180}
+	// The circle's widest longitude span is asin(sin(r)/cos(lat)),
+	// and it covers every longitude if it reaches a pole.
+	if s := math.Sin(r) / math.Cos(c.Lat*math.Pi/180); sw.Lat > -90 && ne.Lat < 90 && r < math.Pi/2 && s < 1 {
+		dLon := math.Asin(s) * 180 / math.Pi
+		sw.Lon, ne.Lon = wrapLon(c.Lon-dLon), wrapLon(c.Lon+dLon)
+	}
+
+	var rv []GeoResult
+	g.scanBox(sw, ne, func(e GeoEntry) {
+		if d := Distance(c, e.Coord); d <= radius {
+			rv = append(rv, GeoResult{e, d})
+		}
+	})
+	sort.SliceStable(rv, func(i, j int) bool {
+		return rv[i].Distance < rv[j].Distance
+	})
+	return rv
+}
+
+// wrapLon puts a longitude into the range [-180, 180].
+func wrapLon(lon float64) float64 {
+	for lon > 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	return lon
+}
+
+type geoIndexFile struct {
+	Version  int
+	CellSize float64
+	Entries  []GeoEntry
+}
+
+// WriteTo saves the index so it may be loaded with ReadGeoIndex.
+func (g *GeoIndex) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := gob.NewEncoder(cw).Encode(geoIndexFile{geoIndexVersion, g.cellSize, g.entries})
+	return cw.n, err
+}
+
+// ReadGeoIndex loads an index saved with GeoIndex.WriteTo.
+func ReadGeoIndex(r io.Reader) (*GeoIndex, error) {
+	var f geoIndexFile
+	if err := gob.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	if f.Version != geoIndexVersion || !(f.CellSize >= minGeoCellSize && f.CellSize <= 360) {
+		return nil, ErrBadGeoIndex
+	}
+	g := NewGeoIndex(f.CellSize)
+	for _, e := range f.Entries {
+		g.Add(e)
+	}
+	return g, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,216 @@
+package wikiparse
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		a, b Coord
+		exp  float64
+	}{
+		{Coord{Lat: 51.5074, Lon: -0.1278}, Coord{Lat: 48.8566, Lon: 2.3522}, 343.56e3},
+		{Coord{Lat: 0, Lon: 179.5}, Coord{Lat: 0, Lon: -179.5}, 111.195e3},
+		{Coord{Lat: 90}, Coord{Lat: -90}, math.Pi * EarthRadius},
+		{Coord{Lat: 10, Lon: 10}, Coord{Lat: 10, Lon: 10}, 0},
+	}
+	for _, test := range tests {
+		if d := Distance(test.a, test.b); math.Abs(d-test.exp) > 100 {
+			t.Errorf("Expected %v between %v and %v, got %v", test.exp, test.a, test.b, d)
+		}
+	}
+}
+
+func randomGeoIndex(n int) (*GeoIndex, *rand.Rand) {
+	r := rand.New(rand.NewSource(1))
+	g := NewGeoIndex(0)
+	for i := 0; i < n; i++ {
+		g.Add(GeoEntry{PageID: uint64(i), Coord: Coord{
+			Lat: r.Float64()*180 - 90,
+			Lon: r.Float64()*360 - 180,
+		}})
+	}
+	// Some clusters so small queries find things.
+	for i := 0; i < n; i++ {
+		g.Add(GeoEntry{PageID: uint64(n + i), Coord: Coord{
+			Lat: 44.6 + r.Float64()*0.5,
+			Lon: 179.8 - r.Float64()*359.5*float64(i%2),
+		}})
+	}
+	return g, r
+}
+
+func entryIDs(es []GeoEntry) []uint64 {
+	rv := make([]uint64, 0, len(es))
+	for _, e := range es {
+		rv = append(rv, e.PageID)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i] < rv[j] })
+	return rv
+}
+
+func sameIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGeoIndexInBox(t *testing.T) {
+	t.Parallel()
+	g, _ := randomGeoIndex(2000)
+	boxes := [][2]Coord{
+		{{Lat: 44, Lon: 179}, {Lat: 45, Lon: -179}},
+		{{Lat: 44, Lon: -180}, {Lat: 46, Lon: 180}},
+		{{Lat: -10, Lon: -10}, {Lat: 10, Lon: 10}},
+		{{Lat: -90, Lon: 170}, {Lat: 90, Lon: 169}},
+		{{Lat: 44.7, Lon: 179.9}, {Lat: 44.8, Lon: 179.95}},
+		{{Lat: 10, Lon: 0}, {Lat: -10, Lon: 1}},
+	}
+	for _, b := range boxes {
+		var exp []GeoEntry
+		for _, e := range g.entries {
+			if e.Coord.Lat >= b[0].Lat && e.Coord.Lat <= b[1].Lat &&
+				inLonRange(e.Coord.Lon, b[0].Lon, b[1].Lon) {
+				exp = append(exp, e)
+			}
+		}
+		if got := entryIDs(g.InBox(b[0], b[1])); !sameIDs(got, entryIDs(exp)) {
+			t.Errorf("Expected %v entries in %v, got %v", len(exp), b, len(got))
+		}
+	}
+}
+
+func TestGeoIndexNear(t *testing.T) {
+	t.Parallel()
+	g, r := randomGeoIndex(2000)
+	points := []Coord{{Lat: 44.8, Lon: 180}, {Lat: 44.8, Lon: -179.9}, {Lat: 89.99}, {Lat: -89.9, Lon: 20}}
+	for i := 0; i < 20; i++ {
+		points = append(points, Coord{Lat: r.Float64()*180 - 90, Lon: r.Float64()*360 - 180})
+	}
+	for _, p := range points {
+		for _, radius := range []float64{5e3, 50e3, 1000e3} {
+			var exp []GeoEntry
+			for _, e := range g.entries {
+				if Distance(p, e.Coord) <= radius {
+					exp = append(exp, e)
+				}
+			}
+			found := g.Near(p, radius)
+			var got []GeoEntry
+			for i, res := range found {
+				got = append(got, res.GeoEntry)
+				if i > 0 && res.Distance < found[i-1].Distance {
+					t.Errorf("Results near %v out of order: %v", p, found)
+				}
+			}
+			if !sameIDs(entryIDs(got), entryIDs(exp)) {
+				t.Errorf("Expected %v entries within %v of %v, got %v", len(exp), radius, p, len(got))
+			}
+		}
+	}
+}
+
+// destination gets the point d meters from c along the given bearing
+// in degrees.
+func destination(c Coord, bearing, d float64) Coord {
+	const rad = math.Pi / 180
+	lat, lon, b, r := c.Lat*rad, c.Lon*rad, bearing*rad, d/EarthRadius
+	lat2 := math.Asin(math.Sin(lat)*math.Cos(r) + math.Cos(lat)*math.Sin(r)*math.Cos(b))
+	lon2 := lon + math.Atan2(math.Sin(b)*math.Sin(r)*math.Cos(lat), math.Cos(r)-math.Sin(lat)*math.Sin(lat2))
+	return Coord{Lat: lat2 / rad, Lon: wrapLon(lon2 / rad)}
+}
+
+func TestGeoIndexNearEdge(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		c      Coord
+		radius float64
+	}{
+		{Coord{Lat: 85}, 111195},
+		{Coord{Lat: 75, Lon: 170}, 1111951},
+		{Coord{Lat: -80, Lon: -100}, 500e3},
+		{Coord{Lat: 60, Lon: 30}, 3000e3},
+	}
+	for _, test := range tests {
+		g := NewGeoIndex(0)
+		for b := 0; b < 360; b += 5 {
+			g.Add(GeoEntry{PageID: uint64(b), Coord: destination(test.c, float64(b), test.radius*0.999)})
+		}
+		if got := g.Near(test.c, test.radius); len(got) != g.Len() {
+			t.Errorf("Expected %v entries within %v of %v, got %v", g.Len(), test.radius, test.c, len(got))
+		}
+	}
+}
+
+func TestGeoIndexPersist(t *testing.T) {
+	t.Parallel()
+	g, _ := randomGeoIndex(100)
+	buf := &bytes.Buffer{}
+	n, err := g.WriteTo(buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("Error writing index: %v (%v bytes of %v)", err, n, buf.Len())
+	}
+	g2, err := ReadGeoIndex(buf)
+	if err != nil {
+		t.Fatalf("Error reading index: %v", err)
+	}
+	if g2.Len() != g.Len() || g2.cellSize != g.cellSize {
+		t.Fatalf("Expected %v entries, got %v", g.Len(), g2.Len())
+	}
+	p := Coord{Lat: 44.8, Lon: 179.9}
+	if a, b := g.Near(p, 100e3), g2.Near(p, 100e3); len(a) == 0 || len(a) != len(b) {
+		t.Errorf("Expected the same results, got %v and %v", len(a), len(b))
+	}
+
+	if _, err := ReadGeoIndex(strings.NewReader("garbage")); err == nil {
+		t.Errorf("Expected error reading garbage")
+	}
+	for _, size := range []float64{0, 1e-300, math.NaN(), math.Inf(1)} {
+		buf.Reset()
+		gob.NewEncoder(buf).Encode(geoIndexFile{geoIndexVersion, size, nil})
+		if _, err := ReadGeoIndex(buf); err != ErrBadGeoIndex {
+			t.Errorf("Expected %v for cell size %v, got %v", ErrBadGeoIndex, size, err)
+		}
+	}
+}
+
+func TestBuildGeoIndex(t *testing.T) {
+	t.Parallel()
+	p, err := NewParser(strings.NewReader(`<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Peggys Cove</title><id>1</id><revision><text>{{coord|44|29|30|N|63|55|8|W}}</text></revision></page>
+<page><title>Halifax</title><id>2</id><revision><text>{{coord|1|2}}</text></revision><revision><text>{{coord|44.65|-63.57}}</text></revision></page>
+<page><title>Tycho</title><id>3</id><revision><text>{{coord|43.3|S|11.2|W|globe:moon}}</text></revision></page>
+<page><title>Nowhere</title><id>4</id><revision><text>No coordinates.</text></revision></page>
+<page><title>Peggy's Cove</title><id>5</id><redirect title="Peggys Cove" /><revision><text>#REDIRECT [[Peggys Cove]] {{coord|1|2}}</text></revision></page>
+</mediawiki>`))
+	if err != nil {
+		t.Fatalf("Error creating parser: %v", err)
+	}
+	g, err := BuildGeoIndex(p, 0)
+	if err != nil {
+		t.Fatalf("Error building index: %v", err)
+	}
+	if g.Len() != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", g.entries)
+	}
+	found := g.Near(Coord{Lat: 44.65, Lon: -63.57}, 50e3)
+	if len(found) != 2 || found[0].Title != "Halifax" || found[1].Title != "Peggys Cove" {
+		t.Errorf("Expected Halifax then Peggys Cove, got %+v", found)
+	}
+	if d := found[1].Distance; d < 30e3 || d > 40e3 {
+		t.Errorf("Expected Peggys Cove about 35km away, got %v", d)
+	}
+}
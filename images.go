@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -31,7 +32,9 @@ func FindFiles(text string) []string {
 	return rv
 }
 
-// URLForFile gets the wikimedia URL for the given named file.
+// URLForFile gets the wikimedia commons URL for the given named file.
+//
+// See MediaURLBuilder for https, locally hosted files and thumbnails.
 func URLForFile(name string) string {
 	m := md5.New()
 	name = strings.Replace(name, " ", "_", -1)
@@ -41,3 +44,242 @@ func URLForFile(name string) string {
 	return "http://upload.wikimedia.org/wikipedia/commons/" +
 		string(h[0]) + "/" + h[0:2] + "/" + url.QueryEscape(name)
 }
+
+// FileOrigin describes where a file reference was found.
+type FileOrigin int
+
+const (
+	// InlineFile is a [[File:...]] link.
+	InlineFile FileOrigin = iota
+	// GalleryFile is a line within a <gallery> tag.
+	GalleryFile
+	// TemplateFile is a template parameter such as an infobox's
+	// "image =".
+	TemplateFile
+)
+
+// FileDetails describes a file embedded in an article.
+type FileDetails struct {
+	// Name is the canonical file name without its namespace.
+	Name string
+	// Caption is the plain text caption.
+	Caption string
+	// Format is thumb, frame, frameless or border, if specified.
+	Format string
+	// Width and Height are the requested size in pixels, or 0.
+	Width, Height int
+	// Upright is the upright scaling factor, or 0 if unspecified.
+	Upright float64
+	// Align is left, right, center or none, if specified.
+	Align string
+	// VAlign is the vertical alignment, if specified.
+	VAlign    string
+	Alt, Link string
+	Origin    FileOrigin
+	// Pos and End are the byte range of the reference.
+	Pos, End int
+}
+
+var fileSizeRE, fileExtRE *regexp.Regexp
+
+func init() {
+	fileSizeRE = regexp.MustCompile(`^(\d*)(?:x(\d+))?\s*px$`)
+	fileExtRE = regexp.MustCompile(`(?i)\.(jpe?g|png|gif|svg|tiff?|webp|xcf|pdf|djvu|ogg|ogv|oga|webm|mp3|wav|flac|mid|stl)$`)
+}
+
+var fileFormats = map[string]string{
+	"thumb": "thumb", "thumbnail": "thumb", "frame": "frame",
+	"framed": "frame", "frameless": "frameless", "border": "border",
+}
+
+var fileAligns = map[string]string{
+	"left": "left", "right": "right", "center": "center",
+	"centre": "center", "none": "none",
+}
+
+var fileVAligns = map[string]bool{
+	"baseline": true, "middle": true, "sub": true, "super": true,
+	"text-top": true, "text-bottom": true, "top": true, "bottom": true,
+}
+
+// namespaceNames gets the lowercased names a namespace is known by,
+// including the given English defaults.
+func (si SiteInfo) namespaceNames(key string, defaults ...string) map[string]bool {
+	rv := map[string]bool{}
+	for _, d := range defaults {
+		rv[strings.ToLower(d)] = true
+	}
+	for _, ns := range si.Namespaces {
+		if ns.Key == key && ns.Value != "" {
+			rv[strings.ToLower(canonicalTitle(ns.Value))] = true
+		}
+	}
+	return rv
+}
+
+// FileNamespaces gets the lowercased names of the File and Media
+// namespaces for this site, including the English "File", "Image" and
+// "Media" that MediaWiki always accepts.
+func (si SiteInfo) FileNamespaces() map[string]bool {
+	rv := si.namespaceNames("6", "File", "Image")
+	for k := range si.namespaceNames("-2", "Media") {
+		rv[k] = true
+	}
+	return rv
+}
+
+// splitFileName splits a namespace prefix off a title, returning the
+// canonical file name if the prefix is a file namespace.
+func splitFileName(title string, namespaces map[string]bool) (string, bool) {
+	i := strings.IndexByte(title, ':')
+	if i < 0 || !namespaces[strings.ToLower(canonicalTitle(title[:i]))] {
+		return "", false
+	}
+	return canonicalTitle(title[i+1:]), true
+}
+
+// applyOption applies a single image option to the details, reporting
+// whether it was a recognized option.
+func (f *FileDetails) applyOption(opt string) bool {
+	key, val := opt, ""
+	if i := strings.IndexByte(opt, '='); i >= 0 {
+		key, val = strings.TrimSpace(opt[:i]), strings.TrimSpace(opt[i+1:])
+	}
+	lkey := strings.ToLower(key)
+	switch {
+	case fileFormats[lkey] != "":
+		f.Format = fileFormats[lkey]
+	case fileAligns[lkey] != "" && val == "":
+		f.Align = fileAligns[lkey]
+	case fileVAligns[lkey] && val == "":
+		f.VAlign = lkey
+	case lkey == "upright":
+		f.Upright = 1
+		if u, err := strconv.ParseFloat(val, 64); err == nil {
+			f.Upright = u
+		}
+	case lkey == "alt":
+		f.Alt = val
+	case lkey == "link":
+		f.Link = val
+	case lkey == "page" || lkey == "lang" || lkey == "class" ||
+		lkey == "start" || lkey == "end" || lkey == "thumbtime":
+	default:
+		m := fileSizeRE.FindStringSubmatch(lkey)
+		if m == nil || (m[1] == "" && m[2] == "") {
+			return false
+		}
+		f.Width, _ = strconv.Atoi(m[1])
+		f.Height, _ = strconv.Atoi(m[2])
+	}
+	return true
+}
+
+// FindFileDetails finds the files used in an article body along with
+// their captions and display options.
+//
+// This understands [[File:...]] links in any of the site's file
+// namespaces, <gallery> tags, and template parameters (such as
+// infobox images) whose values are file names.  Unlike FindFiles,
+// commented out files are not included.
+func FindFileDetails(text string, si SiteInfo) []FileDetails {
+	namespaces := si.FileNamespaces()
+	var rv []FileDetails
+	Walk(ParseWikitext(text), func(n *Node) bool {
+		switch n.Kind {
+		case CommentNode:
+			return false
+		case LinkNode:
+			name, ok := splitFileName(n.Name, namespaces)
+			if !ok {
+				return true
+			}
+			f := FileDetails{Name: name, Origin: InlineFile, Pos: n.Pos, End: n.End}
+			for _, p := range n.Params {
+				if !f.applyOption(strings.TrimSpace(p.Raw)) {
+					f.Caption = inlineText(p.Value)
+				}
+			}
+			rv = append(rv, f)
+		case TagNode:
+			if n.Name == "gallery" {
+				rv = append(rv, galleryFiles(text, n, namespaces)...)
+			}
+		case TemplateNode:
+			rv = append(rv, templateFiles(n, namespaces)...)
+		}
+		return true
+	})
+	return rv
+}
+
+func galleryFiles(text string, n *Node, namespaces map[string]bool) []FileDetails {
+	var rv []FileDetails
+	pos := n.Pos + strings.IndexByte(text[n.Pos:n.End], '>') + 1
+	for _, line := range strings.SplitAfter(n.Text, "\n") {
+		start := pos
+		pos += len(line)
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if parts[0] == "" {
+			continue
+		}
+		name, ok := splitFileName(parts[0], namespaces)
+		if !ok {
+			name = canonicalTitle(parts[0])
+		}
+		f := FileDetails{Name: name, Origin: GalleryFile,
+			Pos: start, End: start + len(strings.TrimRight(line, "\r\n"))}
+		for _, opt := range parts[1:] {
+			if !f.applyOption(strings.TrimSpace(opt)) {
+				f.Caption = PlainText(opt)
+			}
+		}
+		rv = append(rv, f)
+	}
+	return rv
+}
+
+// templateFiles finds template parameters that name files, along
+// with their conventional caption and size parameters.  Citation
+// templates and URLs are skipped, as they link to documents rather
+// than naming files.
+func templateFiles(n *Node, namespaces map[string]bool) []FileDetails {
+	name := strings.ToLower(n.TemplateName())
+	if name == "citation" || strings.HasPrefix(name, "cite ") {
+		return nil
+	}
+	var rv []FileDetails
+	for _, p := range n.Params {
+		v := n.ParamText(p.Name)
+		if !fileExtRE.MatchString(v) || strings.ContainsAny(v, "[]{}|<>\n") ||
+			strings.Contains(v, "://") || strings.HasPrefix(v, "//") {
+			continue
+		}
+		name, ok := splitFileName(v, namespaces)
+		if !ok {
+			name = canonicalTitle(v)
+		}
+		f := FileDetails{Name: name, Origin: TemplateFile, Pos: p.Pos, End: p.End}
+		caps := []string{p.Name + "_caption", p.Name + " caption", p.Name + "caption"}
+		if p.Name == "image" {
+			caps = append(caps, "caption")
+		}
+		for _, c := range caps {
+			if cp, ok := n.Param(c); ok {
+				f.Caption = inlineText(cp.Value)
+				break
+			}
+		}
+		for _, s := range []string{p.Name + "_width", p.Name + "_size", p.Name + "size", p.Name + "-width"} {
+			if w := n.ParamText(s); w != "" {
+				f.Width, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(w), "px"))
+				break
+			}
+		}
+		if f.Alt = n.ParamText(p.Name + "_alt"); f.Alt == "" && p.Name == "image" {
+			f.Alt = n.ParamText("alt")
+		}
+		rv = append(rv, f)
+	}
+	return rv
+}
@@ -1,7 +1,9 @@
 package wikiparse
 
 import (
+	"path"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -874,3 +876,68 @@ func TestImageUrling(t *testing.T) {
 		}
 	}
 }
+
+func TestFindFileDetails(t *testing.T) {
+	t.Parallel()
+	var si SiteInfo
+	si.Namespaces = append(si.Namespaces, struct {
+		Key   string `xml:"key,attr"`
+		Case  string `xml:"case,attr"`
+		Value string `xml:",chardata"`
+	}{Key: "6", Value: "Datei"})
+
+	text := `[[File:A_b.jpg|thumb|upright=0.8|left|alt=Alt text|A ''caption'' with [[link|links]]]]
+[[Image:Old.png|200x100px|frame|Old]] [[datei:Deutsch.svg|x50px|middle|link=Foo]]
+[[:File:NotEmbedded.jpg]] [[Fichier:Unknown.jpg]]
+<!-- [[File:Commented.jpg]] -->
+<gallery>
+File:One.jpg|First one
+Two.jpg|alt=Second|Second caption
+</gallery>
+{{Infobox thing|image = Thing.JPG |image_caption = The [[thing]] |image_size=250px|map=Map of thing.svg|name=thing.jpg.txt}}
+{{cite web|url=http://example.com/report.pdf|title=x}} {{Citation|file=Report.pdf}}
+{{Infobox thing|image=//upload.example.com/x.png|map=https://example.com/map.svg}}`
+
+	exp := []FileDetails{
+		{Name: "A b.jpg", Caption: "A caption with links", Format: "thumb",
+			Upright: 0.8, Align: "left", Alt: "Alt text", Origin: InlineFile},
+		{Name: "Old.png", Caption: "Old", Format: "frame", Width: 200, Height: 100},
+		{Name: "Deutsch.svg", Height: 50, VAlign: "middle", Link: "Foo"},
+		{Name: "One.jpg", Caption: "First one", Origin: GalleryFile},
+		{Name: "Two.jpg", Caption: "Second caption", Alt: "Second", Origin: GalleryFile},
+		{Name: "Thing.JPG", Caption: "The thing", Width: 250, Origin: TemplateFile},
+		{Name: "Map of thing.svg", Origin: TemplateFile},
+	}
+
+	got := FindFileDetails(text, si)
+	for i := range got {
+		if !strings.Contains(text[got[i].Pos:got[i].End], path.Ext(got[i].Name)) {
+			t.Errorf("Wrong range for %v: %q", got[i].Name, text[got[i].Pos:got[i].End])
+		}
+		got[i].Pos, got[i].End = 0, 0
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected\n%+v\ngot\n%+v", exp, got)
+	}
+}
+
+func TestFindFileDetailsSponge(t *testing.T) {
+	t.Parallel()
+	found := FindFileDetails(sponge, SiteInfo{})
+	byName := map[string]FileDetails{}
+	for _, f := range found {
+		byName[f.Name] = f
+	}
+	infobox := byName["Aplysina archeri (Stove-pipe Sponge-pink variation).jpg"]
+	if infobox.Origin != TemplateFile || infobox.Caption != "A stove-pipe sponge" || infobox.Width != 250 {
+		t.Errorf("Wrong infobox image: %+v", infobox)
+	}
+	if f := byName["Porifera calcifying 01.png"]; f.Origin != TemplateFile {
+		t.Errorf("Missed annotated image: %+v", f)
+	}
+	f := byName["Spongia officinalis.jpg"]
+	if f.Origin != InlineFile || f.Format != "thumb" || f.Align != "right" || f.Width != 200 ||
+		f.Caption != `Spongia officinalis, "the kitchen sponge", is dark grey when alive` {
+		t.Errorf("Wrong inline image: %+v", f)
+	}
+}
@@ -0,0 +1,193 @@
+package wikiparse
+
+import (
+	"io"
+)
+
+// A ChangeKind says how an incremental dump changed a page.
+type ChangeKind int
+
+const (
+	// PageAdded is a page that isn't in the base dump.
+	PageAdded ChangeKind = iota + 1
+	// PageUpdated is a page with a newer revision than the base
+	// dump's.
+	PageUpdated
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case PageAdded:
+		return "added"
+	case PageUpdated:
+		return "updated"
+	}
+	return "unknown"
+}
+
+// A Change is a page added or updated by incremental dumps.
+type Change struct {
+	Kind ChangeKind
+	// Page is the page as of its latest revision.
+	Page *Page
+	// Previous is the base dump's latest revision of an updated
+	// page.
+	Previous *Revision
+}
+
+// newerRevision reports whether revision a supersedes b.  Revision
+// ids only go up, so they decide unless one is missing, and then the
+// timestamps do.
+func newerRevision(a, b *Revision) bool {
+	if a.ID != 0 && b.ID != 0 {
+		return a.ID > b.ID
+	}
+	return a.Timestamp > b.Timestamp
+}
+
+func latest(p *Page) *Revision {
+	if len(p.Revisions) == 0 {
+		return nil
+	}
+	return &p.Revisions[len(p.Revisions)-1]
+}
+
+// An Incremental collects the latest revision of each page from a
+// sequence of incremental ("adds-changes") dumps, so they can be
+// applied to a full dump.
+//
+// Only each page's latest revision is kept, but that includes its
+// text, so the pages of every incremental dump are held in memory.
+type Incremental struct {
+	pages map[uint64]*Page
+	order []uint64
+}
+
+// NewIncremental gets an empty Incremental.
+func NewIncremental() *Incremental {
+	return &Incremental{pages: map[uint64]*Page{}}
+}
+
+// Add reads every page from an incremental dump.  Dumps may be added
+// in any order: a page seen more than once keeps its newest revision.
+func (inc *Incremental) Add(p Parser) error {
+	for {
+		page, err := p.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		inc.AddPage(page)
+	}
+}
+
+// AddPage records a page from an incremental dump, reporting whether
+// it's newer than what was already recorded.
+func (inc *Incremental) AddPage(p *Page) bool {
+	r := latest(p)
+	if r == nil {
+		return false
+	}
+	prev, ok := inc.pages[p.ID]
+	if ok && !newerRevision(r, latest(prev)) {
+		return false
+	}
+	if !ok {
+		inc.order = append(inc.order, p.ID)
+	}
+	p.Revisions = p.Revisions[len(p.Revisions)-1:]
+	inc.pages[p.ID] = p
+	return true
+}
+
+// Len gets the number of pages recorded.
+func (inc *Incremental) Len() int {
+	return len(inc.pages)
+}
+
+// Page gets the latest recorded version of a page, or nil.
+func (inc *Incremental) Page(id uint64) *Page {
+	return inc.pages[id]
+}
+
+// Changes reads every page of the base dump and calls fn with each
+// page the incremental dumps update, followed by the pages they add
+// in the order they were first seen.  Pages whose base revision is as
+// new as the incremental one aren't reported.
+func (inc *Incremental) Changes(base Parser, fn func(Change) error) error {
+	seen := make(map[uint64]bool, len(inc.pages))
+	for {
+		page, err := base.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		p, ok := inc.pages[page.ID]
+		if !ok {
+			continue
+		}
+		seen[page.ID] = true
+		prev := latest(page)
+		if prev != nil && !newerRevision(latest(p), prev) {
+			continue
+		}
+		if err := fn(Change{Kind: PageUpdated, Page: p, Previous: prev}); err != nil {
+			return err
+		}
+	}
+	for _, id := range inc.order {
+		if !seen[id] {
+			if err := fn(Change{Kind: PageAdded, Page: inc.pages[id]}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Merge gets a Parser over the base dump with the incremental dumps
+// applied: each base page is replaced by a newer incremental version
+// where there is one, and the added pages follow the base's pages.
+// Replaced pages only have their latest revision.
+func (inc *Incremental) Merge(base Parser) Parser {
+	return &mergedParser{Parser: base, inc: inc, seen: make(map[uint64]bool, len(inc.pages))}
+}
+
+type mergedParser struct {
+	Parser
+	inc   *Incremental
+	seen  map[uint64]bool
+	added int
+}
+
+func (m *mergedParser) Next() (*Page, error) {
+	if m.added == 0 {
+		page, err := m.Parser.Next()
+		if err != io.EOF {
+			if err != nil {
+				return page, err
+			}
+			if p, ok := m.inc.pages[page.ID]; ok {
+				m.seen[page.ID] = true
+				if r := latest(page); r == nil || newerRevision(latest(p), r) {
+					return p, nil
+				}
+			}
+			return page, nil
+		}
+	}
+	for m.added < len(m.inc.order) {
+		id := m.inc.order[m.added]
+		m.added++
+		if !m.seen[id] {
+			return m.inc.pages[id], nil
+		}
+	}
+	// Keep returning EOF.
+	m.added = len(m.inc.order) + 1
+	return nil, io.EOF
+}
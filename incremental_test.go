@@ -0,0 +1,132 @@
+package wikiparse
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func incrDump(pages ...string) Parser {
+	p, err := NewParser(strings.NewReader("<mediawiki><siteinfo><sitename>x</sitename></siteinfo>\n" +
+		strings.Join(pages, "") + "</mediawiki>"))
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func incrPage(id int, title string, revs ...int) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "<page><title>%s</title><id>%d</id>", title, id)
+	for _, r := range revs {
+		fmt.Fprintf(b, "<revision><id>%d</id><timestamp>2020-01-01T00:00:%02dZ</timestamp><text>rev %d</text></revision>",
+			r, r%60, r)
+	}
+	b.WriteString("</page>\n")
+	return b.String()
+}
+
+func testIncremental(t *testing.T) *Incremental {
+	inc := NewIncremental()
+	for _, p := range []Parser{
+		incrDump(incrPage(2, "Edited", 21, 22), incrPage(5, "Added", 50), incrPage(3, "Stale", 29)),
+		incrDump(incrPage(2, "Edited", 23), incrPage(6, "Also added", 60), incrPage(4, "Moved away", 41)),
+		// An older day applied late.
+		incrDump(incrPage(2, "Edited", 20), incrPage(5, "Added", 49)),
+	} {
+		if err := inc.Add(p); err != nil {
+			t.Fatalf("Error adding: %v", err)
+		}
+	}
+	return inc
+}
+
+func baseDump() Parser {
+	return incrDump(
+		incrPage(1, "Same", 10),
+		incrPage(2, "Edited", 20),
+		incrPage(3, "Stale", 30),
+		incrPage(4, "Moved", 40))
+}
+
+func TestIncremental(t *testing.T) {
+	t.Parallel()
+	inc := testIncremental(t)
+	if inc.Len() != 5 {
+		t.Errorf("Expected 5 pages, got %v", inc.Len())
+	}
+	if p := inc.Page(2); len(p.Revisions) != 1 || p.Revisions[0].ID != 23 {
+		t.Errorf("Expected page 2 at revision 23, got %+v", p)
+	}
+	if p := inc.Page(5); p.Revisions[0].ID != 50 {
+		t.Errorf("Expected page 5 at revision 50, got %+v", p)
+	}
+	if inc.Page(1) != nil {
+		t.Errorf("Expected no page 1")
+	}
+}
+
+func TestIncrementalChanges(t *testing.T) {
+	t.Parallel()
+	var got []string
+	err := testIncremental(t).Changes(baseDump(), func(c Change) error {
+		s := fmt.Sprintf("%v %v %v", c.Kind, c.Page.Title, c.Page.Revisions[0].ID)
+		if c.Previous != nil {
+			s += fmt.Sprintf(" from %v", c.Previous.ID)
+		}
+		got = append(got, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error getting changes: %v", err)
+	}
+	exp := "updated Edited 23 from 20|updated Moved away 41 from 40|added Added 50|added Also added 60"
+	if strings.Join(got, "|") != exp {
+		t.Errorf("Expected %v, got %v", exp, strings.Join(got, "|"))
+	}
+}
+
+func TestIncrementalMerge(t *testing.T) {
+	t.Parallel()
+	p := testIncremental(t).Merge(baseDump())
+	if p.SiteInfo().SiteName != "x" {
+		t.Errorf("Expected the base's site info, got %+v", p.SiteInfo())
+	}
+	var got []string
+	for {
+		page, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%v:%v", page.Title, page.Revisions[0].ID))
+	}
+	exp := "Same:10 Edited:23 Stale:30 Moved away:41 Added:50 Also added:60"
+	if strings.Join(got, " ") != exp {
+		t.Errorf("Expected %v, got %v", exp, strings.Join(got, " "))
+	}
+	if _, err := p.Next(); err != io.EOF {
+		t.Errorf("Expected EOF again, got %v", err)
+	}
+}
+
+func TestNewerRevision(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		a, b Revision
+		exp  bool
+	}{
+		{Revision{ID: 2}, Revision{ID: 1}, true},
+		{Revision{ID: 1}, Revision{ID: 1}, false},
+		{Revision{ID: 1, Timestamp: "2021"}, Revision{ID: 2, Timestamp: "2020"}, false},
+		{Revision{Timestamp: "2021-01-01T00:00:00Z"}, Revision{ID: 2, Timestamp: "2020-01-01T00:00:00Z"}, true},
+	}
+	for _, test := range tests {
+		if got := newerRevision(&test.a, &test.b); got != test.exp {
+			t.Errorf("Expected %v for %+v vs %+v, got %v", test.exp, test.a, test.b, got)
+		}
+	}
+}
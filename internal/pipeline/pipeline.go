@@ -0,0 +1,87 @@
+// Package pipeline processes a stream of items with several workers
+// while keeping their order.
+package pipeline
+
+import (
+	"io"
+)
+
+// Ordered reads items with next until it returns io.EOF, and passes
+// work's result for each to emit in the order they were read.  work
+// is run by the given number of goroutines, while keep, if not nil,
+// is called as each item is read and may skip it by returning false.
+//
+// The first error from next, keep or emit stops the pipeline: no more
+// items are read or emitted, and the error is returned.
+func Ordered[In, Out any](next func() (In, error), workers int,
+	keep func(In) (bool, error), work func(In) Out, emit func(Out) error) error {
+
+	if workers <= 0 {
+		workers = 1
+	}
+	type job struct {
+		in In
+		rv chan Out
+	}
+	jobs := make(chan job, workers)
+	results := make(chan chan Out, workers*4)
+	done := make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobs {
+				j.rv <- work(j.in)
+			}
+		}()
+	}
+
+	// Items are handed to the workers and their results queued in
+	// the order they were read.
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(results)
+		defer close(jobs)
+		defer close(readErr)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			in, err := next()
+			if err == nil && keep != nil {
+				var ok bool
+				if ok, err = keep(in); err == nil && !ok {
+					continue
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr <- err
+				}
+				return
+			}
+			j := job{in, make(chan Out, 1)}
+			select {
+			case results <- j.rv:
+			case <-done:
+				return
+			}
+			jobs <- j
+		}
+	}()
+
+	var err error
+	for rv := range results {
+		if err != nil {
+			continue
+		}
+		if err = emit(<-rv); err != nil {
+			close(done)
+		}
+	}
+	if rerr := <-readErr; err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func counter(n int) (func() (int, error), *int) {
+	read := 0
+	return func() (int, error) {
+		if read == n {
+			return 0, io.EOF
+		}
+		read++
+		return read, nil
+	}, &read
+}
+
+func TestOrdered(t *testing.T) {
+	t.Parallel()
+	next, _ := counter(1000)
+	var got []int
+	err := Ordered(next, 8,
+		func(i int) (bool, error) { return i%10 != 0, nil },
+		func(i int) int { return i * 2 },
+		func(i int) error { got = append(got, i); return nil })
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	var exp []int
+	for i := 1; i <= 1000; i++ {
+		if i%10 != 0 {
+			exp = append(exp, i*2)
+		}
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestOrderedErrors(t *testing.T) {
+	t.Parallel()
+	oops := errors.New("oops")
+	id := func(i int) int { return i }
+
+	next, read := counter(100000)
+	emitted := 0
+	err := Ordered(next, 4, nil, id, func(i int) error {
+		if emitted++; i == 10 {
+			return oops
+		}
+		return nil
+	})
+	if err != oops || emitted != 10 || *read == 100000 {
+		t.Errorf("Expected to stop at the emit error, got %v after %v emitted, %v read", err, emitted, *read)
+	}
+
+	next, read = counter(100000)
+	err = Ordered(next, 4, func(i int) (bool, error) {
+		if i == 10 {
+			return false, oops
+		}
+		return false, nil
+	}, id, func(int) error { return nil })
+	if err != oops || *read != 10 {
+		t.Errorf("Expected to stop at the keep error, got %v after %v read", err, *read)
+	}
+
+	err = Ordered(func() (int, error) { return 0, oops }, 4, nil, id, func(int) error { return nil })
+	if err != oops {
+		t.Errorf("Expected %v, got %v", oops, err)
+	}
+}
@@ -0,0 +1,232 @@
+package wikiparse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExportFields selects the optional parts of an exported page.  Page
+// metadata and the latest revision's metadata are always included.
+type ExportFields uint
+
+const (
+	// ExportText includes the latest revision's wikitext.
+	ExportText ExportFields = 1 << iota
+	// ExportPlainText includes the article's plain text.
+	ExportPlainText
+	// ExportLinks includes the targets of the article's links.
+	ExportLinks
+	// ExportCategories includes the article's categories.
+	ExportCategories
+	// ExportCoords includes the article's coordinates.
+	ExportCoords
+	// ExportTemplates includes the templates the article uses.
+	ExportTemplates
+
+	// ExportMetadata includes only the metadata.
+	ExportMetadata ExportFields = 0
+	// ExportAll includes everything.
+	ExportAll = ExportText | ExportPlainText | ExportLinks |
+		ExportCategories | ExportCoords | ExportTemplates
+)
+
+var exportFieldNames = []struct {
+	name string
+	f    ExportFields
+}{
+	{"text", ExportText},
+	{"plaintext", ExportPlainText},
+	{"links", ExportLinks},
+	{"categories", ExportCategories},
+	{"coords", ExportCoords},
+	{"templates", ExportTemplates},
+}
+
+// ParseExportFields parses a comma separated list of field names
+// ("text", "plaintext", "links", "categories", "coords" and
+// "templates"), or "all" or "metadata".
+func ParseExportFields(s string) (ExportFields, error) {
+	var rv ExportFields
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "", "metadata":
+			continue
+		case "all":
+			rv |= ExportAll
+			continue
+		}
+		found := false
+		for _, f := range exportFieldNames {
+			if f.name == name {
+				rv |= f.f
+				found = true
+			}
+		}
+		if !found {
+			return rv, fmt.Errorf("unknown export field %q", name)
+		}
+	}
+	return rv, nil
+}
+
+func (f ExportFields) String() string {
+	var names []string
+	for _, n := range exportFieldNames {
+		if f&n.f != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "metadata"
+	}
+	return strings.Join(names, ",")
+}
+
+// An ExportCoord is a coordinate in an exported page.
+type ExportCoord struct {
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Source  string  `json:"source"`
+	Display string  `json:"display,omitempty"`
+	Name    string  `json:"name,omitempty"`
+	Globe   string  `json:"globe,omitempty"`
+}
+
+// A PageRecord is the exported form of a page.  The optional fields
+// are nil unless selected, and present (even if empty) when they are.
+type PageRecord struct {
+	ID            uint64 `json:"id"`
+	Title         string `json:"title"`
+	Ns            uint64 `json:"ns"`
+	Redirect      string `json:"redirect,omitempty"`
+	URL           string `json:"url,omitempty"`
+	RevisionID    uint64 `json:"revid"`
+	Timestamp     string `json:"timestamp"`
+	Contributor   string `json:"contributor"`
+	ContributorID uint64 `json:"contributorid"`
+	Comment       string `json:"comment,omitempty"`
+	Bytes         int    `json:"bytes"`
+
+	Text       *string       `json:"text,omitempty"`
+	PlainText  *string       `json:"plaintext,omitempty"`
+	Links      []string      `json:"links,omitempty"`
+	Categories []string      `json:"categories,omitempty"`
+	Coords     []ExportCoord `json:"coords,omitempty"`
+	Templates  []string      `json:"templates,omitempty"`
+}
+
+// nonNil makes sure a selected list is written as [] rather than left
+// out.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// MarshalJSON writes selected lists that are empty as [], where
+// omitempty would leave them out.
+func (r PageRecord) MarshalJSON() ([]byte, error) {
+	type record PageRecord
+	out := struct {
+		record
+		Links      *[]string      `json:"links,omitempty"`
+		Categories *[]string      `json:"categories,omitempty"`
+		Coords     *[]ExportCoord `json:"coords,omitempty"`
+		Templates  *[]string      `json:"templates,omitempty"`
+	}{record: record(r)}
+	if r.Links != nil {
+		out.Links = &r.Links
+	}
+	if r.Categories != nil {
+		out.Categories = &r.Categories
+	}
+	if r.Coords != nil {
+		out.Coords = &r.Coords
+	}
+	if r.Templates != nil {
+		out.Templates = &r.Templates
+	}
+
+	buf := &bytes.Buffer{}
+	e := json.NewEncoder(buf)
+	e.SetEscapeHTML(false)
+	err := e.Encode(out)
+	return bytes.TrimRight(buf.Bytes(), "\n"), err
+}
+
+// NewPageRecord builds the exported form of a page from its latest
+// revision.
+func NewPageRecord(p *Page, fields ExportFields, si SiteInfo) PageRecord {
+	rv := PageRecord{
+		ID:       p.ID,
+		Title:    p.Title,
+		Ns:       p.Ns,
+		Redirect: p.Redir.Title,
+		URL:      si.ArticleURL(p.Title),
+	}
+	var text string
+	if len(p.Revisions) > 0 {
+		r := p.Revisions[len(p.Revisions)-1]
+		rv.RevisionID = r.ID
+		rv.Timestamp = r.Timestamp
+		rv.Contributor = r.Contributor.Name()
+		rv.ContributorID = r.Contributor.ID
+		rv.Comment = r.Comment
+		text = r.Text
+	}
+	rv.Bytes = len(text)
+
+	if fields&ExportText != 0 {
+		rv.Text = &text
+	}
+	if fields&ExportPlainText != 0 {
+		pt := PlainText(text)
+		rv.PlainText = &pt
+	}
+	if fields&ExportLinks != 0 {
+		rv.Links = nonNil(FindLinks(text))
+	}
+	if fields&ExportCategories != 0 {
+		rv.Categories = nonNil(FindCategories(text))
+	}
+	if fields&ExportCoords != 0 {
+		rv.Coords = []ExportCoord{}
+		for _, c := range FindLocations(text) {
+			rv.Coords = append(rv.Coords, ExportCoord{
+				Lat: c.Lat, Lon: c.Lon, Source: c.Source.String(),
+				Display: c.Display, Name: c.Name, Globe: c.Params.Globe,
+			})
+		}
+	}
+	if fields&ExportTemplates != 0 {
+		rv.Templates = nonNil(FindTemplates(text))
+	}
+	return rv
+}
+
+// A JSONLEncoder writes pages as JSON Lines, one PageRecord per line.
+type JSONLEncoder struct {
+	// Fields selects the optional fields to include.
+	Fields ExportFields
+	// SiteInfo is used to build page URLs.
+	SiteInfo SiteInfo
+
+	e *json.Encoder
+}
+
+// NewJSONLEncoder gets a JSONLEncoder writing the given fields to w.
+func NewJSONLEncoder(w io.Writer, fields ExportFields, si SiteInfo) *JSONLEncoder {
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	return &JSONLEncoder{Fields: fields, SiteInfo: si, e: e}
+}
+
+// Encode writes a page.
+func (e *JSONLEncoder) Encode(p *Page) error {
+	return e.e.Encode(NewPageRecord(p, e.Fields, e.SiteInfo))
+}
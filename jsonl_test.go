@@ -0,0 +1,125 @@
+package wikiparse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExportFields(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in  string
+		exp ExportFields
+		str string
+	}{
+		{"", ExportMetadata, "metadata"},
+		{"metadata", ExportMetadata, "metadata"},
+		{"Text, links", ExportText | ExportLinks, "text,links"},
+		{"all", ExportAll, "text,plaintext,links,categories,coords,templates"},
+		{"templates,categories,coords,plaintext", ExportAll &^ (ExportText | ExportLinks),
+			"plaintext,categories,coords,templates"},
+	}
+	for _, test := range tests {
+		f, err := ParseExportFields(test.in)
+		if err != nil || f != test.exp || f.String() != test.str {
+			t.Errorf("Expected %v (%v) for %q, got %v (%v), %v", test.exp, test.str, test.in, f, f.String(), err)
+		}
+	}
+	if f, err := ParseExportFields("text,bogus"); err == nil {
+		t.Errorf("Expected error for bogus field, got %v", f)
+	}
+}
+
+var exportPage = &Page{
+	Title: "Peggys Point",
+	ID:    42,
+	Revisions: []Revision{
+		{ID: 1, Text: "old"},
+		{ID: 2, Timestamp: "2024-01-01T00:00:00Z",
+			Contributor: Contributor{ID: 7, Username: "Mapper"}, Comment: "coords",
+			Text: "A [[lighthouse]] in {{convert|1|km}}.\n{{coord|44|29|30|N|63|55|8|W|display=title}}\n[[Category:Lighthouses]]"},
+	},
+}
+
+func TestNewPageRecordMetadata(t *testing.T) {
+	t.Parallel()
+	b, err := json.Marshal(NewPageRecord(exportPage, ExportMetadata, geoSite))
+	if err != nil {
+		t.Fatalf("Error marshaling: %v", err)
+	}
+	exp := `{"id":42,"title":"Peggys Point","ns":0,"url":"https://en.wikipedia.org/wiki/Peggys_Point",` +
+		`"revid":2,"timestamp":"2024-01-01T00:00:00Z","contributor":"Mapper","contributorid":7,` +
+		`"comment":"coords","bytes":107}`
+	if string(b) != exp {
+		t.Errorf("Expected\n%s\ngot\n%s", exp, b)
+	}
+
+	anon := &Page{Title: "Anon", Revisions: []Revision{{Contributor: Contributor{IP: "192.0.2.1"}}}}
+	if r := NewPageRecord(anon, ExportMetadata, SiteInfo{}); r.Contributor != "192.0.2.1" {
+		t.Errorf("Expected IP contributor, got %q", r.Contributor)
+	}
+}
+
+func TestNewPageRecordFields(t *testing.T) {
+	t.Parallel()
+	r := NewPageRecord(exportPage, ExportAll, SiteInfo{})
+	if r.Text == nil || *r.Text != exportPage.Revisions[1].Text {
+		t.Errorf("Expected latest text, got %v", r.Text)
+	}
+	if r.PlainText == nil || !strings.HasPrefix(*r.PlainText, "A lighthouse in") {
+		t.Errorf("Unexpected plain text: %v", r.PlainText)
+	}
+	if !reflect.DeepEqual(r.Links, []string{"lighthouse", "Category:Lighthouses"}) {
+		t.Errorf("Unexpected links: %v", r.Links)
+	}
+	if !reflect.DeepEqual(r.Categories, []string{"Lighthouses"}) {
+		t.Errorf("Unexpected categories: %v", r.Categories)
+	}
+	if !reflect.DeepEqual(r.Templates, []string{"Convert", "Coord"}) {
+		t.Errorf("Unexpected templates: %v", r.Templates)
+	}
+	if len(r.Coords) != 1 || r.Coords[0].Source != "coord" || r.Coords[0].Display != "title" {
+		t.Errorf("Unexpected coords: %+v", r.Coords)
+	}
+
+	// Selected fields are present even when empty.
+	b, err := json.Marshal(NewPageRecord(&Page{Title: "Empty"}, ExportAll, SiteInfo{}))
+	if err != nil {
+		t.Fatalf("Error marshaling: %v", err)
+	}
+	for _, k := range []string{`"text":""`, `"plaintext":""`, `"links":[]`, `"categories":[]`, `"coords":[]`, `"templates":[]`} {
+		if !bytes.Contains(b, []byte(k)) {
+			t.Errorf("Expected %s in %s", k, b)
+		}
+	}
+}
+
+func TestJSONLEncoder(t *testing.T) {
+	t.Parallel()
+	buf := &bytes.Buffer{}
+	e := NewJSONLEncoder(buf, ExportCategories, SiteInfo{})
+	for _, p := range []*Page{exportPage, {Title: "A & B <c>", Redir: Redirect{Title: "C"}}} {
+		if err := e.Encode(p); err != nil {
+			t.Fatalf("Error encoding: %v", err)
+		}
+	}
+	s := bufio.NewScanner(buf)
+	var recs []PageRecord
+	for s.Scan() {
+		var r PageRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("Bad line %q: %v", s.Text(), err)
+		}
+		if strings.Contains(s.Text(), `\u0026`) {
+			t.Errorf("Expected unescaped HTML in %s", s.Text())
+		}
+		recs = append(recs, r)
+	}
+	if len(recs) != 2 || recs[0].Categories[0] != "Lighthouses" || recs[1].Redirect != "C" {
+		t.Errorf("Unexpected records: %+v", recs)
+	}
+}
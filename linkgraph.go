@@ -0,0 +1,352 @@
+package wikiparse
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const linkGraphMagic = "WPLG"
+
+const linkGraphVersion = 1
+
+// The largest graph ReadLinkGraph accepts, well beyond any wiki's.
+const (
+	maxLinkGraphNodes = 1 << 31
+	maxLinkGraphEdges = 1 << 36
+)
+
+// ErrBadLinkGraph is returned when reading a link graph that wasn't
+// written by LinkGraph.WriteTo.
+var ErrBadLinkGraph = errors.New("not a link graph")
+
+// A LinkGraph is the graph of links between pages, stored in
+// compressed sparse row form.  Nodes are numbered densely from 0 in
+// the order their pages were seen.
+type LinkGraph struct {
+	// PageIDs and Titles hold the page id and title of each node.
+	// Titles may be nil if the graph was read without them.
+	PageIDs []uint64
+	Titles  []string
+
+	// Node n links to Targets[Offsets[n]:Offsets[n+1]].
+	Offsets []uint64
+	Targets []uint32
+
+	inOffsets []uint64
+	inTargets []uint32
+	byTitle   map[string]uint32
+	byID      map[uint64]uint32
+}
+
+// A LinkGraphBuilder builds a LinkGraph from pages.  Links can't be
+// resolved until every page and redirect has been seen, so the
+// builder holds each page's link titles until Build.
+type LinkGraphBuilder struct {
+	// Namespaces limits the graph to pages in the given
+	// namespaces.  All pages are included if it's empty.
+	Namespaces []uint64
+
+	redirects *RedirectResolver
+	pageIDs   []uint64
+	titles    []string
+	names     map[string]uint32
+	nameList  []string
+	links     []uint32
+	ends      []int
+}
+
+// NewLinkGraphBuilder gets an empty LinkGraphBuilder.
+func NewLinkGraphBuilder() *LinkGraphBuilder {
+	return &LinkGraphBuilder{
+		redirects: NewRedirectResolver(),
+		names:     map[string]uint32{},
+	}
+}
+
+// BuildLinkGraph builds the link graph of a dump in one pass.
+func BuildLinkGraph(p Parser, namespaces ...uint64) (*LinkGraph, error) {
+	b := NewLinkGraphBuilder()
+	b.Namespaces = namespaces
+	for {
+		page, err := p.Next()
+		if err == io.EOF {
+			return b.Build(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.AddPage(page)
+	}
+}
+
+func (b *LinkGraphBuilder) intern(name string) uint32 {
+	i, ok := b.names[name]
+	if !ok {
+		i = uint32(len(b.nameList))
+		b.names[name] = i
+		b.nameList = append(b.nameList, name)
+	}
+	return i
+}
+
+// AddPage adds a page (or redirect) to the graph, with the links in
+// its latest revision.
+func (b *LinkGraphBuilder) AddPage(p *Page) {
+	if b.redirects.AddPage(p) {
+		return
+	}
+	if len(b.Namespaces) > 0 {
+		found := false
+		for _, ns := range b.Namespaces {
+			found = found || ns == p.Ns
+		}
+		if !found {
+			return
+		}
+	}
+	b.pageIDs = append(b.pageIDs, p.ID)
+	b.titles = append(b.titles, canonicalTitle(p.Title))
+	if len(p.Revisions) > 0 {
+		for _, l := range FindLinks(p.Revisions[len(p.Revisions)-1].Text) {
+			if title, _ := splitTarget(l); title != "" {
+				b.links = append(b.links, b.intern(title))
+			}
+		}
+	}
+	b.ends = append(b.ends, len(b.links))
+}
+
+// Build resolves the links through redirects and builds the graph.
+// Links to pages that aren't in the graph, duplicate links and links
+// from a page to itself are dropped.
+func (b *LinkGraphBuilder) Build() *LinkGraph {
+	g := &LinkGraph{
+		PageIDs: b.pageIDs,
+		Titles:  b.titles,
+		Offsets: make([]uint64, 1, len(b.titles)+1),
+	}
+	g.index()
+
+	// Map each distinct link title to its node, or -1.
+	resolved := make([]int64, len(b.nameList))
+	for i, name := range b.nameList {
+		resolved[i] = -1
+		if n, ok := g.byTitle[b.redirects.Resolve(name).Title]; ok {
+			resolved[i] = int64(n)
+		}
+	}
+
+	start := 0
+	var targets []uint32
+	for n, end := range b.ends {
+		targets = targets[:0]
+		for _, l := range b.links[start:end] {
+			if t := resolved[l]; t >= 0 && t != int64(n) {
+				targets = append(targets, uint32(t))
+			}
+		}
+		start = end
+		g.Targets = append(g.Targets, sortedUnique(targets)...)
+		g.Offsets = append(g.Offsets, uint64(len(g.Targets)))
+	}
+	g.invert()
+	return g
+}
+
+func sortedUnique(a []uint32) []uint32 {
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	rv := a[:0]
+	for i, x := range a {
+		if i == 0 || x != a[i-1] {
+			rv = append(rv, x)
+		}
+	}
+	return rv
+}
+
+func (g *LinkGraph) index() {
+	g.byTitle = make(map[string]uint32, len(g.Titles))
+	for i, t := range g.Titles {
+		g.byTitle[t] = uint32(i)
+	}
+	g.byID = make(map[uint64]uint32, len(g.PageIDs))
+	for i, id := range g.PageIDs {
+		g.byID[id] = uint32(i)
+	}
+}
+
+// invert builds the in-link index.
+func (g *LinkGraph) invert() {
+	counts := make([]uint64, g.Len()+1)
+	for _, t := range g.Targets {
+		counts[t+1]++
+	}
+	for i := 1; i < len(counts); i++ {
+		counts[i] += counts[i-1]
+	}
+	g.inOffsets = counts
+	g.inTargets = make([]uint32, len(g.Targets))
+	next := append([]uint64(nil), counts[:g.Len()]...)
+	for n := 0; n < g.Len(); n++ {
+		for _, t := range g.Targets[g.Offsets[n]:g.Offsets[n+1]] {
+			g.inTargets[next[t]] = uint32(n)
+			next[t]++
+		}
+	}
+}
+
+// Len gets the number of nodes in the graph.
+func (g *LinkGraph) Len() int {
+	return len(g.Offsets) - 1
+}
+
+// Edges gets the number of links in the graph.
+func (g *LinkGraph) Edges() int {
+	return len(g.Targets)
+}
+
+// Node gets the node of the page with the given title.
+func (g *LinkGraph) Node(title string) (uint32, bool) {
+	n, ok := g.byTitle[canonicalTitle(title)]
+	return n, ok
+}
+
+// NodeByPageID gets the node of the page with the given id.
+func (g *LinkGraph) NodeByPageID(id uint64) (uint32, bool) {
+	n, ok := g.byID[id]
+	return n, ok
+}
+
+// OutLinks gets the nodes a node links to, in order.  The returned
+// slice must not be modified.
+func (g *LinkGraph) OutLinks(n uint32) []uint32 {
+	return g.Targets[g.Offsets[n]:g.Offsets[n+1]]
+}
+
+// InLinks gets the nodes that link to a node, in order.  The returned
+// slice must not be modified.
+func (g *LinkGraph) InLinks(n uint32) []uint32 {
+	return g.inTargets[g.inOffsets[n]:g.inOffsets[n+1]]
+}
+
+// WriteTo writes the graph structure and page ids in a compact binary
+// form readable by ReadLinkGraph.  All values are little endian:
+//
+//	"WPLG" version:uint32 nodes:uint32 edges:uint64
+//	offsets:[nodes+1]uint64 targets:[edges]uint32 pageids:[nodes]uint64
+//
+// Titles are written separately by WriteTitles.
+func (g *LinkGraph) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	bw.WriteString(linkGraphMagic)
+	for _, v := range []interface{}{
+		uint32(linkGraphVersion), uint32(g.Len()), uint64(g.Edges()),
+		g.Offsets, g.Targets, g.PageIDs,
+	} {
+		if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+			return cw.n, err
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// WriteTitles writes the node to page mapping as tab separated
+// lines of node, page id and title.
+func (g *LinkGraph) WriteTitles(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for n, t := range g.Titles {
+		fmt.Fprintf(bw, "%d\t%d\t%s\n", n, g.PageIDs[n], t)
+	}
+	return bw.Flush()
+}
+
+// readLinkGraphArray reads n little endian values.  They're read a
+// chunk at a time, so a corrupt count runs out of input rather than
+// memory.
+func readLinkGraphArray[T uint32 | uint64](r io.Reader, n uint64) ([]T, error) {
+	const chunk = 1 << 16
+	rv := make([]T, 0, min(n, chunk))
+	for uint64(len(rv)) < n {
+		buf := make([]T, min(n-uint64(len(rv)), chunk))
+		if err := binary.Read(r, binary.LittleEndian, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, ErrBadLinkGraph
+			}
+			return nil, err
+		}
+		rv = append(rv, buf...)
+	}
+	return rv, nil
+}
+
+// ReadLinkGraph reads a graph written by LinkGraph.WriteTo, and its
+// titles from WriteTitles if titles isn't nil.
+func ReadLinkGraph(r io.Reader, titles io.Reader) (*LinkGraph, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(linkGraphMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != linkGraphMagic {
+		return nil, ErrBadLinkGraph
+	}
+	var hdr struct {
+		Version, Nodes uint32
+		Edges          uint64
+	}
+	if err := binary.Read(br, binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if hdr.Version != linkGraphVersion || hdr.Nodes > maxLinkGraphNodes || hdr.Edges > maxLinkGraphEdges {
+		return nil, ErrBadLinkGraph
+	}
+	g := &LinkGraph{}
+	var err error
+	if g.Offsets, err = readLinkGraphArray[uint64](br, uint64(hdr.Nodes)+1); err != nil {
+		return nil, err
+	}
+	if g.Targets, err = readLinkGraphArray[uint32](br, hdr.Edges); err != nil {
+		return nil, err
+	}
+	if g.PageIDs, err = readLinkGraphArray[uint64](br, uint64(hdr.Nodes)); err != nil {
+		return nil, err
+	}
+	if g.Offsets[0] != 0 || g.Offsets[hdr.Nodes] != hdr.Edges {
+		return nil, ErrBadLinkGraph
+	}
+	for n := 0; n < g.Len(); n++ {
+		if g.Offsets[n] > g.Offsets[n+1] {
+			return nil, ErrBadLinkGraph
+		}
+	}
+	for _, t := range g.Targets {
+		if t >= hdr.Nodes {
+			return nil, ErrBadLinkGraph
+		}
+	}
+
+	if titles != nil {
+		g.Titles = make([]string, hdr.Nodes)
+		s := bufio.NewScanner(titles)
+		s.Buffer(nil, 1<<20)
+		for s.Scan() {
+			parts := strings.SplitN(s.Text(), "\t", 3)
+			n, err := strconv.ParseUint(parts[0], 10, 32)
+			if len(parts) != 3 || err != nil || n >= uint64(hdr.Nodes) {
+				return nil, fmt.Errorf("bad title line: %q", s.Text())
+			}
+			g.Titles[n] = parts[2]
+		}
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+	g.index()
+	g.invert()
+	return g, nil
+}
@@ -0,0 +1,170 @@
+package wikiparse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const linkGraphDump = `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Halifax</title><ns>0</ns><id>10</id><revision><text>Near [[Peggy's Cove]] and [[Dartmouth|across the harbour]]. [[Halifax]] [[Nowhere]]</text></revision></page>
+<page><title>Peggys Cove</title><ns>0</ns><id>20</id><revision><text>Part of [[halifax]], see [[Peggys Point#History|the light]] and [[Halifax]] again.</text></revision></page>
+<page><title>Peggy's Cove</title><ns>0</ns><id>21</id><redirect title="Peggys Cove" /><revision><text>#REDIRECT [[Peggys Cove]]</text></revision></page>
+<page><title>Peggys Point</title><ns>0</ns><id>30</id><revision><text>A lighthouse in [[Peggy's Cove]].</text></revision></page>
+<page><title>Dartmouth</title><ns>0</ns><id>40</id><revision><text>See [[Halifax]].</text></revision><revision><text>Nothing here.</text></revision></page>
+<page><title>Talk:Halifax</title><ns>1</ns><id>50</id><revision><text>[[Halifax]] [[Dartmouth]]</text></revision></page>
+</mediawiki>`
+
+func buildTestLinkGraph(t *testing.T, namespaces ...uint64) *LinkGraph {
+	p, err := NewParser(strings.NewReader(linkGraphDump))
+	if err != nil {
+		t.Fatalf("Error creating parser: %v", err)
+	}
+	g, err := BuildLinkGraph(p, namespaces...)
+	if err != nil {
+		t.Fatalf("Error building graph: %v", err)
+	}
+	return g
+}
+
+func checkLinkGraph(t *testing.T, g *LinkGraph) {
+	if g.Len() != 4 || g.Edges() != 5 {
+		t.Fatalf("Expected 4 nodes and 5 edges, got %v and %v", g.Len(), g.Edges())
+	}
+	node := func(title string) uint32 {
+		n, ok := g.Node(title)
+		if !ok {
+			t.Fatalf("No node for %v", title)
+		}
+		return n
+	}
+	halifax, cove, point, dartmouth := node("Halifax"), node("Peggys_Cove"), node("Peggys Point"), node("Dartmouth")
+	tests := []struct {
+		n       uint32
+		out, in []uint32
+	}{
+		{halifax, []uint32{cove, dartmouth}, []uint32{cove}},
+		{cove, []uint32{halifax, point}, []uint32{halifax, point}},
+		{point, []uint32{cove}, []uint32{cove}},
+		{dartmouth, []uint32{}, []uint32{halifax}},
+	}
+	for _, test := range tests {
+		if got := g.OutLinks(test.n); !reflect.DeepEqual(got, test.out) {
+			t.Errorf("Expected out links %v from %v, got %v", test.out, g.Titles[test.n], got)
+		}
+		if got := g.InLinks(test.n); !reflect.DeepEqual(got, test.in) {
+			t.Errorf("Expected in links %v to %v, got %v", test.in, g.Titles[test.n], got)
+		}
+	}
+	if n, ok := g.NodeByPageID(20); !ok || n != cove {
+		t.Errorf("Expected node %v for page 20, got %v", cove, n)
+	}
+	if _, ok := g.Node("Peggy's Cove"); ok {
+		t.Errorf("Redirects shouldn't be nodes")
+	}
+}
+
+func TestBuildLinkGraph(t *testing.T) {
+	t.Parallel()
+	checkLinkGraph(t, buildTestLinkGraph(t, 0))
+
+	g := buildTestLinkGraph(t)
+	if g.Len() != 5 || g.Edges() != 7 {
+		t.Errorf("Expected 5 nodes and 7 edges with talk pages, got %v and %v", g.Len(), g.Edges())
+	}
+}
+
+func TestLinkGraphPersist(t *testing.T) {
+	t.Parallel()
+	g := buildTestLinkGraph(t, 0)
+	graph, titles := &bytes.Buffer{}, &bytes.Buffer{}
+	n, err := g.WriteTo(graph)
+	if err != nil || n != int64(graph.Len()) {
+		t.Fatalf("Error writing graph: %v (%v bytes of %v)", err, n, graph.Len())
+	}
+	// header, 5 offsets, 5 targets, 4 page ids
+	if exp := 4 + 4 + 4 + 8 + 5*8 + 5*4 + 4*8; graph.Len() != exp {
+		t.Errorf("Expected %v bytes, got %v", exp, graph.Len())
+	}
+	if err := g.WriteTitles(titles); err != nil {
+		t.Fatalf("Error writing titles: %v", err)
+	}
+	if !strings.HasPrefix(titles.String(), "0\t10\tHalifax\n1\t20\tPeggys Cove\n") {
+		t.Errorf("Unexpected titles: %q", titles)
+	}
+
+	g2, err := ReadLinkGraph(bytes.NewReader(graph.Bytes()), titles)
+	if err != nil {
+		t.Fatalf("Error reading graph: %v", err)
+	}
+	checkLinkGraph(t, g2)
+
+	g3, err := ReadLinkGraph(bytes.NewReader(graph.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("Error reading graph without titles: %v", err)
+	}
+	if n, ok := g3.NodeByPageID(30); !ok || !reflect.DeepEqual(g3.InLinks(n), []uint32{1}) {
+		t.Errorf("Unexpected in links for page 30: %v", g3.InLinks(n))
+	}
+}
+
+func TestReadLinkGraphErrors(t *testing.T) {
+	t.Parallel()
+	g := buildTestLinkGraph(t, 0)
+	buf := &bytes.Buffer{}
+	if _, err := g.WriteTo(buf); err != nil {
+		t.Fatalf("Error writing graph: %v", err)
+	}
+	good := buf.Bytes()
+
+	corrupt := append([]byte(nil), good...)
+	corrupt[len(good)-4*8-1] = 0xff // the last target
+	tests := []struct {
+		name         string
+		graph, title string
+	}{
+		{"empty", "", ""},
+		{"magic", "XXXX" + string(good[4:]), ""},
+		{"truncated", string(good[:len(good)-3]), ""},
+		{"target", string(corrupt), ""},
+		{"titles", string(good), "zero\t10\tHalifax\n"},
+	}
+	for _, test := range tests {
+		var titles *strings.Reader
+		if test.title != "" {
+			titles = strings.NewReader(test.title)
+		}
+		var err error
+		if titles != nil {
+			_, err = ReadLinkGraph(strings.NewReader(test.graph), titles)
+		} else {
+			_, err = ReadLinkGraph(strings.NewReader(test.graph), nil)
+		}
+		if err == nil {
+			t.Errorf("Expected error reading %v graph", test.name)
+		}
+	}
+
+	// Corrupt headers and offsets.
+	header := func(fn func(b []byte)) string {
+		b := append([]byte(nil), good...)
+		fn(b)
+		return string(b)
+	}
+	for name, graph := range map[string]string{
+		"nodes":        header(func(b []byte) { binary.LittleEndian.PutUint32(b[8:], 0xffffffff) }),
+		"huge edges":   header(func(b []byte) { binary.LittleEndian.PutUint64(b[12:], 1<<62) }),
+		"edges":        header(func(b []byte) { binary.LittleEndian.PutUint64(b[12:], 1<<35) }),
+		"first offset": header(func(b []byte) { binary.LittleEndian.PutUint64(b[20:], 1) }),
+		"last offset": header(func(b []byte) {
+			n := binary.LittleEndian.Uint32(b[8:])
+			binary.LittleEndian.PutUint64(b[20+8*int(n):], 0)
+		}),
+	} {
+		if _, err := ReadLinkGraph(strings.NewReader(graph), nil); err != ErrBadLinkGraph {
+			t.Errorf("Expected ErrBadLinkGraph reading graph with bad %v, got %v", name, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package wikiparse
 
 import (
 	"regexp"
+	"strings"
 )
 
 var linkRE *regexp.Regexp
@@ -22,3 +23,30 @@ func FindLinks(text string) []string {
 
 	return rv
 }
+
+// FindCategories finds the categories an article is in, without the
+// "Category:" prefix, in the order they first appear.  Links to a
+// category page, such as [[:Category:Lighthouses]], aren't included.
+func FindCategories(text string) []string {
+	var rv []string
+	seen := map[string]bool{}
+	Walk(ParseWikitext(text), func(n *Node) bool {
+		if n.Kind == CommentNode {
+			return false
+		}
+		if n.Kind != LinkNode {
+			return true
+		}
+		name := strings.TrimSpace(n.Name)
+		i := strings.IndexByte(name, ':')
+		if i < 0 || !strings.EqualFold(strings.TrimSpace(name[:i]), "category") {
+			return true
+		}
+		if c := canonicalTitle(name[i+1:]); c != "" && !seen[c] {
+			seen[c] = true
+			rv = append(rv, c)
+		}
+		return true
+	})
+	return rv
+}
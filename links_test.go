@@ -132,3 +132,18 @@ func TestLinkSearch(t *testing.T) {
 		t.Fatalf("Expected %#v, got %#v", exp, found)
 	}
 }
+
+func TestFindCategories(t *testing.T) {
+	t.Parallel()
+	text := `Peggy's Point is a [[lighthouse]].  See also [[:Category:Lighthouses]].
+<!-- [[Category:Hidden]] --><nowiki>[[Category:Escaped]]</nowiki>
+{{DEFAULTSORT:Peggys Point}}
+[[Category:Lighthouses in Nova Scotia|Peggys Point]]
+[[category: buildings_and structures]]
+[[Category:Lighthouses in Nova Scotia]]
+[[fr:Phare de Peggy's Point]]`
+	exp := []string{"Lighthouses in Nova Scotia", "Buildings and structures"}
+	if got := FindCategories(text); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %q, got %q", exp, got)
+	}
+}
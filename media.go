@@ -0,0 +1,129 @@
+package wikiparse
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownSite is returned when a site's base URL can't be mapped
+// to an upload path.
+var ErrUnknownSite = errors.New("can't determine site from base URL")
+
+// A MediaURLBuilder builds URLs for files and thumbnails on a
+// Wikimedia style upload server.
+//
+// Files may be hosted either on the project itself (e.g. non-free
+// images on en.wikipedia) or on Commons, so look up a file with the
+// project's builder and fall back to CommonsMedia.
+type MediaURLBuilder struct {
+	// Scheme defaults to https.
+	Scheme string
+	// Host defaults to upload.wikimedia.org.
+	Host string
+	// Path is the project's directory on the upload server, e.g.
+	// "wikipedia/en" or "wikipedia/commons".
+	Path string
+}
+
+// CommonsMedia builds URLs for files hosted on Wikimedia Commons.
+var CommonsMedia = MediaURLBuilder{Path: "wikipedia/commons"}
+
+// Upload paths of the sites that aren't named <lang>.<project>.org.
+// Wikidata doesn't host files, using Commons'.
+var siteMediaPaths = map[string]string{
+	"mediawiki.org":  "wikipedia/mediawiki",
+	"wikidata.org":   "wikipedia/commons",
+	"wikisource.org": "wikipedia/sources",
+}
+
+// NewMediaURLBuilder gets a MediaURLBuilder for the site described by
+// the given SiteInfo, using its base URL (e.g.
+// https://en.wikipedia.org/wiki/Main_Page) to find the project and
+// language.  Mobile hosts such as en.m.wikipedia.org map to the same
+// project, and the URLs are always https, whatever the base URL uses.
+// Sites without a language, such as www.mediawiki.org, www.wikidata.org
+// and the multilingual wikisource.org, are known by name.
+func NewMediaURLBuilder(si SiteInfo) (*MediaURLBuilder, error) {
+	u, err := url.Parse(si.Base)
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for _, p := range strings.Split(strings.ToLower(u.Hostname()), ".") {
+		if p != "m" && p != "www" {
+			parts = append(parts, p)
+		}
+	}
+	if p, ok := siteMediaPaths[strings.Join(parts, ".")]; ok {
+		return &MediaURLBuilder{Path: p}, nil
+	}
+	if len(parts) < 3 {
+		return nil, ErrUnknownSite
+	}
+	sub, project := parts[len(parts)-3], parts[len(parts)-2]
+	if project == "wikimedia" {
+		// commons, meta, species, etc. live under wikipedia.
+		project = "wikipedia"
+	}
+	return &MediaURLBuilder{Path: project + "/" + sub}, nil
+}
+
+func (m MediaURLBuilder) prefix() string {
+	scheme, host := m.Scheme, m.Host
+	if scheme == "" {
+		scheme = "https"
+	}
+	if host == "" {
+		host = "upload.wikimedia.org"
+	}
+	return scheme + "://" + host + "/" + strings.Trim(m.Path, "/") + "/"
+}
+
+// mediaName gets a file's name as stored: without a namespace, first
+// letter capitalized, and with underscores for spaces.
+func mediaName(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		switch strings.ToLower(strings.TrimSpace(name[:i])) {
+		case "file", "image", "media":
+			name = name[i+1:]
+		}
+	}
+	return strings.Replace(canonicalTitle(name), " ", "_", -1)
+}
+
+// hashPath gets the hash directories a file is stored under.
+func hashPath(name string) string {
+	sum := md5.Sum([]byte(name))
+	h := hex.EncodeToString(sum[:])
+	return h[0:1] + "/" + h[0:2] + "/"
+}
+
+// URL gets the URL of the original file.
+func (m MediaURLBuilder) URL(name string) string {
+	name = mediaName(name)
+	return m.prefix() + hashPath(name) + wikiURLEncode(name)
+}
+
+// ThumbURL gets the URL of a thumbnail of the file scaled to the given
+// width.
+//
+// Vector and paged formats are rendered to raster thumbnails, so e.g.
+// Foo.svg has a thumbnail named 220px-Foo.svg.png.
+func (m MediaURLBuilder) ThumbURL(name string, width int) string {
+	name = mediaName(name)
+	thumb := strconv.Itoa(width) + "px-" + name
+	switch strings.ToLower(path.Ext(name)) {
+	case ".svg":
+		thumb += ".png"
+	case ".pdf", ".djvu":
+		thumb = "page1-" + thumb + ".jpg"
+	case ".tif", ".tiff":
+		thumb = "lossy-page1-" + thumb + ".jpg"
+	}
+	return m.prefix() + "thumb/" + hashPath(name) + wikiURLEncode(name) + "/" + wikiURLEncode(thumb)
+}
@@ -0,0 +1,69 @@
+package wikiparse
+
+import (
+	"testing"
+)
+
+func TestNewMediaURLBuilder(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		base, exp string
+	}{
+		{"http://en.wikipedia.org/wiki/Main_Page", "https://upload.wikimedia.org/wikipedia/en/"},
+		{"https://en.m.wikipedia.org/wiki/Main_Page", "https://upload.wikimedia.org/wikipedia/en/"},
+		{"https://commons.m.wikimedia.org/wiki/Main_Page", "https://upload.wikimedia.org/wikipedia/commons/"},
+		{"https://de.wiktionary.org/wiki/Wiktionary:Hauptseite", "https://upload.wikimedia.org/wiktionary/de/"},
+		{"https://commons.wikimedia.org/wiki/Main_Page", "https://upload.wikimedia.org/wikipedia/commons/"},
+		{"https://www.mediawiki.org/wiki/MediaWiki", "https://upload.wikimedia.org/wikipedia/mediawiki/"},
+		{"https://m.mediawiki.org/wiki/MediaWiki", "https://upload.wikimedia.org/wikipedia/mediawiki/"},
+		{"https://www.wikidata.org/wiki/Wikidata:Main_Page", "https://upload.wikimedia.org/wikipedia/commons/"},
+		{"https://wikisource.org/wiki/Main_Page", "https://upload.wikimedia.org/wikipedia/sources/"},
+		{"https://www.wikisource.org/wiki/Main_Page", "https://upload.wikimedia.org/wikipedia/sources/"},
+		{"https://en.wikisource.org/wiki/Main_Page", "https://upload.wikimedia.org/wikisource/en/"},
+		{"https://localhost/wiki/Main_Page", ""},
+		{"%zz", ""},
+	}
+	for _, test := range tests {
+		m, err := NewMediaURLBuilder(SiteInfo{Base: test.base})
+		switch {
+		case test.exp == "" && err == nil:
+			t.Errorf("Expected error for %v, got %+v", test.base, m)
+		case test.exp != "" && err != nil:
+			t.Errorf("Error for %v: %v", test.base, err)
+		case err == nil && m.prefix() != test.exp:
+			t.Errorf("Expected %v for %v, got %v", test.exp, test.base, m.prefix())
+		}
+	}
+}
+
+func TestMediaURLs(t *testing.T) {
+	t.Parallel()
+	en := MediaURLBuilder{Path: "wikipedia/en"}
+	tests := []struct {
+		m           MediaURLBuilder
+		name        string
+		width       int
+		orig, thumb string
+	}{
+		{CommonsMedia, "BoredEncrustedShell.JPG", 220,
+			"https://upload.wikimedia.org/wikipedia/commons/1/10/BoredEncrustedShell.JPG",
+			"https://upload.wikimedia.org/wikipedia/commons/thumb/1/10/BoredEncrustedShell.JPG/220px-BoredEncrustedShell.JPG"},
+		{CommonsMedia, "File:AURI B-25.jpg", 100,
+			"https://upload.wikimedia.org/wikipedia/commons/9/93/AURI_B-25.jpg",
+			"https://upload.wikimedia.org/wikipedia/commons/thumb/9/93/AURI_B-25.jpg/100px-AURI_B-25.jpg"},
+		{en, "biological classification L Pengo.svg", 100,
+			"https://upload.wikimedia.org/wikipedia/en/5/5f/Biological_classification_L_Pengo.svg",
+			"https://upload.wikimedia.org/wikipedia/en/thumb/5/5f/Biological_classification_L_Pengo.svg/100px-Biological_classification_L_Pengo.svg.png"},
+		{MediaURLBuilder{Scheme: "http", Host: "media.example", Path: "/w/"}, "Doc?.pdf", 50,
+			"http://media.example/w/6/6d/Doc%3F.pdf",
+			"http://media.example/w/thumb/6/6d/Doc%3F.pdf/page1-50px-Doc%3F.pdf.jpg"},
+	}
+	for _, test := range tests {
+		if got := test.m.URL(test.name); got != test.orig {
+			t.Errorf("Expected %v for %v, got %v", test.orig, test.name, got)
+		}
+		if got := test.m.ThumbURL(test.name, test.width); got != test.thumb {
+			t.Errorf("Expected %v for %v thumb, got %v", test.thumb, test.name, got)
+		}
+	}
+}
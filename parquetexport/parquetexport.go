@@ -0,0 +1,264 @@
+// Package parquetexport writes dumps as Parquet tables for analytics
+// tools such as DuckDB and Spark.
+//
+// Export writes up to four files to a directory:
+//
+//	pages.parquet       one row per page, with its latest revision
+//	revisions.parquet   one row per revision
+//	links.parquet       page_id, target for each distinct link (optional)
+//	categories.parquet  page_id, category for each category (optional)
+//
+// The column names and types are part of the API and only ever grow.
+package parquetexport
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/dustin/go-wikiparse"
+	"github.com/dustin/go-wikiparse/internal/pipeline"
+	"github.com/parquet-go/parquet-go"
+)
+
+// DefaultRowGroupSize is the number of rows per row group used when
+// Options doesn't give one.
+const DefaultRowGroupSize = 100000
+
+// A PageRow is a row of pages.parquet.
+type PageRow struct {
+	PageID     uint64 `parquet:"page_id"`
+	Title      string `parquet:"title,dict"`
+	Ns         uint64 `parquet:"ns"`
+	Redirect   string `parquet:"redirect,optional"`
+	RevisionID uint64 `parquet:"rev_id"`
+	Revisions  int64  `parquet:"revisions"`
+	Bytes      int64  `parquet:"bytes"`
+}
+
+// A RevisionRow is a row of revisions.parquet.
+type RevisionRow struct {
+	PageID        uint64    `parquet:"page_id"`
+	RevisionID    uint64    `parquet:"rev_id"`
+	Timestamp     time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	Contributor   string    `parquet:"contributor,dict"`
+	ContributorID uint64    `parquet:"contributor_id"`
+	Comment       string    `parquet:"comment"`
+	Bytes         int64     `parquet:"bytes"`
+	Text          *string   `parquet:"text,optional"`
+}
+
+// A LinkRow is a row of links.parquet.
+type LinkRow struct {
+	PageID uint64 `parquet:"page_id"`
+	Target string `parquet:"target,dict"`
+}
+
+// A CategoryRow is a row of categories.parquet.
+type CategoryRow struct {
+	PageID   uint64 `parquet:"page_id"`
+	Category string `parquet:"category,dict"`
+}
+
+// Options controls an export.
+type Options struct {
+	// Dir is the directory the files are written to.  It's created
+	// if needed.
+	Dir string
+	// Fields selects the optional data: wikiparse.ExportText adds
+	// the text column to revisions, and wikiparse.ExportLinks and
+	// wikiparse.ExportCategories write the links and categories
+	// tables.  Other fields are ignored.
+	Fields wikiparse.ExportFields
+	// RowGroupSize is the maximum number of rows in a row group.
+	RowGroupSize int64
+	// Workers is the number of goroutines extracting rows from
+	// pages.  It defaults to GOMAXPROCS.
+	Workers int
+	// Filter, if not nil, selects the pages to export.
+	Filter func(*wikiparse.Page) bool
+}
+
+// Stats counts the rows written by an export.
+type Stats struct {
+	Pages, Revisions, Links, Categories int64
+}
+
+// rows are the rows extracted from one page.
+type rows struct {
+	page       PageRow
+	revisions  []RevisionRow
+	links      []LinkRow
+	categories []CategoryRow
+}
+
+func extract(p *wikiparse.Page, fields wikiparse.ExportFields) *rows {
+	rv := &rows{page: PageRow{
+		PageID:    p.ID,
+		Title:     p.Title,
+		Ns:        p.Ns,
+		Redirect:  p.Redir.Title,
+		Revisions: int64(len(p.Revisions)),
+	}}
+	var latest string
+	for i, r := range p.Revisions {
+		ts, _ := time.Parse(time.RFC3339, r.Timestamp)
+		row := RevisionRow{
+			PageID:        p.ID,
+			RevisionID:    r.ID,
+			Timestamp:     ts,
+			Contributor:   r.Contributor.Name(),
+			ContributorID: r.Contributor.ID,
+			Comment:       r.Comment,
+			Bytes:         int64(len(r.Text)),
+		}
+		if fields&wikiparse.ExportText != 0 {
+			row.Text = &p.Revisions[i].Text
+		}
+		rv.revisions = append(rv.revisions, row)
+		rv.page.RevisionID, rv.page.Bytes = r.ID, row.Bytes
+		latest = r.Text
+	}
+	if fields&wikiparse.ExportLinks != 0 {
+		seen := map[string]bool{}
+		for _, l := range wikiparse.FindLinks(latest) {
+			if !seen[l] {
+				seen[l] = true
+				rv.links = append(rv.links, LinkRow{p.ID, l})
+			}
+		}
+	}
+	if fields&wikiparse.ExportCategories != 0 {
+		for _, c := range wikiparse.FindCategories(latest) {
+			rv.categories = append(rv.categories, CategoryRow{p.ID, c})
+		}
+	}
+	return rv
+}
+
+// table is an open Parquet file.
+type table[T any] struct {
+	f *os.File
+	w *parquet.GenericWriter[T]
+}
+
+func createTable[T any](dir, name string, rowGroupSize int64) (*table[T], error) {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return &table[T]{f, parquet.NewGenericWriter[T](f,
+		parquet.MaxRowsPerRowGroup(rowGroupSize),
+		parquet.Compression(&parquet.Zstd),
+		parquet.CreatedBy("go-wikiparse", "", ""),
+	)}, nil
+}
+
+func (t *table[T]) write(rows []T) error {
+	if t == nil || len(rows) == 0 {
+		return nil
+	}
+	_, err := t.w.Write(rows)
+	return err
+}
+
+func (t *table[T]) close() error {
+	if t == nil {
+		return nil
+	}
+	err := t.w.Close()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// tables are the files being written by an export.
+type tables struct {
+	pages      *table[PageRow]
+	revisions  *table[RevisionRow]
+	links      *table[LinkRow]
+	categories *table[CategoryRow]
+}
+
+func createTables(opts Options) (*tables, error) {
+	if err := os.MkdirAll(opts.Dir, 0777); err != nil {
+		return nil, err
+	}
+	t := &tables{}
+	var err error
+	if t.pages, err = createTable[PageRow](opts.Dir, "pages.parquet", opts.RowGroupSize); err != nil {
+		return nil, err
+	}
+	if t.revisions, err = createTable[RevisionRow](opts.Dir, "revisions.parquet", opts.RowGroupSize); err != nil {
+		t.close()
+		return nil, err
+	}
+	if opts.Fields&wikiparse.ExportLinks != 0 {
+		if t.links, err = createTable[LinkRow](opts.Dir, "links.parquet", opts.RowGroupSize); err != nil {
+			t.close()
+			return nil, err
+		}
+	}
+	if opts.Fields&wikiparse.ExportCategories != 0 {
+		if t.categories, err = createTable[CategoryRow](opts.Dir, "categories.parquet", opts.RowGroupSize); err != nil {
+			t.close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *tables) write(r *rows, st *Stats) error {
+	if err := t.pages.write([]PageRow{r.page}); err != nil {
+		return err
+	}
+	if err := t.revisions.write(r.revisions); err != nil {
+		return err
+	}
+	if err := t.links.write(r.links); err != nil {
+		return err
+	}
+	if err := t.categories.write(r.categories); err != nil {
+		return err
+	}
+	st.Pages++
+	st.Revisions += int64(len(r.revisions))
+	st.Links += int64(len(r.links))
+	st.Categories += int64(len(r.categories))
+	return nil
+}
+
+func (t *tables) close() error {
+	return errors.Join(t.pages.close(), t.revisions.close(),
+		t.links.close(), t.categories.close())
+}
+
+// Export reads every page from p and writes the tables to opts.Dir.
+// Rows are extracted from pages by several workers, but are written
+// in the order the parser returned the pages.
+func Export(p wikiparse.Parser, opts Options) (Stats, error) {
+	var st Stats
+	if opts.RowGroupSize <= 0 {
+		opts.RowGroupSize = DefaultRowGroupSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.GOMAXPROCS(0)
+	}
+	t, err := createTables(opts)
+	if err != nil {
+		return st, err
+	}
+
+	var keep func(*wikiparse.Page) (bool, error)
+	if opts.Filter != nil {
+		keep = func(page *wikiparse.Page) (bool, error) { return opts.Filter(page), nil }
+	}
+	err = pipeline.Ordered(p.Next, opts.Workers, keep,
+		func(page *wikiparse.Page) *rows { return extract(page, opts.Fields) },
+		func(r *rows) error { return t.write(r, &st) })
+	err = errors.Join(err, t.close())
+	return st, err
+}
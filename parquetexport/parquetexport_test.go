@@ -0,0 +1,142 @@
+package parquetexport
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-wikiparse"
+	"github.com/parquet-go/parquet-go"
+)
+
+const historyDump = `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Halifax</title><ns>0</ns><id>10</id>
+<revision><id>100</id><timestamp>2020-01-02T03:04:05Z</timestamp><contributor><username>Alice</username><id>7</id></contributor><comment>new</comment><text>Near [[Dartmouth]].</text></revision>
+<revision><id>101</id><timestamp>2021-01-02T03:04:05Z</timestamp><contributor><username>Bob</username><id>8</id></contributor><text>Near [[Dartmouth]] and [[Peggys Cove]], [[Dartmouth]]. [[Category:Cities]]</text></revision>
+</page>
+<page><title>Peggy's Cove</title><ns>0</ns><id>20</id><redirect title="Peggys Cove" />
+<revision><id>200</id><timestamp>2022-01-02T03:04:05Z</timestamp><text>#REDIRECT [[Peggys Cove]]</text></revision>
+</page>
+<page><title>Talk:Halifax</title><ns>1</ns><id>30</id>
+<revision><id>300</id><timestamp>2023-01-02T03:04:05Z</timestamp><text>Hi</text></revision>
+</page>
+</mediawiki>`
+
+func export(t *testing.T, opts Options) (string, Stats) {
+	p, err := wikiparse.NewParser(strings.NewReader(historyDump))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	opts.Dir = filepath.Join(t.TempDir(), "out")
+	st, err := Export(p, opts)
+	if err != nil {
+		t.Fatalf("Error exporting: %v", err)
+	}
+	return opts.Dir, st
+}
+
+func read[T any](t *testing.T, dir, name string) []T {
+	rows, err := parquet.ReadFile[T](filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("Error reading %v: %v", name, err)
+	}
+	return rows
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+	dir, st := export(t, Options{
+		Fields:       wikiparse.ExportLinks | wikiparse.ExportCategories,
+		RowGroupSize: 1,
+		Workers:      3,
+	})
+
+	exp := Stats{Pages: 3, Revisions: 4, Links: 4, Categories: 1}
+	if st != exp {
+		t.Errorf("Expected %+v, got %+v", exp, st)
+	}
+
+	pages := read[PageRow](t, dir, "pages.parquet")
+	expPages := []PageRow{
+		{PageID: 10, Title: "Halifax", RevisionID: 101, Revisions: 2, Bytes: 74},
+		{PageID: 20, Title: "Peggy's Cove", Redirect: "Peggys Cove", RevisionID: 200, Revisions: 1, Bytes: 25},
+		{PageID: 30, Title: "Talk:Halifax", Ns: 1, RevisionID: 300, Revisions: 1, Bytes: 2},
+	}
+	if !reflect.DeepEqual(pages, expPages) {
+		t.Errorf("Expected %+v, got %+v", expPages, pages)
+	}
+
+	revs := read[RevisionRow](t, dir, "revisions.parquet")
+	if len(revs) != 4 {
+		t.Fatalf("Expected 4 revisions, got %+v", revs)
+	}
+	expRev := RevisionRow{
+		PageID: 10, RevisionID: 100,
+		Timestamp:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Contributor: "Alice", ContributorID: 7, Comment: "new", Bytes: 19,
+	}
+	if !revs[0].Timestamp.Equal(expRev.Timestamp) {
+		t.Errorf("Expected %v, got %v", expRev.Timestamp, revs[0].Timestamp)
+	}
+	revs[0].Timestamp = expRev.Timestamp
+	if !reflect.DeepEqual(revs[0], expRev) {
+		t.Errorf("Expected %+v, got %+v", expRev, revs[0])
+	}
+
+	links := read[LinkRow](t, dir, "links.parquet")
+	expLinks := []LinkRow{{10, "Dartmouth"}, {10, "Peggys Cove"}, {10, "Category:Cities"}, {20, "Peggys Cove"}}
+	if !reflect.DeepEqual(links, expLinks) {
+		t.Errorf("Expected %+v, got %+v", expLinks, links)
+	}
+
+	cats := read[CategoryRow](t, dir, "categories.parquet")
+	expCats := []CategoryRow{{10, "Cities"}}
+	if !reflect.DeepEqual(cats, expCats) {
+		t.Errorf("Expected %+v, got %+v", expCats, cats)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "revisions.parquet"))
+	if err != nil {
+		t.Fatalf("Error opening revisions: %v", err)
+	}
+	defer f.Close()
+	fi, _ := f.Stat()
+	pf, err := parquet.OpenFile(f, fi.Size())
+	if err != nil {
+		t.Fatalf("Error opening revisions: %v", err)
+	}
+	if n := len(pf.RowGroups()); n != 4 {
+		t.Errorf("Expected 4 row groups, got %v", n)
+	}
+}
+
+func TestExportText(t *testing.T) {
+	t.Parallel()
+	dir, _ := export(t, Options{
+		Fields: wikiparse.ExportText,
+		Filter: func(p *wikiparse.Page) bool { return p.Ns == 0 },
+	})
+
+	revs := read[RevisionRow](t, dir, "revisions.parquet")
+	if len(revs) != 3 || revs[2].Text == nil || *revs[2].Text != "#REDIRECT [[Peggys Cove]]" {
+		t.Errorf("Unexpected revisions: %+v", revs)
+	}
+	for _, name := range []string{"links.parquet", "categories.parquet"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected no %v, got %v", name, err)
+		}
+	}
+}
+
+func TestExportMetadataOnly(t *testing.T) {
+	t.Parallel()
+	dir, _ := export(t, Options{})
+	for _, r := range read[RevisionRow](t, dir, "revisions.parquet") {
+		if r.Text != nil {
+			t.Errorf("Expected no text, got %q", *r.Text)
+		}
+	}
+}
@@ -513,3 +513,66 @@ func BenchmarkSmallTokening(b *testing.B) {
 func BenchmarkLargeTokening(b *testing.B) {
 	tokening(b, []byte(larger))
 }
+
+func TestArticleURL(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		base, title, exp string
+	}{
+		{"https://en.wikipedia.org/wiki/Main_Page", "peggy's Cove, Nova Scotia",
+			"https://en.wikipedia.org/wiki/Peggy%27s_Cove,_Nova_Scotia"},
+		{"http://localhost/w/Main", "A&B", "http://localhost/w/A%26B"},
+		{"", "Anything", ""},
+	}
+	for _, test := range tests {
+		if got := (SiteInfo{Base: test.base}).ArticleURL(test.title); got != test.exp {
+			t.Errorf("Expected %v for %v, got %v", test.exp, test.title, got)
+		}
+	}
+}
+
+func TestRevisionSHA1(t *testing.T) {
+	t.Parallel()
+	p, err := NewParser(strings.NewReader(exemplar))
+	if err != nil {
+		t.Fatalf("Error making parser: %v", err)
+	}
+	page, err := p.Next()
+	if err != nil {
+		t.Fatalf("Error reading page: %v", err)
+	}
+	r := page.Revisions[0]
+	const exp = "lo15ponaybcg2sf49sstw9gdjmdetnk"
+	if r.SHA1 != exp {
+		t.Errorf("Expected %v, got %v", exp, r.SHA1)
+	}
+	if got := TextSHA1(r.Text); got != exp {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+	r.SHA1 = ""
+	if got := r.ContentSHA1(); got != exp {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+	if got := TextSHA1(""); got != "phoiac9h4m842xq45sp7s6u21eteeq1" {
+		t.Errorf("Expected the empty text's sha1, got %v", got)
+	}
+}
+
+func TestContributorName(t *testing.T) {
+	t.Parallel()
+	p, err := NewParser(strings.NewReader(`<mediawiki><siteinfo></siteinfo>
+<page><title>A</title><revision><contributor><ip>10.0.0.1</ip></contributor></revision>
+<revision><contributor><username>Ed</username><id>4</id></contributor></revision></page></mediawiki>`))
+	if err != nil {
+		t.Fatalf("Error making parser: %v", err)
+	}
+	page, err := p.Next()
+	if err != nil {
+		t.Fatalf("Error reading page: %v", err)
+	}
+	for i, exp := range []string{"10.0.0.1", "Ed"} {
+		if got := page.Revisions[i].Contributor.Name(); got != exp {
+			t.Errorf("Expected %v, got %v", exp, got)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package wikiparse
 
 import (
+	"crypto/sha1"
 	"encoding/xml"
 	"io"
+	"math/big"
+	"strings"
 )
 
 // SiteInfo is the toplevel site info describing basic dump properties.
@@ -18,10 +21,20 @@ type SiteInfo struct {
 	} `xml:"namespaces>namespace"`
 }
 
-// A Contributor is a user who contributed a revision.
+// A Contributor is a user who contributed a revision.  Anonymous
+// contributors only have an IP address.
 type Contributor struct {
 	ID       uint64 `xml:"id"`
 	Username string `xml:"username"`
+	IP       string `xml:"ip"`
+}
+
+// Name gets the contributor's username, or IP address if anonymous.
+func (c Contributor) Name() string {
+	if c.Username != "" {
+		return c.Username
+	}
+	return c.IP
 }
 
 // A Redirect to another Page.
@@ -32,10 +45,29 @@ type Redirect struct {
 // A Revision to a page.
 type Revision struct {
 	ID          uint64      `xml:"id"`
+	ParentID    uint64      `xml:"parentid"`
 	Timestamp   string      `xml:"timestamp"`
 	Contributor Contributor `xml:"contributor"`
 	Comment     string      `xml:"comment"`
 	Text        string      `xml:"text"`
+	SHA1        string      `xml:"sha1"`
+}
+
+// TextSHA1 gets the SHA-1 of revision text the way MediaWiki writes
+// it in dumps: base 36, padded to 31 digits.
+func TextSHA1(text string) string {
+	sum := sha1.Sum([]byte(text))
+	s := new(big.Int).SetBytes(sum[:]).Text(36)
+	return strings.Repeat("0", 31-len(s)) + s
+}
+
+// ContentSHA1 gets the revision's SHA-1 from the dump, or computes it
+// from the text if the dump doesn't have one.
+func (r Revision) ContentSHA1() string {
+	if r.SHA1 != "" {
+		return r.SHA1
+	}
+	return TextSHA1(r.Text)
 }
 
 // A Page in the wiki.
@@ -89,3 +121,14 @@ func (p *singleStreamParser) Next() (*Page, error) {
 func (p *singleStreamParser) SiteInfo() SiteInfo {
 	return p.siteInfo
 }
+
+// ArticleURL gets the URL of the article with the given title, based
+// on the site's base URL (e.g. https://en.wikipedia.org/wiki/Main_Page).
+// It returns "" if the site has no base URL.
+func (si SiteInfo) ArticleURL(title string) string {
+	i := strings.LastIndexByte(si.Base, '/')
+	if i < 0 {
+		return ""
+	}
+	return si.Base[:i+1] + wikiURLEncode(canonicalTitle(title))
+}
@@ -0,0 +1,205 @@
+package wikiparse
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var emptyParenRE, parenPunctRE, spacePunctRE *regexp.Regexp
+
+// langCodes are the prefixes of interlanguage links: the language
+// codes of the Wikipedias.
+var langCodes = map[string]bool{}
+
+func init() {
+	for _, code := range strings.Fields(wikipediaLanguages) {
+		langCodes[code] = true
+	}
+	emptyParenRE = regexp.MustCompile(`\(\s*[,;]*\s*\)`)
+	parenPunctRE = regexp.MustCompile(`\(\s*[,;]\s*`)
+	spacePunctRE = regexp.MustCompile(`\s+([,.;:])`)
+}
+
+// PlainTextOptions control what's kept when converting wikitext to
+// plain text.
+type PlainTextOptions struct {
+	// KeepLists emits list items, one per line.
+	KeepLists bool
+	// KeepTables emits table captions and rows, one row per line
+	// with cells separated by tabs.
+	KeepTables bool
+}
+
+// DefaultPlainTextOptions are the options used by PlainText.
+var DefaultPlainTextOptions = PlainTextOptions{KeepLists: true}
+
+// PlainText converts wikitext markup to plain text suitable for
+// full-text search.
+//
+// Templates, references, tables, file embeds, comments and formatting
+// are removed.  Link labels, headings and lists are kept, with
+// paragraphs separated by blank lines.
+func PlainText(text string) string {
+	return PlainTextWithOptions(text, DefaultPlainTextOptions)
+}
+
+// PlainTextWithOptions converts wikitext markup to plain text using
+// the given options.
+func PlainTextWithOptions(text string, opts PlainTextOptions) string {
+	return opts.blocks(ParseWikitext(text))
+}
+
+func (o PlainTextOptions) blocks(nodes []*Node) string {
+	var out []string
+	inList := false
+	for _, n := range nodes {
+		var s string
+		switch n.Kind {
+		case ListItemNode:
+			if !o.KeepLists {
+				continue
+			}
+			if s = inlineText(n.Children); !hasWordChar(s) {
+				continue
+			}
+			if inList {
+				out[len(out)-1] += "\n" + s
+				continue
+			}
+			out = append(out, s)
+			inList = true
+			continue
+		case TableNode:
+			if o.KeepTables {
+				s = o.table(n)
+			}
+		case HRNode:
+		default:
+			s = inlineText([]*Node{n})
+		}
+		inList = false
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return strings.Join(out, "\n\n")
+}
+
+func (o PlainTextOptions) table(t *Node) string {
+	var lines []string
+	for _, n := range t.Children {
+		switch n.Kind {
+		case TableCaptionNode:
+			if s := inlineText(n.Children); s != "" {
+				lines = append(lines, s)
+			}
+		case TableRowNode:
+			var cells []string
+			for _, c := range n.Children {
+				cells = append(cells, strings.Join(strings.Fields(o.blocks(c.Children)), " "))
+			}
+			if strings.TrimSpace(strings.Join(cells, "")) != "" {
+				lines = append(lines, strings.Join(cells, "\t"))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func hasWordChar(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}) >= 0
+}
+
+// isVisibleLink reports whether an internal link shows up in the
+// rendered text, as opposed to embedding a file or categorizing the
+// page.
+func isVisibleLink(target string) bool {
+	if strings.HasPrefix(target, ":") {
+		return true
+	}
+	i := strings.IndexByte(target, ':')
+	if i < 0 {
+		return true
+	}
+	switch ns := strings.ToLower(strings.TrimSpace(target[:i])); ns {
+	case "file", "image", "media", "category":
+		return false
+	}
+	// Interlanguage links are written as [[de:Dinge]], so a prefix
+	// that's capitalized or followed by a space, as in
+	// [[War: The Story]], is part of an ordinary title.
+	return !langCodes[target[:i]] || strings.HasPrefix(target[i+1:], " ")
+}
+
+func inlineText(nodes []*Node) string {
+	var b strings.Builder
+	writeInlineText(&b, nodes)
+	s := strings.Join(strings.Fields(b.String()), " ")
+	s = emptyParenRE.ReplaceAllString(s, "")
+	s = parenPunctRE.ReplaceAllString(s, "(")
+	s = spacePunctRE.ReplaceAllString(s, "$1")
+	return strings.TrimSpace(s)
+}
+
+func writeInlineText(b *strings.Builder, nodes []*Node) {
+	for _, n := range nodes {
+		switch n.Kind {
+		case TextNode:
+			b.WriteString(html.UnescapeString(n.Text))
+		case LinkNode:
+			if !isVisibleLink(n.Name) {
+				continue
+			}
+			if len(n.Params) == 0 && strings.HasPrefix(n.Name, ":") {
+				b.WriteString(n.Name[1:])
+				writeInlineText(b, n.Children[1:])
+				continue
+			}
+			writeInlineText(b, n.Children)
+		case TagNode:
+			switch n.Name {
+			case "nowiki", "pre":
+				b.WriteString(html.UnescapeString(n.Text))
+			case "poem":
+				b.WriteString(inlineText(n.Children))
+			}
+		case HTMLNode:
+			if n.Name == "br" {
+				b.WriteByte(' ')
+			}
+		case TemplateNode, ArgumentNode, CommentNode:
+		case ListItemNode, TableNode, HRNode:
+		default:
+			writeInlineText(b, n.Children)
+		}
+	}
+}
+
+const wikipediaLanguages = `
+	aa ab ace ady af ak als alt am ami an ang anp ar arc ary arz as
+	ast atj av avk awa ay az azb ba ban bar bat-smg bbc bcl be
+	be-tarask be-x-old bew bg bh bi bjn blk bm bn bo bpy br bs btm
+	bug bxr ca cbk-zam cdo ce ceb ch cho chr chy ckb co cr crh cs
+	csb cu cv cy da dag de dga din diq dsb dtp dty dv dz ee el eml
+	en eo es et eu ext fa fat ff fi fiu-vro fj fo fon fr frp frr fur
+	fy ga gag gan gcr gd gl glk gn gom gor got gpe gu guc gur guw gv
+	ha hak haw he hi hif ho hr hsb ht hu hy hyw hz ia iba id ie ig
+	igl ii ik ilo inh io is it iu ja jam jbo jv ka kaa kab kbd kbp
+	kcg kg kge ki kj kk kl km kn knc ko koi kr krc ks ksh ku kus kv
+	kw ky la lad lb lbe lez lfn lg li lij lld lmo ln lo lrc lt ltg
+	lv lzh mad mai map-bms mdf mg mh mhr mi min mk ml mn mni mnw mo
+	mos mr mrj ms mt mus mwl my myv mzn na nah nan nap nds nds-nl ne
+	new ng nia nl nn no nov nqo nr nrm nso nup nv ny oc olo om or os
+	pa pag pam pap pcd pcm pdc pfl pi pih pl pms pnb pnt ps pt pwn
+	qu rki rm rmy rn ro roa-rup roa-tara rsk ru rue rup rw sa sah
+	sat sc scn sco sd se sg sgs sh shi shn si simple sk skr sl sm
+	smn sn so sq sr srn ss st stq su sv sw syl szl szy ta tay tcy
+	tdd te tet tg th ti tig tk tl tly tn to tpi tr trv ts tt tum tw
+	ty tyv udm ug uk ur uz ve vec vep vi vls vo vro wa war wo wuu
+	xal xh xmf yi yo yue za zea zgh zh zh-classical zh-min-nan
+	zh-yue zu
+`
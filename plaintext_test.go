@@ -0,0 +1,68 @@
+package wikiparse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, exp string
+	}{
+		{"'''Bold''' and ''italic''", "Bold and italic"},
+		{"[[Foo|bar]]s and [[baz]]", "bars and baz"},
+		{"A{{citation needed}}.<ref>{{cite web|url=x}}</ref> B", "A. B"},
+		{"Porifera ({{IPAc-en|p|ɒ}}; meaning x)", "Porifera (meaning x)"},
+		{"[[File:X.jpg|thumb|Caption]]Text", "Text"},
+		{"[[Category:Things]][[de:Dinge]][[:Category:Things]]", "Category:Things"},
+		{"[[War: The Story]] is good", "War: The Story is good"},
+		{"[[war:Gubat]][[zh-yue:戰爭]][[Abc:Def]] [[abc:def]]", "Abc:Def abc:def"},
+		{"[http://example.com Example] [http://example.com]", "Example"},
+		{"a<!-- hidden -->b &amp; c&nbsp;d", "ab & c d"},
+		{"<nowiki>[[raw]]</nowiki>", "[[raw]]"},
+		{"__NOTOC__Text", "Text"},
+		{"== Heading ==\nPara one\ncontinued.\n\nPara two.", "Heading\n\nPara one continued.\n\nPara two."},
+		{"* one\n* two\n\n{|\n| cell\n|}", "one\ntwo"},
+	}
+
+	for _, test := range tests {
+		if got := PlainText(test.in); got != test.exp {
+			t.Errorf("Expected %q for %q, got %q", test.exp, test.in, got)
+		}
+	}
+}
+
+func TestPlainTextOptions(t *testing.T) {
+	t.Parallel()
+	in := "Intro\n* one\n* [[two]]\n{|\n|+ Cap\n! A !! B\n|-\n| 1 || [[x|2]]\n|}"
+	tests := []struct {
+		opts PlainTextOptions
+		exp  string
+	}{
+		{PlainTextOptions{}, "Intro"},
+		{PlainTextOptions{KeepLists: true}, "Intro\n\none\ntwo"},
+		{PlainTextOptions{KeepTables: true}, "Intro\n\nCap\nA\tB\n1\t2"},
+	}
+	for _, test := range tests {
+		if got := PlainTextWithOptions(in, test.opts); got != test.exp {
+			t.Errorf("Expected %q with %+v, got %q", test.exp, test.opts, got)
+		}
+	}
+}
+
+func TestPlainTextSponge(t *testing.T) {
+	t.Parallel()
+	got := PlainText(sponge)
+	if !strings.HasPrefix(got, "Sponges are animals of the phylum Porifera (meaning") {
+		t.Errorf("Unexpected start of text: %.100q", got)
+	}
+	for _, bad := range []string{"{{", "}}", "[[", "]]", "<ref", "'''", "thumb|"} {
+		if strings.Contains(got, bad) {
+			t.Errorf("Found %q in plain text", bad)
+		}
+	}
+	if !strings.Contains(got, "\n\nOverview\n\n") {
+		t.Errorf("Expected Overview heading as its own paragraph")
+	}
+}
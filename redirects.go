@@ -0,0 +1,169 @@
+package wikiparse
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var redirectRE *regexp.Regexp
+
+func init() {
+	redirectRE = regexp.MustCompile(`(?i)^\s*#redirect\s*:?\s*\[\[([^\]|]+)`)
+}
+
+// A RedirectTarget is where a title ends up after following
+// redirects.
+type RedirectTarget struct {
+	// Title is the canonical title of the final page.
+	Title string
+	// Section is the section the redirect points to, if any.  When
+	// several redirects in a chain name one, the first wins.
+	Section string
+	// Hops is the number of redirects followed.  More than one is a
+	// double redirect, which MediaWiki itself won't follow.
+	Hops int
+	// Loop is true if the redirects lead back to a title already
+	// seen.  Title is then the last title before the loop closed.
+	Loop bool
+}
+
+// A RedirectResolver follows redirects between pages.
+type RedirectResolver struct {
+	// Canonical title -> target title and section.
+	targets map[string][2]string
+}
+
+// NewRedirectResolver gets an empty RedirectResolver.
+func NewRedirectResolver() *RedirectResolver {
+	return &RedirectResolver{targets: map[string][2]string{}}
+}
+
+// BuildRedirects collects all of the redirects from a dump in one
+// pass.
+func BuildRedirects(p Parser) (*RedirectResolver, error) {
+	r := NewRedirectResolver()
+	for {
+		page, err := p.Next()
+		if err == io.EOF {
+			return r, nil
+		}
+		if err != nil {
+			return r, err
+		}
+		r.AddPage(page)
+	}
+}
+
+// splitTarget splits a link target into its canonical title and
+// section.
+func splitTarget(target string) (string, string) {
+	title, section := target, ""
+	if i := strings.IndexByte(target, '#'); i >= 0 {
+		title, section = target[:i], strings.TrimSpace(target[i+1:])
+	}
+	return canonicalTitle(strings.TrimPrefix(strings.TrimSpace(title), ":")), section
+}
+
+// Add records a redirect.  The target may name a section, as in
+// "Page#Section".
+func (r *RedirectResolver) Add(from, to string) {
+	title, section := splitTarget(to)
+	if title == "" {
+		return
+	}
+	r.targets[canonicalTitle(from)] = [2]string{title, section}
+}
+
+// AddPage records the page if it's a redirect, reporting whether it
+// was.  The section comes from the #REDIRECT text of the page's latest
+// revision, as the dump only records the target title.
+func (r *RedirectResolver) AddPage(p *Page) bool {
+	target := p.Redir.Title
+	if len(p.Revisions) > 0 {
+		if m := redirectRE.FindStringSubmatch(p.Revisions[len(p.Revisions)-1].Text); m != nil {
+			if t, _ := splitTarget(m[1]); target == "" || t == canonicalTitle(target) {
+				target = m[1]
+			}
+		}
+	}
+	if target == "" {
+		return false
+	}
+	r.Add(p.Title, target)
+	return true
+}
+
+// Len gets the number of redirects known.
+func (r *RedirectResolver) Len() int {
+	return len(r.targets)
+}
+
+// IsRedirect reports whether the title is a redirect.
+func (r *RedirectResolver) IsRedirect(title string) bool {
+	_, ok := r.targets[canonicalTitle(title)]
+	return ok
+}
+
+// Resolve follows the redirects from a title.  Titles that aren't
+// redirects resolve to themselves with no hops.
+func (r *RedirectResolver) Resolve(title string) RedirectTarget {
+	rv := RedirectTarget{Title: canonicalTitle(title)}
+	seen := map[string]bool{rv.Title: true}
+	for {
+		next, ok := r.targets[rv.Title]
+		if !ok {
+			return rv
+		}
+		if seen[next[0]] {
+			rv.Loop = true
+			return rv
+		}
+		seen[next[0]] = true
+		rv.Title = next[0]
+		rv.Hops++
+		if rv.Section == "" {
+			rv.Section = next[1]
+		}
+	}
+}
+
+// Map resolves every redirect, keyed by the redirect's canonical
+// title.
+func (r *RedirectResolver) Map() map[string]RedirectTarget {
+	rv := make(map[string]RedirectTarget, len(r.targets))
+	for from := range r.targets {
+		rv[from] = r.Resolve(from)
+	}
+	return rv
+}
+
+// DoubleRedirects lists the redirects that lead to another redirect
+// (including loops) in sorted order.
+func (r *RedirectResolver) DoubleRedirects() []string {
+	var rv []string
+	for from, to := range r.targets {
+		if _, ok := r.targets[to[0]]; ok {
+			rv = append(rv, from)
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// CanonicalLink gets the page and section a link (such as one from
+// FindLinks) finally leads to.  A section in the link itself takes
+// precedence over one from a redirect.  Links to a section of the
+// same page have an empty title.
+func (r *RedirectResolver) CanonicalLink(link string) (title, section string) {
+	title, section = splitTarget(link)
+	if title == "" {
+		return "", section
+	}
+	t := r.Resolve(title)
+	if section == "" {
+		section = t.Section
+	}
+	return t.Title, section
+}
@@ -0,0 +1,96 @@
+package wikiparse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const redirectDump = `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Peggys Cove</title><id>1</id><revision><text>A village.</text></revision></page>
+<page><title>Peggy's Cove</title><id>2</id><redirect title="Peggys Cove" /><revision><text>#REDIRECT [[Peggys Cove]]</text></revision></page>
+<page><title>Peggys Point</title><id>3</id><redirect title="Peggys Cove" /><revision><text>#REDIRECT [[peggys_Cove#Lighthouse]] {{R to section}}</text></revision></page>
+<page><title>Peggy's Point</title><id>4</id><redirect title="Peggys Point" /><revision><text>#redirect:[[Peggys Point]]</text></revision></page>
+<page><title>Loop A</title><id>5</id><revision><text>#REDIRECT [[Loop B]]</text></revision></page>
+<page><title>Loop B</title><id>6</id><redirect title="Loop A" /><revision><text>#REDIRECT [[Loop A]]</text></revision></page>
+<page><title>Self</title><id>7</id><redirect title="Self" /><revision><text></text></revision></page>
+<page><title>Former redirect</title><id>8</id><revision><text>#REDIRECT [[Peggys Cove]]</text></revision><revision><text>Now an article.</text></revision></page>
+</mediawiki>`
+
+func buildTestRedirects(t *testing.T) *RedirectResolver {
+	p, err := NewParser(strings.NewReader(redirectDump))
+	if err != nil {
+		t.Fatalf("Error creating parser: %v", err)
+	}
+	r, err := BuildRedirects(p)
+	if err != nil {
+		t.Fatalf("Error building redirects: %v", err)
+	}
+	return r
+}
+
+func TestResolveRedirects(t *testing.T) {
+	t.Parallel()
+	r := buildTestRedirects(t)
+	if r.Len() != 6 {
+		t.Errorf("Expected 6 redirects, got %v", r.Len())
+	}
+	tests := []struct {
+		title string
+		exp   RedirectTarget
+	}{
+		{"Peggys Cove", RedirectTarget{Title: "Peggys Cove"}},
+		{"peggy's_Cove", RedirectTarget{Title: "Peggys Cove", Hops: 1}},
+		{"Peggys Point", RedirectTarget{Title: "Peggys Cove", Section: "Lighthouse", Hops: 1}},
+		{"Peggy's Point", RedirectTarget{Title: "Peggys Cove", Section: "Lighthouse", Hops: 2}},
+		{"Loop A", RedirectTarget{Title: "Loop B", Hops: 1, Loop: true}},
+		{"Self", RedirectTarget{Title: "Self", Loop: true}},
+		{"Unknown", RedirectTarget{Title: "Unknown"}},
+		{"Former redirect", RedirectTarget{Title: "Former redirect"}},
+	}
+	for _, test := range tests {
+		if got := r.Resolve(test.title); got != test.exp {
+			t.Errorf("Expected %+v for %v, got %+v", test.exp, test.title, got)
+		}
+	}
+
+	m := r.Map()
+	if len(m) != 6 || m["Peggy's Point"].Hops != 2 {
+		t.Errorf("Unexpected map: %+v", m)
+	}
+	exp := []string{"Loop A", "Loop B", "Peggy's Point", "Self"}
+	if got := r.DoubleRedirects(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected double redirects %v, got %v", exp, got)
+	}
+	if !r.IsRedirect("peggys Point") || r.IsRedirect("Peggys Cove") {
+		t.Errorf("Wrong IsRedirect")
+	}
+}
+
+func TestCanonicalLink(t *testing.T) {
+	t.Parallel()
+	r := buildTestRedirects(t)
+	tests := []struct {
+		link, title, section string
+	}{
+		{"Peggy's Cove", "Peggys Cove", ""},
+		{"peggy's  Point", "Peggys Cove", "Lighthouse"},
+		{"Peggys Point#History", "Peggys Cove", "History"},
+		{":Peggy's_Cove", "Peggys Cove", ""},
+		{"#Geography", "", "Geography"},
+		{"halifax", "Halifax", ""},
+	}
+	for _, test := range tests {
+		title, section := r.CanonicalLink(test.link)
+		if title != test.title || section != test.section {
+			t.Errorf("Expected %q#%q for %v, got %q#%q",
+				test.title, test.section, test.link, title, section)
+		}
+	}
+
+	for _, l := range FindLinks("See [[Peggy's Point|the light]] and [[peggys Cove]].") {
+		if title, _ := r.CanonicalLink(l); title != "Peggys Cove" {
+			t.Errorf("Expected %v to resolve to Peggys Cove, got %v", l, title)
+		}
+	}
+}
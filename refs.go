@@ -0,0 +1,135 @@
+package wikiparse
+
+import (
+	"strings"
+)
+
+// A Reference is a <ref> footnote in an article.
+type Reference struct {
+	// Name and Group are the ref tag's name and group attributes.
+	Name, Group string
+	// Text is the raw wikitext content of the reference.
+	Text string
+	// Uses is the number of times the reference is cited.  Named
+	// references may be reused with <ref name="..." />.
+	Uses int
+	// Citation is the citation template within the reference, if
+	// any.
+	Citation *Citation
+	// Pos and End are the byte range of the defining ref tag, or of
+	// the first use if it's never defined.
+	Pos, End int
+}
+
+// A Citation is a parsed citation template such as {{cite web}}.
+type Citation struct {
+	// Type is the kind of citation, e.g. "web", "book" or
+	// "journal".  {{citation}} has the type "citation".
+	Type string
+	// Fields contains every parameter of the template.
+	Fields map[string]string
+
+	URL, Title, DOI, ISBN, Date, AccessDate string
+}
+
+// Alternate parameter names for the standard citation fields.
+var citationAliases = map[string][]string{
+	"url":         {"url", "URL"},
+	"title":       {"title", "chapter"},
+	"doi":         {"doi", "DOI"},
+	"isbn":        {"isbn", "ISBN", "isbn13", "ISBN13"},
+	"date":        {"date", "year"},
+	"access-date": {"access-date", "accessdate", "access_date"},
+}
+
+// ParseCitation parses a citation template node.  It returns false if
+// the node isn't a citation template.
+func ParseCitation(n *Node) (*Citation, bool) {
+	if n.Kind != TemplateNode {
+		return nil, false
+	}
+	name := strings.ToLower(n.TemplateName())
+	c := &Citation{Fields: map[string]string{}}
+	switch {
+	case name == "citation" || name == "cite":
+		c.Type = name
+	case strings.HasPrefix(name, "cite "):
+		c.Type = strings.TrimSpace(name[5:])
+	default:
+		return nil, false
+	}
+	for _, p := range n.Params {
+		if v := n.ParamText(p.Name); v != "" {
+			c.Fields[p.Name] = v
+		}
+	}
+
+	field := func(name string) string {
+		for _, alias := range citationAliases[name] {
+			if v, ok := c.Fields[alias]; ok {
+				return v
+			}
+		}
+		return ""
+	}
+	c.URL = field("url")
+	c.DOI = field("doi")
+	c.ISBN = field("isbn")
+	c.Date = field("date")
+	c.AccessDate = field("access-date")
+	for _, alias := range citationAliases["title"] {
+		if p, ok := n.Param(alias); ok && strings.TrimSpace(p.Raw) != "" {
+			c.Title = inlineText(p.Value)
+			break
+		}
+	}
+	return c, true
+}
+
+// FindReferences finds all the references in an article body in the
+// order they're first cited.
+func FindReferences(text string) []*Reference {
+	var rv []*Reference
+	named := map[[2]string]*Reference{}
+
+	var visit func(n *Node, cited bool) bool
+	visit = func(n *Node, cited bool) bool {
+		if n.Kind == CommentNode {
+			return false
+		}
+		if n.Kind == TagNode && n.Name == "references" {
+			// List-defined references aren't citations themselves.
+			Walk(n.Children, func(c *Node) bool { return visit(c, false) })
+			return false
+		}
+		if n.Kind != TagNode || n.Name != "ref" {
+			return true
+		}
+
+		key := [2]string{n.Attrs["group"], n.Attrs["name"]}
+		r := named[key]
+		if r == nil || key[1] == "" {
+			r = &Reference{Name: key[1], Group: key[0], Pos: n.Pos, End: n.End}
+			rv = append(rv, r)
+			if key[1] != "" {
+				named[key] = r
+			}
+		}
+		if cited {
+			r.Uses++
+		}
+		if strings.TrimSpace(n.Text) != "" && strings.TrimSpace(r.Text) == "" {
+			r.Text, r.Pos, r.End = n.Text, n.Pos, n.End
+			Walk(n.Children, func(c *Node) bool {
+				if cite, ok := ParseCitation(c); ok && r.Citation == nil {
+					r.Citation = cite
+				}
+				return r.Citation == nil
+			})
+		}
+		return false
+	}
+	Walk(ParseWikitext(text), func(n *Node) bool { return visit(n, true) })
+
+	return rv
+}
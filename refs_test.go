@@ -0,0 +1,113 @@
+package wikiparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindReferences(t *testing.T) {
+	t.Parallel()
+	text := `A.<ref name="a">{{cite web |url=http://example.com/a |title=The [[A]] |access-date=2020-01-02 |website=Ex}}</ref>
+B.<ref>Plain text ref.</ref> C.<ref name="a"/> D.<ref name=b /><!-- <ref>hidden</ref> -->
+E.<ref group="note">A note.</ref>
+<references>
+<ref name="b">{{Cite book|title=B|isbn=978-0-00-000000-0|year=1999}}</ref>
+</references>`
+
+	refs := FindReferences(text)
+	if len(refs) != 4 {
+		t.Fatalf("Expected 4 references, got %v", len(refs))
+	}
+
+	a := refs[0]
+	if a.Name != "a" || a.Uses != 2 || a.Citation == nil {
+		t.Fatalf("Wrong first reference: %+v", a)
+	}
+	exp := Citation{
+		Type: "web",
+		Fields: map[string]string{
+			"url":         "http://example.com/a",
+			"title":       "The [[A]]",
+			"access-date": "2020-01-02",
+			"website":     "Ex",
+		},
+		URL:        "http://example.com/a",
+		Title:      "The A",
+		AccessDate: "2020-01-02",
+	}
+	if !reflect.DeepEqual(exp, *a.Citation) {
+		t.Errorf("Expected %+v, got %+v", exp, *a.Citation)
+	}
+	if text[a.Pos:a.End] != `<ref name="a">`+a.Text+"</ref>" {
+		t.Errorf("Wrong range for first reference: %q", text[a.Pos:a.End])
+	}
+
+	if refs[1].Text != "Plain text ref." || refs[1].Citation != nil || refs[1].Uses != 1 {
+		t.Errorf("Wrong plain reference: %+v", refs[1])
+	}
+
+	b := refs[2]
+	if b.Name != "b" || b.Uses != 1 || b.Citation == nil {
+		t.Fatalf("Wrong list-defined reference: %+v", b)
+	}
+	if b.Citation.Type != "book" || b.Citation.ISBN != "978-0-00-000000-0" || b.Citation.Date != "1999" {
+		t.Errorf("Wrong book citation: %+v", b.Citation)
+	}
+
+	if refs[3].Group != "note" || refs[3].Text != "A note." {
+		t.Errorf("Wrong grouped reference: %+v", refs[3])
+	}
+}
+
+func TestParseCitation(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, typ string
+	}{
+		{"{{cite journal|doi=10.1/x}}", "journal"},
+		{"{{Cite_news|url=x}}", "news"},
+		{"{{citation|title=x}}", "citation"},
+		{"{{Template:Cite web|url=x}}", "web"},
+		{"{{Citation needed}}", ""},
+		{"{{convert|1|m}}", ""},
+	}
+	for _, test := range tests {
+		n := ParseWikitext(test.in)[0].Children[0]
+		c, ok := ParseCitation(n)
+		switch {
+		case test.typ == "" && ok:
+			t.Errorf("Expected no citation from %q, got %+v", test.in, c)
+		case test.typ != "" && !ok:
+			t.Errorf("Expected citation from %q", test.in)
+		case ok && c.Type != test.typ:
+			t.Errorf("Expected type %q from %q, got %q", test.typ, test.in, c.Type)
+		}
+	}
+}
+
+func TestFindReferencesSponge(t *testing.T) {
+	t.Parallel()
+	refs := FindReferences(sponge)
+	cited, withDOI := 0, 0
+	for _, r := range refs {
+		if r.Citation != nil {
+			cited++
+			if r.Citation.DOI != "" {
+				withDOI++
+			}
+		}
+	}
+	if len(refs) < 40 || cited < 30 || withDOI == 0 {
+		t.Errorf("Expected lots of citations, got %v refs, %v cited, %v with DOIs",
+			len(refs), cited, withDOI)
+	}
+	ruppert := 0
+	for _, r := range refs {
+		if r.Name == "RuppertBarnes2004Porifera" {
+			ruppert = r.Uses
+		}
+	}
+	if ruppert < 30 {
+		t.Errorf("Expected RuppertBarnes2004Porifera to be reused, got %v uses", ruppert)
+	}
+}
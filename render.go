@@ -0,0 +1,465 @@
+package wikiparse
+
+import (
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A LinkResolver maps internal link targets to URLs.
+type LinkResolver interface {
+	// LinkURL gets the URL for the given link target (e.g.
+	// "Foo#Bar" or "File:Baz.jpg").
+	LinkURL(target string) string
+}
+
+// LinkResolverFunc is an adapter allowing a function to be used as a
+// LinkResolver.
+type LinkResolverFunc func(target string) string
+
+// LinkURL calls f(target).
+func (f LinkResolverFunc) LinkURL(target string) string {
+	return f(target)
+}
+
+// BaseURLResolver links titles to pages under the given base, e.g.
+// "https://en.wikipedia.org/wiki/".
+func BaseURLResolver(base string) LinkResolver {
+	return LinkResolverFunc(func(target string) string {
+		title, frag := target, ""
+		if i := strings.IndexByte(target, '#'); i >= 0 {
+			title, frag = target[:i], "#"+wikiURLEncode(sectionAnchor(target[i+1:]))
+		}
+		if strings.TrimSpace(title) == "" {
+			return frag
+		}
+		return base + wikiURLEncode(canonicalTitle(title)) + frag
+	})
+}
+
+// A TemplateHandler renders templates as HTML.
+type TemplateHandler interface {
+	// RenderTemplate gets the HTML for a template node.  If ok is
+	// false, the template is left out of the output.
+	RenderTemplate(t *Node) (h string, ok bool)
+}
+
+// TemplateHandlerFunc is an adapter allowing a function to be used as
+// a TemplateHandler.
+type TemplateHandlerFunc func(t *Node) (string, bool)
+
+// RenderTemplate calls f(t).
+func (f TemplateHandlerFunc) RenderTemplate(t *Node) (string, bool) {
+	return f(t)
+}
+
+// An HTMLRenderer renders the common subset of wikitext as HTML.
+//
+// Templates are dropped unless the TemplateHandler renders them, with
+// the exception of {{reflist}}, which lists references.  Files are
+// rendered as placeholder links.
+type HTMLRenderer struct {
+	// Links resolves internal links.  Defaults to /wiki/ paths.
+	Links LinkResolver
+	// Templates renders templates.  May be nil.
+	Templates TemplateHandler
+}
+
+var defaultLinkResolver = BaseURLResolver("/wiki/")
+
+// RenderHTML renders wikitext as HTML using the default HTMLRenderer.
+func RenderHTML(text string) string {
+	return (&HTMLRenderer{}).Render(text)
+}
+
+// Render renders wikitext as HTML.
+func (r *HTMLRenderer) Render(text string) string {
+	w := &htmlWriter{r: r, b: &strings.Builder{}, anchors: anchorSet{}, refs: map[string]*refGroup{}}
+	if w.links = r.Links; w.links == nil {
+		w.links = defaultLinkResolver
+	}
+	w.blocks(ParseWikitext(text))
+	groups := make([]string, 0, len(w.refs))
+	for g := range w.refs {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		w.references(g)
+	}
+	return w.b.String()
+}
+
+// wikiURLEncode escapes a title for use in a URL the way MediaWiki
+// does, leaving characters such as ':', '/' and parens alone.
+func wikiURLEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ':
+			b.WriteByte('_')
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexByte("-_.~;@$!*(),/:", c) >= 0:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
+
+type refGroup struct {
+	notes []string
+	names map[string]int
+}
+
+type htmlWriter struct {
+	r        *HTMLRenderer
+	links    LinkResolver
+	b        *strings.Builder
+	anchors  anchorSet
+	refs     map[string]*refGroup
+	extLinks int
+}
+
+var listTags = map[byte][2]string{
+	'*': {"ul", "li"},
+	'#': {"ol", "li"},
+	';': {"dl", "dt"},
+	':': {"dl", "dd"},
+}
+
+// Attributes allowed through to the output.
+var safeAttrs = map[string]bool{
+	"class": true, "id": true, "title": true, "lang": true, "dir": true,
+	"colspan": true, "rowspan": true, "scope": true, "align": true,
+	"valign": true, "width": true,
+}
+
+// HTML tags allowed through to the output.
+var safeTags = map[string]bool{
+	"abbr": true, "b": true, "big": true, "blockquote": true, "br": true,
+	"center": true, "cite": true, "code": true, "del": true, "div": true,
+	"em": true, "i": true, "ins": true, "kbd": true, "q": true, "s": true,
+	"small": true, "span": true, "strike": true, "strong": true,
+	"sub": true, "sup": true, "tt": true, "u": true, "var": true,
+}
+
+func (w *htmlWriter) attrs(a map[string]string) {
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		if safeAttrs[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w.b.WriteString(" " + k + `="` + html.EscapeString(a[k]) + `"`)
+	}
+}
+
+func (w *htmlWriter) blocks(nodes []*Node) {
+	var lists []byte
+	for _, n := range nodes {
+		if n.Kind == ListItemNode {
+			lists = w.listItem(lists, n)
+			continue
+		}
+		lists = w.closeLists(lists, 0)
+		switch n.Kind {
+		case ParagraphNode:
+			h := strings.TrimSpace(w.inlineString(n.Children))
+			switch {
+			case h == "":
+			case isBlockHTML(h):
+				w.b.WriteString(h + "\n")
+			default:
+				w.b.WriteString("<p>" + h + "</p>\n")
+			}
+		case HeadingNode:
+			l := strconv.Itoa(n.Level)
+			w.b.WriteString("<h" + l + ` id="` + html.EscapeString(w.anchors.add(inlineText(n.Children))) + `">`)
+			w.b.WriteString(strings.TrimSpace(w.inlineString(n.Children)))
+			w.b.WriteString("</h" + l + ">\n")
+		case HRNode:
+			w.b.WriteString("<hr>\n")
+		case TableNode:
+			w.table(n)
+		default:
+			w.inline([]*Node{n})
+		}
+	}
+	w.closeLists(lists, 0)
+}
+
+// isBlockHTML reports whether rendered paragraph content is itself a
+// block (such as a reference list) that shouldn't be wrapped in <p>.
+func isBlockHTML(h string) bool {
+	for _, t := range []string{"<ol", "<ul", "<div", "<table", "<pre", "<blockquote"} {
+		if strings.HasPrefix(h, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameList(a, b byte) bool {
+	return listTags[a][0] == listTags[b][0]
+}
+
+func (w *htmlWriter) closeLists(open []byte, keep int) []byte {
+	for len(open) > keep {
+		t := listTags[open[len(open)-1]]
+		w.b.WriteString("</" + t[1] + "></" + t[0] + ">\n")
+		open = open[:len(open)-1]
+	}
+	return open
+}
+
+func (w *htmlWriter) listItem(open []byte, n *Node) []byte {
+	prefix := n.Prefix
+	common := 0
+	for common < len(open) && common < len(prefix) && sameList(open[common], prefix[common]) {
+		common++
+	}
+	if common == len(prefix) && common > 0 {
+		// A sibling item: close the current one and start another.
+		open = w.closeLists(open, common)
+		t := listTags[open[common-1]]
+		w.b.WriteString("</" + t[1] + ">\n")
+		open[common-1] = prefix[common-1]
+		w.b.WriteString("<" + listTags[prefix[common-1]][1] + ">")
+	} else {
+		open = w.closeLists(open, common)
+		for i := common; i < len(prefix); i++ {
+			t := listTags[prefix[i]]
+			w.b.WriteString("<" + t[0] + "><" + t[1] + ">")
+			open = append(open, prefix[i])
+		}
+	}
+	w.b.WriteString(strings.TrimSpace(w.inlineString(n.Children)))
+	return open
+}
+
+func (w *htmlWriter) table(t *Node) {
+	w.b.WriteString("<table")
+	w.attrs(t.Attrs)
+	w.b.WriteString(">\n")
+	for _, n := range t.Children {
+		switch n.Kind {
+		case TableCaptionNode:
+			w.b.WriteString("<caption>" + strings.TrimSpace(w.inlineString(n.Children)) + "</caption>\n")
+		case TableRowNode:
+			if len(n.Children) == 0 {
+				continue
+			}
+			w.b.WriteString("<tr")
+			w.attrs(n.Attrs)
+			w.b.WriteString(">")
+			for _, c := range n.Children {
+				tag := "td"
+				if c.Kind == TableHeaderNode {
+					tag = "th"
+				}
+				w.b.WriteString("<" + tag)
+				w.attrs(c.Attrs)
+				w.b.WriteString(">")
+				if len(c.Children) == 1 && c.Children[0].Kind == ParagraphNode {
+					w.b.WriteString(strings.TrimSpace(w.inlineString(c.Children[0].Children)))
+				} else {
+					w.blocks(c.Children)
+				}
+				w.b.WriteString("</" + tag + ">")
+			}
+			w.b.WriteString("</tr>\n")
+		}
+	}
+	w.b.WriteString("</table>\n")
+}
+
+func (w *htmlWriter) inlineString(nodes []*Node) string {
+	outer := w.b
+	w.b = &strings.Builder{}
+	w.inline(nodes)
+	rv := w.b.String()
+	w.b = outer
+	return rv
+}
+
+func (w *htmlWriter) inline(nodes []*Node) {
+	for _, n := range nodes {
+		switch n.Kind {
+		case TextNode:
+			w.b.WriteString(html.EscapeString(html.UnescapeString(n.Text)))
+		case BoldNode:
+			w.b.WriteString("<b>" + w.inlineString(n.Children) + "</b>")
+		case ItalicNode:
+			w.b.WriteString("<i>" + w.inlineString(n.Children) + "</i>")
+		case LinkNode:
+			w.link(n)
+		case ExtLinkNode:
+			w.b.WriteString(`<a class="external" rel="nofollow" href="` + html.EscapeString(n.Name) + `">`)
+			if len(n.Children) > 0 {
+				w.inline(n.Children)
+			} else {
+				w.extLinks++
+				w.b.WriteString("[" + strconv.Itoa(w.extLinks) + "]")
+			}
+			w.b.WriteString("</a>")
+		case TemplateNode:
+			w.template(n)
+		case TagNode:
+			w.tag(n)
+		case HTMLNode:
+			if !safeTags[n.Name] {
+				w.b.WriteString(html.EscapeString(n.Text))
+				continue
+			}
+			if n.Closing {
+				w.b.WriteString("</" + n.Name + ">")
+				continue
+			}
+			w.b.WriteString("<" + n.Name)
+			w.attrs(n.Attrs)
+			w.b.WriteString(">")
+		case ParagraphNode:
+			w.inline(n.Children)
+			w.b.WriteString(" ")
+		case ListItemNode, TableNode, HeadingNode, HRNode:
+			w.blocks([]*Node{n})
+		}
+	}
+}
+
+func (w *htmlWriter) link(n *Node) {
+	target := n.Name
+	if i := strings.IndexByte(target, ':'); i > 0 {
+		switch strings.ToLower(strings.TrimSpace(target[:i])) {
+		case "file", "image":
+			w.file(target)
+			return
+		}
+	}
+	if !isVisibleLink(target) {
+		return
+	}
+	label := w.inlineString(n.Children)
+	if len(n.Params) == 0 && strings.HasPrefix(target, ":") {
+		label = strings.TrimPrefix(label, ":")
+	}
+	target = strings.TrimPrefix(target, ":")
+	w.b.WriteString(`<a href="` + html.EscapeString(w.links.LinkURL(target)) +
+		`" title="` + html.EscapeString(canonicalTitle(target)) + `">` + label + "</a>")
+}
+
+// file writes a placeholder link for an embedded file.
+func (w *htmlWriter) file(target string) {
+	w.b.WriteString(`<span class="file"><a href="` + html.EscapeString(w.links.LinkURL(target)) +
+		`">` + html.EscapeString(target) + "</a></span>")
+}
+
+func (w *htmlWriter) template(n *Node) {
+	if w.r.Templates != nil {
+		if h, ok := w.r.Templates.RenderTemplate(n); ok {
+			w.b.WriteString(h)
+			return
+		}
+	}
+	switch n.TemplateName() {
+	case "Reflist", "References":
+		w.references(n.ParamText("group"))
+	}
+}
+
+func (w *htmlWriter) refGroup(name string) *refGroup {
+	g := w.refs[name]
+	if g == nil {
+		g = &refGroup{names: map[string]int{}}
+		w.refs[name] = g
+	}
+	return g
+}
+
+func refID(prefix, group string, num int) string {
+	if group != "" {
+		prefix += sectionAnchor(group) + "-"
+	}
+	return prefix + strconv.Itoa(num)
+}
+
+func (w *htmlWriter) tag(n *Node) {
+	switch n.Name {
+	case "ref":
+		group := n.Attrs["group"]
+		g := w.refGroup(group)
+		name := n.Attrs["name"]
+		num, ok := g.names[name]
+		if !ok || name == "" {
+			g.notes = append(g.notes, "")
+			num = len(g.notes)
+			if name != "" {
+				g.names[name] = num
+			}
+		}
+		if strings.TrimSpace(n.Text) != "" && g.notes[num-1] == "" {
+			g.notes[num-1] = strings.TrimSpace(w.inlineString(n.Children))
+		}
+		label := strconv.Itoa(num)
+		if group != "" {
+			label = group + " " + label
+		}
+		w.b.WriteString(`<sup class="reference"><a href="#` + html.EscapeString(refID("cite_note-", group, num)) +
+			`">[` + html.EscapeString(label) + "]</a></sup>")
+	case "references":
+		// List-defined references fill in named refs.
+		group := n.Attrs["group"]
+		g := w.refGroup(group)
+		Walk(n.Children, func(c *Node) bool {
+			if c.Kind == TagNode && c.Name == "ref" {
+				if num, ok := g.names[c.Attrs["name"]]; ok && g.notes[num-1] == "" {
+					g.notes[num-1] = strings.TrimSpace(w.inlineString(c.Children))
+				}
+				return false
+			}
+			return true
+		})
+		w.references(group)
+	case "nowiki":
+		w.b.WriteString(html.EscapeString(html.UnescapeString(n.Text)))
+	case "pre":
+		w.b.WriteString("<pre>" + html.EscapeString(html.UnescapeString(n.Text)) + "</pre>")
+	case "math":
+		w.b.WriteString(`<span class="math">` + html.EscapeString(n.Text) + "</span>")
+	case "poem":
+		w.b.WriteString(`<div class="poem">` + strings.Replace(strings.TrimSpace(w.inlineString(n.Children)), "\n", "<br>\n", -1) + "</div>")
+	case "gallery":
+		w.b.WriteString(`<span class="gallery">`)
+		for _, line := range strings.Split(n.Text, "\n") {
+			if name := strings.TrimSpace(strings.SplitN(line, "|", 2)[0]); name != "" {
+				if !strings.Contains(name, ":") {
+					name = "File:" + name
+				}
+				w.file(name)
+			}
+		}
+		w.b.WriteString("</span>")
+	}
+}
+
+func (w *htmlWriter) references(group string) {
+	g := w.refs[group]
+	if g == nil || len(g.notes) == 0 {
+		return
+	}
+	w.b.WriteString(`<ol class="references">` + "\n")
+	for i, note := range g.notes {
+		w.b.WriteString(`<li id="` + html.EscapeString(refID("cite_note-", group, i+1)) + `">` + note + "</li>\n")
+	}
+	w.b.WriteString("</ol>\n")
+	delete(w.refs, group)
+}
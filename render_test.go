@@ -0,0 +1,78 @@
+package wikiparse
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func TestRenderGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/render/*.wiki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("No render fixtures found")
+	}
+	for _, fn := range files {
+		src, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := RenderHTML(string(src))
+		golden := strings.TrimSuffix(fn, ".wiki") + ".html"
+		if *updateGolden {
+			if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		exp, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(exp) {
+			t.Errorf("Rendering %v, expected:\n%s\ngot:\n%s", fn, exp, got)
+		}
+	}
+}
+
+func TestRenderResolvers(t *testing.T) {
+	t.Parallel()
+	r := HTMLRenderer{
+		Links: BaseURLResolver("https://en.wikipedia.org/wiki/"),
+		Templates: TemplateHandlerFunc(func(n *Node) (string, bool) {
+			if n.TemplateName() == "Convert" {
+				return n.ParamText("1") + " " + n.ParamText("2"), true
+			}
+			return "", false
+		}),
+	}
+	got := r.Render("Deep as {{convert|8800|m|mi}}{{other}} [[sea floor#Depth (max)|ocean]].")
+	exp := `<p>Deep as 8800 m <a href="https://en.wikipedia.org/wiki/Sea_floor#Depth_(max)" title="Sea floor#Depth (max)">ocean</a>.</p>` + "\n"
+	if got != exp {
+		t.Errorf("Expected\n%v, got\n%v", exp, got)
+	}
+}
+
+func TestWikiURLEncode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, exp string
+	}{
+		{"AURI B-25.jpg", "AURI_B-25.jpg"},
+		{"AC/DC (band)", "AC/DC_(band)"},
+		{"Hello?&=#", "Hello%3F%26%3D%23"},
+		{"Süß", "S%C3%BC%C3%9F"},
+		{"Foo:Bar;baz", "Foo:Bar;baz"},
+	}
+	for _, test := range tests {
+		if got := wikiURLEncode(test.in); got != test.exp {
+			t.Errorf("Expected %q for %q, got %q", test.exp, test.in, got)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package wikiparse
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// undoRE matches the comment MediaWiki gives an undo.
+var undoRE *regexp.Regexp
+
+func init() {
+	undoRE = regexp.MustCompile(`(?i)\bundid revision (\d+)`)
+}
+
+// DefaultRevertRadius is how many revisions back a revert is looked
+// for when a RevertDetector isn't given a radius.
+const DefaultRevertRadius = 15
+
+// A RevertKind labels a revision by its part in reverts.
+type RevertKind int
+
+const (
+	// RevisionNormal is neither a revert nor reverted.
+	RevisionNormal RevertKind = iota
+	// RevisionRevert undoes earlier revisions.
+	RevisionRevert
+	// RevisionReverted was undone by a later revision.
+	RevisionReverted
+)
+
+func (k RevertKind) String() string {
+	switch k {
+	case RevisionNormal:
+		return "normal"
+	case RevisionRevert:
+		return "revert"
+	case RevisionReverted:
+		return "reverted"
+	}
+	return "unknown"
+}
+
+// A RevertLabel is a revision's part in reverts.
+type RevertLabel struct {
+	RevisionID uint64
+	Kind       RevertKind
+	// Target is the revision a revert went back to, or the revert
+	// that undid a reverted revision.  It's 0 for an undo whose
+	// undone revision's parent isn't known.
+	Target uint64
+	// Undo is true if the revert was found from an "Undid revision"
+	// comment rather than by its text matching an earlier revision's.
+	Undo bool
+}
+
+// A RevertDetector labels a page's revisions as reverts, reverted or
+// normal as they're added in order.
+//
+// A revision whose text (by SHA-1) matches one of the last Radius
+// revisions, other than the one right before it, is an identity
+// revert: it goes back to that revision, and the revisions between
+// are reverted.  Revisions with neither text nor a SHA-1, such as
+// those whose text was suppressed, aren't matched.  A revision whose
+// comment says "Undid revision N" reverts revision N.  A revert may
+// itself be reverted later, and is then labelled reverted.
+type RevertDetector struct {
+	// Radius is the number of revisions back to look for a revert.
+	Radius int
+
+	window []revertEntry
+}
+
+type revertEntry struct {
+	sha1   string
+	parent uint64
+	label  RevertLabel
+}
+
+// NewRevertDetector gets a RevertDetector looking the given number of
+// revisions back, or DefaultRevertRadius if it's not positive.
+func NewRevertDetector(radius int) *RevertDetector {
+	if radius <= 0 {
+		radius = DefaultRevertRadius
+	}
+	return &RevertDetector{Radius: radius}
+}
+
+// reverted labels a revision as reverted by another unless an earlier
+// revert already undid it.
+func (e *revertEntry) reverted(by uint64) {
+	if e.label.Kind != RevisionReverted {
+		e.label = RevertLabel{RevisionID: e.label.RevisionID, Kind: RevisionReverted, Target: by}
+	}
+}
+
+// Add labels the next revision.  It returns the labels of the
+// revisions that have fallen outside the radius, and so won't change
+// any more, oldest first.
+func (d *RevertDetector) Add(r *Revision) []RevertLabel {
+	cur := revertEntry{
+		parent: r.ParentID,
+		label:  RevertLabel{RevisionID: r.ID},
+	}
+	// Suppressed revisions would all match the SHA-1 of no text.
+	if r.SHA1 != "" || r.Text != "" {
+		cur.sha1 = r.ContentSHA1()
+	}
+	if n := len(d.window); n > 0 {
+		prev := &d.window[n-1]
+		if cur.parent == 0 {
+			cur.parent = prev.label.RevisionID
+		}
+		// A revision the same as the last is a null edit, not a
+		// revert.
+		if cur.sha1 != "" && prev.sha1 != cur.sha1 {
+			d.identityRevert(&cur)
+		}
+	}
+	if cur.label.Kind == RevisionNormal {
+		d.undo(&cur, r.Comment)
+	}
+	d.window = append(d.window, cur)
+
+	var rv []RevertLabel
+	for len(d.window) > d.Radius {
+		rv = append(rv, d.window[0].label)
+		d.window = d.window[1:]
+	}
+	return rv
+}
+
+func (d *RevertDetector) identityRevert(cur *revertEntry) {
+	for i := len(d.window) - 2; i >= 0; i-- {
+		if d.window[i].sha1 != cur.sha1 {
+			continue
+		}
+		cur.label.Kind = RevisionRevert
+		cur.label.Target = d.window[i].label.RevisionID
+		for j := i + 1; j < len(d.window); j++ {
+			d.window[j].reverted(cur.label.RevisionID)
+		}
+		return
+	}
+}
+
+func (d *RevertDetector) undo(cur *revertEntry, comment string) {
+	m := undoRE.FindStringSubmatch(comment)
+	if m == nil {
+		return
+	}
+	undone, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return
+	}
+	cur.label.Kind = RevisionRevert
+	cur.label.Undo = true
+	for i := range d.window {
+		if e := &d.window[i]; e.label.RevisionID == undone {
+			cur.label.Target = e.parent
+			e.reverted(cur.label.RevisionID)
+		}
+	}
+}
+
+// Flush gets the labels of the revisions still within the radius,
+// for the end of a page, and resets the detector for the next.
+func (d *RevertDetector) Flush() []RevertLabel {
+	var rv []RevertLabel
+	for _, e := range d.window {
+		rv = append(rv, e.label)
+	}
+	d.window = nil
+	return rv
+}
+
+// PageReverts labels all of a page's revisions, in order, looking the
+// given number of revisions back for reverts.
+func PageReverts(p *Page, radius int) []RevertLabel {
+	d := NewRevertDetector(radius)
+	var rv []RevertLabel
+	for i := range p.Revisions {
+		rv = append(rv, d.Add(&p.Revisions[i])...)
+	}
+	return append(rv, d.Flush()...)
+}
@@ -0,0 +1,100 @@
+package wikiparse
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func revertPage(revs ...string) *Page {
+	p := &Page{}
+	for i, r := range revs {
+		text, comment, _ := strings.Cut(r, "|")
+		p.Revisions = append(p.Revisions, Revision{ID: uint64(i + 1), Text: text, Comment: comment})
+	}
+	return p
+}
+
+func labelString(labels []RevertLabel) string {
+	var rv []string
+	for _, l := range labels {
+		s := fmt.Sprintf("%d:%v", l.RevisionID, l.Kind)
+		if l.Kind != RevisionNormal {
+			s += fmt.Sprintf(">%d", l.Target)
+		}
+		if l.Undo {
+			s += "(undo)"
+		}
+		rv = append(rv, s)
+	}
+	return strings.Join(rv, " ")
+}
+
+func TestPageReverts(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		page   *Page
+		radius int
+		exp    string
+	}{
+		{"vandalism", revertPage("a", "b", "a"), 0,
+			"1:normal 2:reverted>3 3:revert>1"},
+		{"several reverted", revertPage("a", "b", "c", "d", "b"), 0,
+			"1:normal 2:normal 3:reverted>5 4:reverted>5 5:revert>2"},
+		{"null edit", revertPage("a", "b", "b", "c"), 0,
+			"1:normal 2:normal 3:normal 4:normal"},
+		{"revert war", revertPage("a", "b", "a", "b"), 0,
+			"1:normal 2:reverted>3 3:reverted>4 4:revert>2"},
+		{"outside radius", revertPage("a", "b", "c", "a"), 2,
+			"1:normal 2:normal 3:normal 4:normal"},
+		{"undo", revertPage("a", "b", "c", "d|Undid revision 2 by [[Special:Contributions/X|X]]"), 0,
+			"1:normal 2:reverted>4 3:normal 4:revert>1(undo)"},
+		{"suppressed", revertPage("a", "", "b", "", "c"), 0,
+			"1:normal 2:normal 3:normal 4:normal 5:normal"},
+		{"revert over suppressed", revertPage("a", "", "a"), 0,
+			"1:normal 2:reverted>3 3:revert>1"},
+		{"undo unknown", revertPage("a", "b|undid revision 99"), 0,
+			"1:normal 2:revert>0(undo)"},
+	}
+	for _, test := range tests {
+		if got := labelString(PageReverts(test.page, test.radius)); got != test.exp {
+			t.Errorf("%v: Expected %v, got %v", test.name, test.exp, got)
+		}
+	}
+}
+
+func TestRevertDetectorStreaming(t *testing.T) {
+	t.Parallel()
+	d := NewRevertDetector(2)
+	p := revertPage("a", "b", "a", "c", "d")
+	var got []string
+	for i := range p.Revisions {
+		got = append(got, labelString(d.Add(&p.Revisions[i])))
+	}
+	got = append(got, labelString(d.Flush()))
+	exp := []string{"", "", "1:normal", "2:reverted>3", "3:revert>1", "4:normal 5:normal"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %q, got %q", exp, got)
+	}
+
+	// The detector starts over after a flush.
+	r := Revision{ID: 6, Text: "d"}
+	d.Add(&r)
+	if got := labelString(d.Flush()); got != "6:normal" {
+		t.Errorf("Expected 6:normal, got %v", got)
+	}
+}
+
+func TestRevertParentID(t *testing.T) {
+	t.Parallel()
+	p := &Page{Revisions: []Revision{
+		{ID: 10, Text: "a"},
+		{ID: 20, ParentID: 15, Text: "b"},
+		{ID: 30, Text: "c", Comment: "Undid revision 20"},
+	}}
+	if got := labelString(PageReverts(p, 0)); got != "10:normal 20:reverted>30 30:revert>15(undo)" {
+		t.Errorf("Unexpected labels: %v", got)
+	}
+}
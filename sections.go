@@ -0,0 +1,118 @@
+package wikiparse
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A Section is a part of an article under a heading.
+type Section struct {
+	// Level is the heading level (2 for == Heading ==), or 0 for
+	// the article itself.
+	Level int
+	// Heading is the plain text of the heading.
+	Heading string
+	// Anchor is the fragment id MediaWiki generates for the heading.
+	Anchor string
+	// Text is the wikitext between this heading and the next one.
+	Text string
+	// Pos and End are the byte range of the whole section within
+	// the article, including its heading and subsections.
+	Pos, End int
+	// BodyPos and BodyEnd are the byte range of Text.
+	BodyPos, BodyEnd int
+
+	Subsections []*Section
+}
+
+// ParseSections splits an article into a tree of sections.
+//
+// The returned Section represents the whole article; its Text is the
+// lead section before the first heading, and its Subsections are the
+// top level sections of the article.
+func ParseSections(text string) *Section {
+	root := &Section{End: len(text), BodyEnd: len(text)}
+	stack := []*Section{root}
+	anchors := anchorSet{}
+
+	prev := root
+	for _, n := range ParseWikitext(text) {
+		if n.Kind != HeadingNode {
+			continue
+		}
+		prev.BodyEnd = n.Pos
+		for len(stack) > 1 && stack[len(stack)-1].Level >= n.Level {
+			stack[len(stack)-1].End = n.Pos
+			stack = stack[:len(stack)-1]
+		}
+
+		s := &Section{
+			Level:   n.Level,
+			Heading: inlineText(n.Children),
+			Pos:     n.Pos,
+			End:     len(text),
+			BodyPos: n.End,
+			BodyEnd: len(text),
+		}
+		if s.BodyPos < len(text) && text[s.BodyPos] == '\n' {
+			s.BodyPos++
+		}
+		s.Anchor = anchors.add(s.Heading)
+
+		parent := stack[len(stack)-1]
+		parent.Subsections = append(parent.Subsections, s)
+		stack = append(stack, s)
+		prev = s
+	}
+
+	for _, s := range root.Flatten() {
+		s.Text = text[s.BodyPos:s.BodyEnd]
+	}
+	return root
+}
+
+// sectionAnchor generates the HTML5 id MediaWiki uses for a heading.
+func sectionAnchor(heading string) string {
+	return strings.Join(strings.Fields(strings.Replace(heading, "_", " ", -1)), "_")
+}
+
+// anchorSet generates unique anchors for the headings on a page.
+// Later headings with the same (case-insensitive) anchor get _2, _3,
+// etc. appended.
+type anchorSet map[string]int
+
+func (a anchorSet) add(heading string) string {
+	rv := sectionAnchor(heading)
+	key := strings.ToLower(rv)
+	a[key]++
+	if a[key] > 1 {
+		rv += "_" + strconv.Itoa(a[key])
+	}
+	return rv
+}
+
+// Flatten lists this section and all of its descendants in document
+// order.
+func (s *Section) Flatten() []*Section {
+	rv := []*Section{s}
+	for _, sub := range s.Subsections {
+		rv = append(rv, sub.Flatten()...)
+	}
+	return rv
+}
+
+// Find gets the first section (this one or a descendant) with the
+// given heading, ignoring case.
+func (s *Section) Find(heading string) *Section {
+	for _, sec := range s.Flatten() {
+		if strings.EqualFold(sec.Heading, heading) {
+			return sec
+		}
+	}
+	return nil
+}
+
+// PlainText gets the plain text of this section's own body.
+func (s *Section) PlainText() string {
+	return PlainText(s.Text)
+}
@@ -0,0 +1,97 @@
+package wikiparse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sectioned = `Lead text.
+== History ==
+Old stuff.
+=== Early ''years'' ===
+Very old.
+==== Detail ====
+Tiny.
+=== Later ===
+Less old.
+== See also ==
+* [[Foo]]
+== History ==
+Again.
+=Top=
+Bottom.
+`
+
+func TestSections(t *testing.T) {
+	t.Parallel()
+	root := ParseSections(sectioned)
+	if root.Text != "Lead text.\n" {
+		t.Errorf("Wrong lead: %q", root.Text)
+	}
+
+	type flat struct {
+		Level           int
+		Heading, Anchor string
+		Subs            int
+	}
+	var got []flat
+	for _, s := range root.Flatten()[1:] {
+		got = append(got, flat{s.Level, s.Heading, s.Anchor, len(s.Subsections)})
+	}
+	exp := []flat{
+		{2, "History", "History", 2},
+		{3, "Early years", "Early_years", 1},
+		{4, "Detail", "Detail", 0},
+		{3, "Later", "Later", 0},
+		{2, "See also", "See_also", 0},
+		{2, "History", "History_2", 0},
+		{1, "Top", "Top", 0},
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected\n%v, got\n%v", exp, got)
+	}
+
+	hist := root.Subsections[0]
+	if hist.Text != "Old stuff.\n" {
+		t.Errorf("Wrong history body: %q", hist.Text)
+	}
+	whole := sectioned[hist.Pos:hist.End]
+	if !strings.HasPrefix(whole, "== History ==") || !strings.HasSuffix(whole, "Less old.\n") {
+		t.Errorf("Wrong history range: %q", whole)
+	}
+	if sectioned[hist.BodyPos:hist.BodyEnd] != hist.Text {
+		t.Errorf("Body range doesn't match text")
+	}
+	if s := root.Find("see ALSO"); s == nil || s.PlainText() != "Foo" {
+		t.Errorf("Couldn't find see also section: %+v", s)
+	}
+	if s := root.Find("Missing"); s != nil {
+		t.Errorf("Found a missing section: %+v", s)
+	}
+	last := root.Subsections[len(root.Subsections)-1]
+	if last.End != len(sectioned) || last.Text != "Bottom.\n" {
+		t.Errorf("Wrong last section: %+v", last)
+	}
+}
+
+func TestSectionAnchors(t *testing.T) {
+	t.Parallel()
+	root := ParseSections("==A  b_c==\n==a b c==\n== [[Foo|Bar]] &amp; baz ==\n")
+	var got []string
+	for _, s := range root.Subsections {
+		got = append(got, s.Anchor)
+	}
+	exp := []string{"A_b_c", "a_b_c_2", "Bar_&_baz"}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestSectionsNoHeadings(t *testing.T) {
+	t.Parallel()
+	root := ParseSections("Just text.")
+	if len(root.Subsections) != 0 || root.Text != "Just text." {
+		t.Fatalf("Unexpected sections: %+v", root)
+	}
+}
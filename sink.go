@@ -0,0 +1,475 @@
+package wikiparse
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/dustin/go-wikiparse/internal/pipeline"
+)
+
+// RevInfo describes the revision a Document was built from.
+type RevInfo struct {
+	ID            uint64 `json:"id"`
+	Timestamp     string `json:"timestamp"`
+	Contributor   string `json:"contributor"`
+	ContributorID uint64 `json:"contributorid"`
+	Comment       string `json:"comment"`
+	SHA1          string `json:"sha1,omitempty"`
+}
+
+// DocumentGeo is the GeoJSON Feature locating a Document.
+type DocumentGeo struct {
+	Type     string   `json:"type"`
+	Geometry GeoPoint `json:"geometry"`
+}
+
+// A Document is the form of a page written to a Sink: the page's
+// latest revision and what was found in its text.
+type Document struct {
+	// ID is the document's key, the page id in decimal.  Documents
+	// used to be keyed by title, which changes when a page is moved;
+	// the loaders' -migrate flag removes those.
+	ID      string       `json:"-"`
+	Title   string       `json:"title"`
+	PageID  uint64       `json:"pageid"`
+	Ns      uint64       `json:"ns"`
+	RevInfo RevInfo      `json:"revinfo"`
+	Text    string       `json:"text"`
+	Geo     *DocumentGeo `json:"geo,omitempty"`
+	Files   []string     `json:"files,omitempty"`
+	Links   []string     `json:"links,omitempty"`
+}
+
+// NewDocument builds the Document for a page from its latest
+// revision.
+func NewDocument(p *Page) *Document {
+	d := &Document{
+		ID:     strconv.FormatUint(p.ID, 10),
+		Title:  p.Title,
+		PageID: p.ID,
+		Ns:     p.Ns,
+	}
+	if len(p.Revisions) == 0 {
+		return d
+	}
+	r := p.Revisions[len(p.Revisions)-1]
+	d.RevInfo = RevInfo{
+		ID:            r.ID,
+		Timestamp:     r.Timestamp,
+		Contributor:   r.Contributor.Name(),
+		ContributorID: r.Contributor.ID,
+		Comment:       r.Comment,
+		SHA1:          r.ContentSHA1(),
+	}
+	d.Text = r.Text
+	if c, err := ParseCoords(r.Text); err == nil {
+		d.Geo = &DocumentGeo{
+			Type:     "Feature",
+			Geometry: GeoPoint{Type: "Point", Coordinates: []float64{c.Lon, c.Lat}},
+		}
+	}
+	d.Files = FindFiles(r.Text)
+	d.Links = FindLinks(r.Text)
+	return d
+}
+
+// A Sink stores documents, such as in a database.
+type Sink interface {
+	// Write stores a batch of documents.  Load calls Write from
+	// several goroutines at once, and retries failed batches, so a
+	// batch may be written more than once.
+	Write(docs []*Document) error
+	// Flush makes sure everything written has been stored.
+	Flush() error
+	// Close flushes and releases the sink.
+	Close() error
+}
+
+// A RetryPolicy says how failed writes are retried.  The zero value
+// doesn't retry.
+type RetryPolicy struct {
+	// Attempts is the most times to try, including the first.
+	Attempts int
+	// Backoff is the wait before the first retry.  It doubles with
+	// each retry, up to MaxBackoff if that's set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable reports whether an error is worth retrying.  All
+	// errors are if it's nil.
+	Retryable func(error) bool
+}
+
+// Do calls fn until it succeeds or the policy gives up, returning
+// the last error.
+func (r RetryPolicy) Do(fn func() error) error {
+	wait := r.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.Attempts ||
+			(r.Retryable != nil && !r.Retryable(err)) {
+			return err
+		}
+		time.Sleep(wait)
+		if wait *= 2; r.MaxBackoff > 0 && wait > r.MaxBackoff {
+			wait = r.MaxBackoff
+		}
+	}
+}
+
+// An UpsertSink is a Sink that can say what it holds, so Load can
+// skip pages that haven't changed and delete pages that are gone.
+// Documents are keyed by page id.
+type UpsertSink interface {
+	Sink
+	// Stored gets the Title and RevInfo stored for the given pages,
+	// keyed by page id.  Pages that aren't stored are left out.
+	Stored(pageIDs []uint64) (map[uint64]*Document, error)
+	// Delete removes pages.
+	Delete(pageIDs []uint64) error
+	// PageIDs calls fn with the id of every stored page.  Missing
+	// pages are deleted by these ids, so it should skip anything
+	// else that's stored, whatever its key.
+	PageIDs(fn func(pageID uint64) error) error
+}
+
+// ErrNotUpsertSink is returned by Load when upserting to a sink that
+// isn't an UpsertSink.
+var ErrNotUpsertSink = errors.New("sink can't upsert")
+
+// LoadStats count the pages seen by Load.
+type LoadStats struct {
+	// Pages is the number of pages read from the parser.
+	Pages int64
+	// Written is the number of documents written to the sink.
+	Written int64
+	// Skipped is the number of pages filtered out or without
+	// revisions.
+	Skipped int64
+	// Unchanged is the number of pages not written because the
+	// sink has the same title and text.
+	Unchanged int64
+	// Stale is the number of pages not written because the sink
+	// has a newer revision.
+	Stale int64
+	// Deleted is the number of pages deleted from the sink because
+	// they weren't in the dump.
+	Deleted int64
+	// Failed is the number of documents in batches that couldn't be
+	// written.
+	Failed int64
+	// Elapsed is the time since the load started.
+	Elapsed time.Duration
+}
+
+func (s LoadStats) String() string {
+	return fmt.Sprintf("%s pages: %s written, %s unchanged, %s stale, %s skipped, %s deleted, %s failed",
+		humanize.Comma(s.Pages), humanize.Comma(s.Written), humanize.Comma(s.Unchanged),
+		humanize.Comma(s.Stale), humanize.Comma(s.Skipped), humanize.Comma(s.Deleted),
+		humanize.Comma(s.Failed))
+}
+
+// Rate gets the number of pages read per second, or 0 if no time has
+// elapsed.
+func (s LoadStats) Rate() float64 {
+	return rate(s.Pages, s.Elapsed)
+}
+
+func rate(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+// LoadOptions control Load.
+type LoadOptions struct {
+	// Workers is the number of goroutines building and writing
+	// documents.  It defaults to GOMAXPROCS.
+	Workers int
+	// BatchSize is the most documents passed to each Write.  It
+	// defaults to 100.
+	BatchSize int
+	// Retry is the policy for retrying failed writes.
+	Retry RetryPolicy
+	// ContinueOnError logs batches that still fail after retrying
+	// and carries on.  Otherwise the first such failure stops the
+	// load.
+	ContinueOnError bool
+	// Filter, if not nil, selects the pages to load.
+	Filter func(*Page) bool
+	// Upsert only writes a page if the sink, which must be an
+	// UpsertSink, doesn't have it, has an older revision of it, or
+	// has a different title or text (by SHA-1).
+	Upsert bool
+	// DeleteMissing deletes the pages the sink has that aren't in
+	// the dump, once the whole dump has been read.  Pages that are
+	// in it but skipped, by Filter or for having no revisions, are
+	// kept.  The sink must be an UpsertSink.
+	DeleteMissing bool
+	// Progress, if not nil, is called every ProgressEvery pages
+	// (default 1000).
+	Progress      func(LoadStats)
+	ProgressEvery int64
+	// Logf, if not nil, logs failed batches.
+	Logf func(format string, args ...interface{})
+}
+
+// changed decides whether to write a document over what's stored.
+func changed(d, stored *Document) (write, stale bool) {
+	switch {
+	case stored == nil:
+		return true, false
+	case stored.RevInfo.ID > d.RevInfo.ID:
+		return false, true
+	case stored.Title == d.Title && stored.RevInfo.SHA1 != "" &&
+		stored.RevInfo.SHA1 == d.RevInfo.SHA1:
+		return false, false
+	}
+	return true, false
+}
+
+// pageSet is a set of page ids.  Page ids are dense, so it's a
+// bitmap.
+type pageSet []uint64
+
+func (s *pageSet) add(id uint64) {
+	for uint64(len(*s)) <= id/64 {
+		*s = append(*s, 0)
+	}
+	(*s)[id/64] |= 1 << (id % 64)
+}
+
+func (s pageSet) has(id uint64) bool {
+	return id/64 < uint64(len(s)) && s[id/64]&(1<<(id%64)) != 0
+}
+
+// LogProgress gets a LoadOptions.Progress function that logs the
+// pages processed and the rate since the last call.
+func LogProgress(logf func(format string, args ...interface{})) func(LoadStats) {
+	var prev LoadStats
+	return func(s LoadStats) {
+		logf("Processed %s pages total (%.2f/s)",
+			humanize.Comma(s.Pages), rate(s.Pages-prev.Pages, s.Elapsed-prev.Elapsed))
+		prev = s
+	}
+}
+
+// Load reads every page from p and writes its Document to s, then
+// flushes s.  Documents are built by several workers and written in
+// batches by several more, so they may be stored out of order.  The
+// sink isn't closed.
+func Load(p Parser, s Sink, opts LoadOptions) (LoadStats, error) {
+	us, _ := s.(UpsertSink)
+	if us == nil && (opts.Upsert || opts.DeleteMissing) {
+		return LoadStats{}, ErrNotUpsertSink
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.GOMAXPROCS(0)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.ProgressEvery <= 0 {
+		opts.ProgressEvery = 1000
+	}
+	logf := opts.Logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+
+	start := time.Now()
+	var pages, written, skipped, unchanged, stale, deleted, failed int64
+	stats := func() LoadStats {
+		return LoadStats{
+			Pages:     atomic.LoadInt64(&pages),
+			Written:   atomic.LoadInt64(&written),
+			Skipped:   atomic.LoadInt64(&skipped),
+			Unchanged: atomic.LoadInt64(&unchanged),
+			Stale:     atomic.LoadInt64(&stale),
+			Deleted:   atomic.LoadInt64(&deleted),
+			Failed:    atomic.LoadInt64(&failed),
+			Elapsed:   time.Since(start),
+		}
+	}
+
+	var errOnce sync.Once
+	var loadErr error
+	done := make(chan struct{})
+	fail := func(err error) {
+		errOnce.Do(func() {
+			loadErr = err
+			close(done)
+		})
+	}
+
+	// upsert drops the documents that needn't be written.
+	upsert := func(batch []*Document) ([]*Document, error) {
+		ids := make([]uint64, len(batch))
+		for i, d := range batch {
+			ids[i] = d.PageID
+		}
+		var stored map[uint64]*Document
+		err := opts.Retry.Do(func() (err error) {
+			stored, err = us.Stored(ids)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		rv := batch[:0]
+		for _, d := range batch {
+			switch write, old := changed(d, stored[d.PageID]); {
+			case write:
+				rv = append(rv, d)
+			case old:
+				atomic.AddInt64(&stale, 1)
+			default:
+				atomic.AddInt64(&unchanged, 1)
+			}
+		}
+		return rv, nil
+	}
+
+	write := func(batch []*Document) {
+		if len(batch) == 0 {
+			return
+		}
+		first, n := batch[0].ID, len(batch)
+		var err error
+		if opts.Upsert {
+			batch, err = upsert(batch)
+		}
+		if err == nil && len(batch) > 0 {
+			err = opts.Retry.Do(func() error { return s.Write(batch) })
+		}
+		if err == nil {
+			atomic.AddInt64(&written, int64(len(batch)))
+			return
+		}
+		atomic.AddInt64(&failed, int64(n))
+		if !opts.ContinueOnError {
+			fail(fmt.Errorf("writing %q..: %w", first, err))
+			return
+		}
+		logf("Error writing %d documents starting with %q: %v", n, first, err)
+	}
+
+	batches := make(chan []*Document, opts.Workers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				write(batch)
+			}
+		}()
+	}
+	// stopped gets the load's error once it has failed, so no more
+	// of the dump is read.
+	stopped := func() error {
+		select {
+		case <-done:
+			return loadErr
+		default:
+			return nil
+		}
+	}
+	send := func(batch []*Document) error {
+		if err := stopped(); err != nil {
+			return err
+		}
+		select {
+		case batches <- batch:
+			return nil
+		case <-done:
+			return loadErr
+		}
+	}
+
+	var seen pageSet
+	batch := make([]*Document, 0, opts.BatchSize)
+	err := pipeline.Ordered(p.Next, opts.Workers,
+		func(page *Page) (bool, error) {
+			if err := stopped(); err != nil {
+				return false, err
+			}
+			n := atomic.AddInt64(&pages, 1)
+			// Skipped pages are still in the dump, so they
+			// aren't deleted.
+			if opts.DeleteMissing {
+				seen.add(page.ID)
+			}
+			keep := len(page.Revisions) > 0 && (opts.Filter == nil || opts.Filter(page))
+			if !keep {
+				atomic.AddInt64(&skipped, 1)
+			}
+			if opts.Progress != nil && n%opts.ProgressEvery == 0 {
+				opts.Progress(stats())
+			}
+			return keep, nil
+		}, NewDocument,
+		func(d *Document) error {
+			if batch = append(batch, d); len(batch) < opts.BatchSize {
+				return nil
+			}
+			full := batch
+			batch = make([]*Document, 0, opts.BatchSize)
+			return send(full)
+		})
+	if err == nil && len(batch) > 0 {
+		err = send(batch)
+	}
+	close(batches)
+	wg.Wait()
+
+	if err != nil {
+		fail(err)
+	}
+	if loadErr == nil && opts.DeleteMissing {
+		if err := deleteMissing(us, seen, opts, &deleted); err != nil {
+			fail(err)
+		}
+	}
+	if loadErr == nil {
+		if err := s.Flush(); err != nil {
+			fail(err)
+		}
+	}
+	return stats(), loadErr
+}
+
+// deleteMissing deletes the pages the sink has that weren't seen.
+// The ids are all gathered first, as the sink may not allow deleting
+// while listing.
+func deleteMissing(us UpsertSink, seen pageSet, opts LoadOptions, deleted *int64) error {
+	var missing []uint64
+	err := us.PageIDs(func(id uint64) error {
+		if !seen.has(id) {
+			missing = append(missing, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("listing pages: %w", err)
+	}
+	for len(missing) > 0 {
+		n := opts.BatchSize
+		if n > len(missing) {
+			n = len(missing)
+		}
+		batch := missing[:n]
+		if err := opts.Retry.Do(func() error { return us.Delete(batch) }); err != nil {
+			return fmt.Errorf("deleting pages: %w", err)
+		}
+		atomic.AddInt64(deleted, int64(n))
+		missing = missing[n:]
+	}
+	return nil
+}
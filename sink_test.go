@@ -0,0 +1,365 @@
+package wikiparse
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memSink stores documents in memory, failing the first failures
+// writes.
+type memSink struct {
+	mu       sync.Mutex
+	docs     map[string]*Document
+	batches  int
+	failures int
+	flushed  bool
+}
+
+func newMemSink() *memSink {
+	return &memSink{docs: map[string]*Document{}}
+}
+
+func (m *memSink) Write(docs []*Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches++
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("temporarily unavailable")
+	}
+	for _, d := range docs {
+		m.docs[d.ID] = d
+	}
+	return nil
+}
+
+func (m *memSink) Flush() error {
+	m.flushed = true
+	return nil
+}
+
+func (m *memSink) Close() error { return nil }
+
+func (m *memSink) Stored(ids []uint64) (map[uint64]*Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rv := map[uint64]*Document{}
+	for _, id := range ids {
+		if d, ok := m.docs[strconv.FormatUint(id, 10)]; ok {
+			rv[id] = d
+		}
+	}
+	return rv, nil
+}
+
+func (m *memSink) Delete(ids []uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		delete(m.docs, strconv.FormatUint(id, 10))
+	}
+	return nil
+}
+
+func (m *memSink) PageIDs(fn func(uint64) error) error {
+	for _, d := range m.docs {
+		if err := fn(d.PageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadDump(n int) string {
+	b := &strings.Builder{}
+	b.WriteString("<mediawiki><siteinfo><sitename>x</sitename></siteinfo>\n")
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(b, "<page><title>Page %d</title><ns>%d</ns><id>%d</id>"+
+			"<revision><id>%d</id><timestamp>2020-01-01T00:00:00Z</timestamp>"+
+			"<contributor><username>Ed</username><id>3</id></contributor>"+
+			"<text>See [[Page %d]]. {{coord|44.5|-63.5}}</text></revision></page>\n",
+			i, i%2, i, i*10, i+1)
+	}
+	b.WriteString("<page><title>Empty</title><id>999</id></page>\n</mediawiki>")
+	return b.String()
+}
+
+func TestNewDocument(t *testing.T) {
+	t.Parallel()
+	d := NewDocument(&Page{Title: "Halifax", ID: 3, Revisions: []Revision{
+		{ID: 1, Text: "old"},
+		{ID: 2, Timestamp: "2020-01-01T00:00:00Z", Comment: "hi",
+			Contributor: Contributor{ID: 7, Username: "Ed"},
+			Text:        "[[File:Harbour.jpg]] [[Dartmouth]] {{coord|44.65|-63.57}}"},
+	}})
+	if d.ID != "3" || d.PageID != 3 || d.RevInfo.ID != 2 ||
+		d.RevInfo.Contributor != "Ed" || d.RevInfo.ContributorID != 7 || d.RevInfo.Comment != "hi" ||
+		d.RevInfo.SHA1 != TextSHA1(d.Text) {
+		t.Errorf("Unexpected document: %+v", d)
+	}
+	if d.Geo == nil || d.Geo.Geometry.Coordinates[0] != -63.57 || d.Geo.Geometry.Coordinates[1] != 44.65 {
+		t.Errorf("Expected geo, got %+v", d.Geo)
+	}
+	if len(d.Files) != 1 || d.Files[0] != "Harbour.jpg" {
+		t.Errorf("Expected [Harbour.jpg], got %v", d.Files)
+	}
+	if len(d.Links) != 2 || d.Links[1] != "Dartmouth" {
+		t.Errorf("Expected links, got %v", d.Links)
+	}
+
+	if d := NewDocument(&Page{Title: "Empty", ID: 9}); d.ID != "9" || d.Text != "" {
+		t.Errorf("Unexpected document: %+v", d)
+	}
+
+	anon := &Page{Title: "Anon", ID: 4, Revisions: []Revision{{Contributor: Contributor{IP: "192.0.2.1"}}}}
+	if d := NewDocument(anon); d.RevInfo.Contributor != "192.0.2.1" {
+		t.Errorf("Expected IP contributor, got %q", d.RevInfo.Contributor)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+	p, err := NewParser(strings.NewReader(loadDump(25)))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	s := newMemSink()
+	var progress []int64
+	st, err := Load(p, s, LoadOptions{
+		Workers:       3,
+		BatchSize:     4,
+		Filter:        func(p *Page) bool { return p.Ns == 0 },
+		Progress:      func(s LoadStats) { progress = append(progress, s.Pages) },
+		ProgressEvery: 10,
+	})
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	if st.Pages != 26 || st.Written != 12 || st.Skipped != 14 || st.Failed != 0 {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+	if len(s.docs) != 12 || s.docs["4"] == nil || s.docs["4"].RevInfo.ID != 40 {
+		t.Errorf("Unexpected documents: %v", s.docs)
+	}
+	if !s.flushed {
+		t.Errorf("Expected the sink to be flushed")
+	}
+	if fmt.Sprint(progress) != "[10 20]" {
+		t.Errorf("Expected progress at [10 20], got %v", progress)
+	}
+}
+
+func TestLoadRetries(t *testing.T) {
+	t.Parallel()
+	p, err := NewParser(strings.NewReader(loadDump(3)))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	s := newMemSink()
+	s.failures = 2
+	st, err := Load(p, s, LoadOptions{
+		Workers: 1,
+		Retry:   RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	if st.Written != 3 || s.batches != 3 {
+		t.Errorf("Expected 3 written in 3 tries, got %+v in %v", st, s.batches)
+	}
+}
+
+func TestLoadFailure(t *testing.T) {
+	t.Parallel()
+	p, err := NewParser(strings.NewReader(loadDump(10)))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	s := newMemSink()
+	s.failures = 1
+	_, err = Load(p, s, LoadOptions{Workers: 1, BatchSize: 2})
+	if err == nil || !strings.Contains(err.Error(), "temporarily unavailable") {
+		t.Errorf("Expected a write error, got %v", err)
+	}
+	if s.flushed {
+		t.Errorf("Expected no flush after a failure")
+	}
+}
+
+// notifySink closes written on its first Write.
+type notifySink struct {
+	*memSink
+	written chan struct{}
+	once    sync.Once
+}
+
+func (n *notifySink) Write(docs []*Document) error {
+	defer n.once.Do(func() { close(n.written) })
+	return n.memSink.Write(docs)
+}
+
+func TestLoadFailureStopsReading(t *testing.T) {
+	t.Parallel()
+	p, err := NewParser(strings.NewReader(loadDump(1000)))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	s := &notifySink{memSink: newMemSink(), written: make(chan struct{})}
+	s.failures = 1
+	st, err := Load(p, s, LoadOptions{
+		Workers:   1,
+		BatchSize: 1,
+		Filter: func(p *Page) bool {
+			if p.ID == 2 {
+				<-s.written
+				time.Sleep(10 * time.Millisecond)
+			}
+			return p.ID == 1
+		},
+	})
+	if err == nil || st.Pages != 2 {
+		t.Errorf("Expected to stop reading after 2 pages, got %+v, %v", st, err)
+	}
+}
+
+func TestLoadContinueOnError(t *testing.T) {
+	t.Parallel()
+	p, err := NewParser(strings.NewReader(loadDump(10)))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	s := newMemSink()
+	s.failures = 1
+	var logged []string
+	st, err := Load(p, s, LoadOptions{
+		Workers:         1,
+		BatchSize:       2,
+		ContinueOnError: true,
+		Retry: RetryPolicy{Attempts: 5, Retryable: func(error) bool {
+			return false
+		}},
+		Logf: func(f string, args ...interface{}) { logged = append(logged, fmt.Sprintf(f, args...)) },
+	})
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	if st.Written != 8 || st.Failed != 2 || len(logged) != 1 {
+		t.Errorf("Unexpected stats %+v, logged %q", st, logged)
+	}
+	var keys []string
+	for k := range s.docs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if keys[0] != "10" || keys[1] != "3" {
+		t.Errorf("Expected the first batch to be missing, got %v", keys)
+	}
+}
+
+func TestLoadUpsert(t *testing.T) {
+	t.Parallel()
+	page := func(id, rev int, title, text string) string {
+		return fmt.Sprintf("<page><title>%s</title><id>%d</id><revision><id>%d</id><text>%s</text></revision></page>\n",
+			title, id, rev, text)
+	}
+	load := func(s *memSink, pages ...string) LoadStats {
+		p, err := NewParser(strings.NewReader("<mediawiki><siteinfo></siteinfo>\n" +
+			strings.Join(pages, "") + "</mediawiki>"))
+		if err != nil {
+			t.Fatalf("Error parsing: %v", err)
+		}
+		st, err := Load(p, s, LoadOptions{Workers: 2, BatchSize: 2, Upsert: true, DeleteMissing: true})
+		if err != nil {
+			t.Fatalf("Error loading: %v", err)
+		}
+		return st
+	}
+
+	s := newMemSink()
+	load(s,
+		page(1, 10, "Same", "same"),
+		page(2, 20, "Edited", "before"),
+		page(3, 30, "Newer", "newer"),
+		page(4, 40, "Moved", "moved"),
+		page(5, 50, "Gone", "gone"))
+	st := load(s,
+		page(1, 11, "Same", "same"),
+		page(2, 21, "Edited", "after"),
+		page(3, 29, "Newer", "older"),
+		page(4, 41, "Moved away", "moved"),
+		page(6, 60, "New", "new"))
+
+	exp := LoadStats{Pages: 5, Written: 3, Unchanged: 1, Stale: 1, Deleted: 1}
+	st.Elapsed = 0
+	if st != exp {
+		t.Errorf("Expected %+v, got %+v", exp, st)
+	}
+	revs := map[string]uint64{}
+	for k, d := range s.docs {
+		revs[k] = d.RevInfo.ID
+	}
+	expRevs := map[string]uint64{"1": 10, "2": 21, "3": 30, "4": 41, "6": 60}
+	if !reflect.DeepEqual(revs, expRevs) {
+		t.Errorf("Expected %v, got %v", expRevs, revs)
+	}
+}
+
+func TestLoadDeleteMissingSkipped(t *testing.T) {
+	t.Parallel()
+	s := newMemSink()
+	s.docs["999"] = &Document{ID: "999", PageID: 999}
+	for _, filter := range []func(*Page) bool{nil, func(p *Page) bool { return p.Ns == 0 }} {
+		p, err := NewParser(strings.NewReader(loadDump(4)))
+		if err != nil {
+			t.Fatalf("Error parsing: %v", err)
+		}
+		if _, err := Load(p, s, LoadOptions{Filter: filter, Upsert: true, DeleteMissing: true}); err != nil {
+			t.Fatalf("Error loading: %v", err)
+		}
+	}
+	if len(s.docs) != 5 {
+		t.Errorf("Expected the skipped pages kept, got %v", s.docs)
+	}
+}
+
+func TestLoadNotUpsertSink(t *testing.T) {
+	t.Parallel()
+	p, err := NewParser(strings.NewReader(loadDump(1)))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	s := struct{ Sink }{newMemSink()}
+	if _, err := Load(p, s, LoadOptions{DeleteMissing: true}); err != ErrNotUpsertSink {
+		t.Errorf("Expected ErrNotUpsertSink, got %v", err)
+	}
+}
+
+func TestLoadStatsRate(t *testing.T) {
+	t.Parallel()
+	if r := (LoadStats{Pages: 10}).Rate(); r != 0 {
+		t.Errorf("Expected 0 with no time elapsed, got %v", r)
+	}
+	if r := (LoadStats{Pages: 10, Elapsed: 2 * time.Second}).Rate(); r != 5 {
+		t.Errorf("Expected 5, got %v", r)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+	r := RetryPolicy{Attempts: 4, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	tries := 0
+	err := r.Do(func() error {
+		tries++
+		return errors.New("no")
+	})
+	if err == nil || tries != 4 {
+		t.Errorf("Expected 4 failed tries, got %v (%v)", tries, err)
+	}
+}
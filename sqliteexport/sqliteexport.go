@@ -0,0 +1,321 @@
+// Package sqliteexport writes dumps to a single SQLite database for
+// ad-hoc SQL, with full-text search over the articles' plain text.
+//
+// The database has these tables:
+//
+//	pages(id, title, ns, redirect, rev_id, revisions, bytes)
+//	revisions(id, page_id, timestamp, contributor, contributor_id, comment, bytes, text)
+//	redirects(title, target, section, hops, loop)
+//	categories(page_id, category)
+//	links(page_id, target)
+//	pages_fts(title, text)  -- FTS5, rowid is the page id
+//
+// Redirect targets are resolved through chains of redirects, and
+// revision text is only stored when asked for.
+package sqliteexport
+
+import (
+	"database/sql"
+	"errors"
+	"runtime"
+
+	"github.com/dustin/go-wikiparse"
+	"github.com/dustin/go-wikiparse/internal/pipeline"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// DefaultBatchSize is the number of pages written per transaction
+// when Options doesn't give one.
+const DefaultBatchSize = 1000
+
+const schema = `
+CREATE TABLE IF NOT EXISTS pages (
+	id INTEGER PRIMARY KEY,
+	title TEXT NOT NULL,
+	ns INTEGER NOT NULL,
+	redirect TEXT,
+	rev_id INTEGER,
+	revisions INTEGER NOT NULL,
+	bytes INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pages_title ON pages (title);
+CREATE TABLE IF NOT EXISTS revisions (
+	id INTEGER PRIMARY KEY,
+	page_id INTEGER NOT NULL,
+	timestamp TEXT NOT NULL,
+	contributor TEXT,
+	contributor_id INTEGER,
+	comment TEXT,
+	bytes INTEGER NOT NULL,
+	text TEXT
+);
+CREATE INDEX IF NOT EXISTS revisions_page ON revisions (page_id);
+CREATE TABLE IF NOT EXISTS redirects (
+	title TEXT PRIMARY KEY,
+	target TEXT NOT NULL,
+	section TEXT,
+	hops INTEGER NOT NULL,
+	loop INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS categories (
+	page_id INTEGER NOT NULL,
+	category TEXT NOT NULL,
+	PRIMARY KEY (page_id, category)
+);
+CREATE INDEX IF NOT EXISTS categories_category ON categories (category);
+CREATE TABLE IF NOT EXISTS links (
+	page_id INTEGER NOT NULL,
+	target TEXT NOT NULL,
+	PRIMARY KEY (page_id, target)
+);
+CREATE INDEX IF NOT EXISTS links_target ON links (target);
+CREATE VIRTUAL TABLE IF NOT EXISTS pages_fts USING fts5 (title, text);
+`
+
+// Options controls an export.
+type Options struct {
+	// Fields selects the optional data: wikiparse.ExportText stores
+	// the text of each revision.  Links, categories and the
+	// full-text index are always written.
+	Fields wikiparse.ExportFields
+	// BatchSize is the number of pages written per transaction.
+	BatchSize int
+	// Workers is the number of goroutines extracting rows from
+	// pages.  It defaults to GOMAXPROCS.
+	Workers int
+	// Filter, if not nil, selects the pages to export.  Redirects
+	// are resolved through all redirects, filtered or not.
+	Filter func(*wikiparse.Page) bool
+}
+
+// Stats counts the rows written by an export.
+type Stats struct {
+	Pages, Revisions, Redirects, Links, Categories int64
+}
+
+// row is what's written for one page.
+type row struct {
+	page       *wikiparse.Page
+	plain      string
+	links      []string
+	categories []string
+}
+
+func extract(p *wikiparse.Page) *row {
+	rv := &row{page: p}
+	if len(p.Revisions) == 0 || p.Redir.Title != "" {
+		return rv
+	}
+	text := p.Revisions[len(p.Revisions)-1].Text
+	rv.plain = wikiparse.PlainText(text)
+	seen := map[string]bool{}
+	for _, l := range wikiparse.FindLinks(text) {
+		if !seen[l] {
+			seen[l] = true
+			rv.links = append(rv.links, l)
+		}
+	}
+	rv.categories = wikiparse.FindCategories(text)
+	return rv
+}
+
+// Create opens (creating if needed) the database at path and
+// makes sure it has the export's tables.
+func Create(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// Writes are serialized anyway, and pragmas are per connection.
+	db.SetMaxOpenConns(1)
+	for _, q := range []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		schema,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// Export reads every page from p and writes it to the SQLite
+// database at path.  Pages already in the database are replaced.  On
+// error the database may hold some of the pages, as with ExportDB.
+func Export(p wikiparse.Parser, path string, opts Options) (Stats, error) {
+	db, err := Create(path)
+	if err != nil {
+		return Stats{}, err
+	}
+	st, err := ExportDB(p, db, opts)
+	return st, errors.Join(err, db.Close())
+}
+
+// ExportDB is Export to a database opened by Create.  Rows are
+// extracted from pages by several workers, but are written in the
+// order the parser returned the pages.
+//
+// Pages are committed every Options.BatchSize pages, so an error only
+// rolls back the current batch: the database keeps the pages written
+// before it.  Exporting the same dump again replaces them.
+func ExportDB(p wikiparse.Parser, db *sql.DB, opts Options) (Stats, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.GOMAXPROCS(0)
+	}
+	w := &writer{db: db, fields: opts.Fields, batch: opts.BatchSize}
+	redirects := wikiparse.NewRedirectResolver()
+
+	err := pipeline.Ordered(p.Next, opts.Workers,
+		func(page *wikiparse.Page) (bool, error) {
+			// The resolver isn't safe for concurrent use, so
+			// redirects are collected here rather than by the
+			// workers.
+			redirects.AddPage(page)
+			return opts.Filter == nil || opts.Filter(page), nil
+		}, extract, w.write)
+	if err != nil {
+		return w.st, errors.Join(err, w.rollback())
+	}
+	if err := w.writeRedirects(redirects); err != nil {
+		return w.st, errors.Join(err, w.rollback())
+	}
+	return w.st, w.commit()
+}
+
+// writer writes pages in batched transactions.
+type writer struct {
+	db     *sql.DB
+	fields wikiparse.ExportFields
+	batch  int
+
+	tx      *sql.Tx
+	pending int
+	st      Stats
+}
+
+func (w *writer) begin() error {
+	if w.tx != nil {
+		return nil
+	}
+	var err error
+	w.tx, err = w.db.Begin()
+	return err
+}
+
+func (w *writer) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Commit()
+	w.tx, w.pending = nil, 0
+	return err
+}
+
+func (w *writer) rollback() error {
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Rollback()
+	w.tx, w.pending = nil, 0
+	return err
+}
+
+func (w *writer) exec(q string, args ...interface{}) error {
+	_, err := w.tx.Exec(q, args...)
+	return err
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (w *writer) write(r *row) error {
+	if err := w.begin(); err != nil {
+		return err
+	}
+	p := r.page
+	for _, q := range []string{
+		"DELETE FROM revisions WHERE page_id = ?",
+		"DELETE FROM categories WHERE page_id = ?",
+		"DELETE FROM links WHERE page_id = ?",
+		"DELETE FROM pages_fts WHERE rowid = ?",
+	} {
+		if err := w.exec(q, p.ID); err != nil {
+			return err
+		}
+	}
+
+	var revID interface{}
+	var bytes int
+	for _, rev := range p.Revisions {
+		var text interface{}
+		if w.fields&wikiparse.ExportText != 0 {
+			text = rev.Text
+		}
+		err := w.exec(`INSERT OR REPLACE INTO revisions
+			(id, page_id, timestamp, contributor, contributor_id, comment, bytes, text)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			rev.ID, p.ID, rev.Timestamp, nullString(rev.Contributor.Name()),
+			rev.Contributor.ID, nullString(rev.Comment), len(rev.Text), text)
+		if err != nil {
+			return err
+		}
+		revID, bytes = rev.ID, len(rev.Text)
+	}
+	err := w.exec(`INSERT OR REPLACE INTO pages
+		(id, title, ns, redirect, rev_id, revisions, bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.Title, p.Ns, nullString(p.Redir.Title), revID, len(p.Revisions), bytes)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range r.links {
+		if err := w.exec("INSERT OR IGNORE INTO links (page_id, target) VALUES (?, ?)", p.ID, l); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.categories {
+		if err := w.exec("INSERT OR IGNORE INTO categories (page_id, category) VALUES (?, ?)", p.ID, c); err != nil {
+			return err
+		}
+	}
+	if p.Redir.Title == "" {
+		if err := w.exec("INSERT INTO pages_fts (rowid, title, text) VALUES (?, ?, ?)", p.ID, p.Title, r.plain); err != nil {
+			return err
+		}
+	}
+
+	w.st.Pages++
+	w.st.Revisions += int64(len(p.Revisions))
+	w.st.Links += int64(len(r.links))
+	w.st.Categories += int64(len(r.categories))
+	if w.pending++; w.pending >= w.batch {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *writer) writeRedirects(r *wikiparse.RedirectResolver) error {
+	if err := w.begin(); err != nil {
+		return err
+	}
+	for from, to := range r.Map() {
+		err := w.exec(`INSERT OR REPLACE INTO redirects (title, target, section, hops, loop)
+			VALUES (?, ?, ?, ?, ?)`, from, to.Title, nullString(to.Section), to.Hops, to.Loop)
+		if err != nil {
+			return err
+		}
+		w.st.Redirects++
+	}
+	return nil
+}
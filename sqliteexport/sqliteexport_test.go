@@ -0,0 +1,148 @@
+package sqliteexport
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+const dump = `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Halifax</title><ns>0</ns><id>10</id>
+<revision><id>100</id><timestamp>2020-01-02T03:04:05Z</timestamp><contributor><username>Alice</username><id>7</id></contributor><comment>new</comment><text>A '''harbour''' city.</text></revision>
+<revision><id>101</id><timestamp>2021-01-02T03:04:05Z</timestamp><contributor><username>Bob</username><id>8</id></contributor><text>A '''harbour''' city near [[Dartmouth]] and [[Peggy's Cove]], [[Dartmouth]]. [[Category:Cities]]</text></revision>
+</page>
+<page><title>Peggy's Cove</title><ns>0</ns><id>20</id><redirect title="Peggys Cove" />
+<revision><id>200</id><timestamp>2022-01-02T03:04:05Z</timestamp><text>#REDIRECT [[Peggys Cove#Lighthouse]]</text></revision>
+</page>
+<page><title>Peggys Cove</title><ns>0</ns><id>30</id>
+<revision><id>300</id><timestamp>2023-01-02T03:04:05Z</timestamp><text>A village with a lighthouse. [[Category:Villages]] [[Category:Lighthouses]]</text></revision>
+</page>
+<page><title>Talk:Halifax</title><ns>1</ns><id>40</id>
+<revision><id>400</id><timestamp>2024-01-02T03:04:05Z</timestamp><contributor><ip>192.0.2.1</ip></contributor><text>Which harbour?</text></revision>
+</page>
+</mediawiki>`
+
+func export(t *testing.T, opts Options) (*sql.DB, Stats) {
+	p, err := wikiparse.NewParser(strings.NewReader(dump))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	fn := filepath.Join(t.TempDir(), "wiki.db")
+	st, err := Export(p, fn, opts)
+	if err != nil {
+		t.Fatalf("Error exporting: %v", err)
+	}
+	db, err := sql.Open("sqlite", fn)
+	if err != nil {
+		t.Fatalf("Error opening: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, st
+}
+
+func queryStrings(t *testing.T, db *sql.DB, q string, args ...interface{}) []string {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		t.Fatalf("Error querying %q: %v", q, err)
+	}
+	defer rows.Close()
+	var rv []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatalf("Error scanning %q: %v", q, err)
+		}
+		rv = append(rv, s)
+	}
+	return rv
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+	db, st := export(t, Options{BatchSize: 2, Workers: 3})
+
+	exp := Stats{Pages: 4, Revisions: 5, Redirects: 1, Links: 5, Categories: 3}
+	if st != exp {
+		t.Errorf("Expected %+v, got %+v", exp, st)
+	}
+
+	tests := []struct {
+		q   string
+		exp []string
+	}{
+		{"SELECT title FROM pages ORDER BY id",
+			[]string{"Halifax", "Peggy's Cove", "Peggys Cove", "Talk:Halifax"}},
+		{"SELECT rev_id || ':' || revisions || ':' || bytes FROM pages WHERE id = 10",
+			[]string{"101:2:96"}},
+		{"SELECT contributor || ':' || contributor_id || ':' || comment FROM revisions WHERE id = 100",
+			[]string{"Alice:7:new"}},
+		{"SELECT contributor FROM revisions WHERE id = 400", []string{"192.0.2.1"}},
+		{"SELECT count(*) FROM revisions WHERE text IS NOT NULL", []string{"0"}},
+		{"SELECT target FROM links WHERE page_id = 10 ORDER BY target",
+			[]string{"Category:Cities", "Dartmouth", "Peggy's Cove"}},
+		{"SELECT category FROM categories ORDER BY category",
+			[]string{"Cities", "Lighthouses", "Villages"}},
+		{"SELECT target || '#' || section || ':' || hops FROM redirects WHERE title = 'Peggy''s Cove'",
+			[]string{"Peggys Cove#Lighthouse:1"}},
+		{"SELECT title FROM pages_fts WHERE pages_fts MATCH 'harbour' ORDER BY rowid",
+			[]string{"Halifax", "Talk:Halifax"}},
+		{"SELECT title FROM pages_fts WHERE pages_fts MATCH 'lighthouse'",
+			[]string{"Peggys Cove"}},
+		{"SELECT count(*) FROM pages_fts WHERE text LIKE '%[[%'", []string{"0"}},
+	}
+	for _, test := range tests {
+		got := queryStrings(t, db, test.q)
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("Expected %q from %q, got %q", test.exp, test.q, got)
+		}
+	}
+}
+
+func TestExportTextFiltered(t *testing.T) {
+	t.Parallel()
+	db, st := export(t, Options{
+		Fields: wikiparse.ExportText,
+		Filter: func(p *wikiparse.Page) bool { return p.Ns == 0 },
+	})
+	if st.Pages != 3 || st.Redirects != 1 {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+	got := queryStrings(t, db, "SELECT text FROM revisions WHERE id = 300")
+	if len(got) != 1 || !strings.HasPrefix(got[0], "A village") {
+		t.Errorf("Expected revision text, got %q", got)
+	}
+}
+
+func TestExportReplaces(t *testing.T) {
+	t.Parallel()
+	fn := filepath.Join(t.TempDir(), "wiki.db")
+	for i := 0; i < 2; i++ {
+		p, err := wikiparse.NewParser(strings.NewReader(dump))
+		if err != nil {
+			t.Fatalf("Error parsing: %v", err)
+		}
+		if _, err := Export(p, fn, Options{}); err != nil {
+			t.Fatalf("Error exporting: %v", err)
+		}
+	}
+	db, err := sql.Open("sqlite", fn)
+	if err != nil {
+		t.Fatalf("Error opening: %v", err)
+	}
+	defer db.Close()
+	for q, exp := range map[string]string{
+		"SELECT count(*) FROM pages":      "4",
+		"SELECT count(*) FROM revisions":  "5",
+		"SELECT count(*) FROM links":      "5",
+		"SELECT count(*) FROM categories": "3",
+		"SELECT count(*) FROM pages_fts":  "3",
+	} {
+		if got := queryStrings(t, db, q); len(got) != 1 || got[0] != exp {
+			t.Errorf("Expected %v from %q, got %v", exp, q, got)
+		}
+	}
+}
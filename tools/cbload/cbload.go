@@ -2,22 +2,23 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
-	"sync"
+	"strconv"
 	"time"
 
 	"github.com/couchbase/go-couchbase"
-	"github.com/dustin/go-humanize"
 	"github.com/dustin/go-wikiparse"
 )
 
 var numWorkers = flag.Int("numWorkers", 8, "Number of page workers")
-
-var wg sync.WaitGroup
+var deleteMissing = flag.Bool("delete", false, "Delete pages that aren't in the dump")
+var migrate = flag.Bool("migrate", false,
+	"Delete the documents older versions stored by title before loading")
 
 func init() {
 	flag.Usage = usage
@@ -32,58 +33,173 @@ func usage() {
 	os.Exit(1)
 }
 
-type geo struct {
-	Geometry struct {
-		Type        string    `json:"type"`
-		Coordinates []float64 `json:"coordinates"`
-	} `json:"geometry"`
-	Type string `json:"type"`
-}
-
-type article struct {
-	RevInfo struct {
-		ID            uint64 `json:"id"`
-		Timestamp     string `json:"timestamp"`
-		Contributor   string `json:"contributor"`
-		ContributorID uint64 `json:"contributorid"`
-		Comment       string `json:"comment"`
-	} `json:"revinfo"`
-	Text  string   `json:"text"`
-	Geo   *geo     `json:"geo,omitempty"`
-	Files []string `json:"files,omitempty"`
-	Links []string `json:"links,omitempty"`
-}
-
-func doPage(db *couchbase.Bucket, p *wikiparse.Page) {
-	article := article{Text: p.Revisions[0].Text}
-	gl, err := wikiparse.ParseCoords(p.Revisions[0].Text)
-	if err == nil {
-		article.Geo = &geo{Type: "Feature"}
-		article.Geo.Geometry.Type = "Point"
-		article.Geo.Geometry.Coordinates = []float64{gl.Lon, gl.Lat}
+// A store is where documents are kept, by key.
+type store interface {
+	// Get gets a document, reporting whether it was there.
+	Get(key string, v interface{}) (bool, error)
+	Set(key string, v interface{}) error
+	Delete(key string) error
+	// Keys calls fn with every key.
+	Keys(fn func(key string) error) error
+}
+
+type bucketStore struct {
+	b *couchbase.Bucket
+}
+
+func (s bucketStore) Get(key string, v interface{}) (bool, error) {
+	err := s.b.Get(key, v)
+	if couchbase.IsKeyNoEntError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s bucketStore) Set(key string, v interface{}) error {
+	return s.b.Set(key, 0, v)
+}
+
+func (s bucketStore) Delete(key string) error {
+	err := s.b.Delete(key)
+	if couchbase.IsKeyNoEntError(err) {
+		return nil
+	}
+	return err
+}
+
+func (s bucketStore) Keys(fn func(key string) error) error {
+	const pageSize = 1000
+	params := map[string]interface{}{"limit": pageSize}
+	for {
+		res, err := s.b.View("", "_all_docs", params)
+		if err != nil {
+			return err
+		}
+		for _, row := range res.Rows {
+			if err := fn(row.ID); err != nil {
+				return err
+			}
+		}
+		if len(res.Rows) < pageSize {
+			return nil
+		}
+		params["startkey"] = res.Rows[len(res.Rows)-1].ID
+		params["skip"] = 1
+	}
+}
+
+// sink writes documents to couchbase keyed by page id.
+type sink struct {
+	db store
+}
+
+func (s *sink) Write(docs []*wikiparse.Document) error {
+	for _, d := range docs {
+		if err := s.db.Set(d.ID, d); err != nil {
+			return fmt.Errorf("setting %v: %w", d.ID, err)
+		}
+	}
+	return nil
+}
+
+// A header is enough of a stored document to tell what it is.  Pages
+// have a pageid.  Older versions stored pages by title, with only
+// their revinfo, text and what was found in it.
+type header struct {
+	PageID  *uint64         `json:"pageid"`
+	RevInfo json.RawMessage `json:"revinfo"`
+}
+
+func (h header) legacy() bool {
+	return h.PageID == nil && h.RevInfo != nil
+}
+
+func (s *sink) Stored(ids []uint64) (map[uint64]*wikiparse.Document, error) {
+	rv := map[uint64]*wikiparse.Document{}
+	for _, id := range ids {
+		d := &wikiparse.Document{}
+		found, err := s.db.Get(strconv.FormatUint(id, 10), d)
+		if err != nil {
+			return nil, err
+		}
+		// A title such as "1984" may still hold an old document.
+		if found && d.PageID == id {
+			rv[id] = d
+		}
+	}
+	return rv, nil
+}
+
+func (s *sink) Delete(ids []uint64) error {
+	for _, id := range ids {
+		if err := s.db.Delete(strconv.FormatUint(id, 10)); err != nil {
+			return err
+		}
 	}
-	article.RevInfo.ID = p.Revisions[0].ID
-	article.RevInfo.Timestamp = p.Revisions[0].Timestamp
-	article.RevInfo.Contributor = p.Revisions[0].Contributor.Username
-	article.RevInfo.ContributorID = p.Revisions[0].Contributor.ID
-	article.RevInfo.Comment = p.Revisions[0].Comment
-	article.Files = wikiparse.FindFiles(article.Text)
-	article.Links = wikiparse.FindLinks(article.Text)
-
-	err = db.Set(p.Title, 0, article)
+	return nil
+}
+
+// headers calls fn with the key and header of every document.  The
+// keys are all listed first, as the bucket may not allow reading
+// while listing.
+func (s *sink) headers(fn func(key string, h header) error) error {
+	var keys []string
+	err := s.db.Keys(func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error setting %v: %v", p.Title, err)
-		return
+		return err
 	}
+	for _, key := range keys {
+		var h header
+		found, err := s.db.Get(key, &h)
+		if err != nil {
+			return fmt.Errorf("getting %q: %w", key, err)
+		}
+		if found {
+			if err := fn(key, h); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func pageHandler(db *couchbase.Bucket, ch <-chan *wikiparse.Page) {
-	defer wg.Done()
-	for p := range ch {
-		doPage(db, p)
+// PageIDs lists the documents that are pages, by their pageid.
+func (s *sink) PageIDs(fn func(uint64) error) error {
+	return s.headers(func(key string, h header) error {
+		if h.PageID == nil || key != strconv.FormatUint(*h.PageID, 10) {
+			return nil
+		}
+		return fn(*h.PageID)
+	})
+}
+
+// migrate deletes the documents older versions stored by title.
+func (s *sink) migrate() (int, error) {
+	var legacy []string
+	err := s.headers(func(key string, h header) error {
+		if h.legacy() {
+			legacy = append(legacy, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
+	for i, key := range legacy {
+		if err := s.db.Delete(key); err != nil {
+			return i, fmt.Errorf("deleting %q: %w", key, err)
+		}
+	}
+	return len(legacy), nil
 }
 
+func (s *sink) Flush() error { return nil }
+
+func (s *sink) Close() error { return nil }
+
 func main() {
 	couchbaseServer := flag.String("couchbase", "http://localhost:8091/",
 		"Couchbase URL")
@@ -105,36 +221,23 @@ func main() {
 		log.Fatalf("Error initializing multistream parser: %v", err)
 	}
 
-	ch := make(chan *wikiparse.Page, 1000)
-
-	for i := 0; i < *numWorkers; i++ {
-		wg.Add(1)
-		go pageHandler(db, ch)
-	}
-
-	pages := int64(0)
-	start := time.Now()
-	prev := start
-	reportfreq := int64(1000)
-	for err == nil {
-		var page *wikiparse.Page
-		page, err = p.Next()
-		if err == nil {
-			ch <- page
-		}
-
-		pages++
-		if pages%reportfreq == 0 {
-			now := time.Now()
-			d := now.Sub(prev)
-			log.Printf("Processed %s pages total (%.2f/s)",
-				humanize.Comma(pages), float64(reportfreq)/d.Seconds())
-			prev = now
+	s := &sink{bucketStore{db}}
+	if *migrate {
+		n, err := s.migrate()
+		if err != nil {
+			log.Fatalf("Error deleting documents stored by title: %v", err)
 		}
+		log.Printf("Deleted %d documents stored by title", n)
 	}
-	close(ch)
-	wg.Wait()
-	log.Printf("Ended with err after %v:  %v after %s pages",
-		time.Now().Sub(start), err, humanize.Comma(pages))
 
+	st, err := wikiparse.Load(p, s, wikiparse.LoadOptions{
+		Workers:         *numWorkers,
+		Retry:           wikiparse.RetryPolicy{Attempts: 3, Backoff: time.Second},
+		ContinueOnError: true,
+		Upsert:          true,
+		DeleteMissing:   *deleteMissing,
+		Progress:        wikiparse.LogProgress(log.Printf),
+		Logf:            log.Printf,
+	})
+	log.Printf("Ended with err after %v:  %v after %v", st.Elapsed, err, st)
 }
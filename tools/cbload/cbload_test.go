@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+type memStore struct {
+	mu   sync.Mutex
+	docs map[string][]byte
+	sets int
+	fail string
+}
+
+func newMemStore() *memStore {
+	return &memStore{docs: map[string][]byte{}}
+}
+
+func (m *memStore) Get(key string, v interface{}) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.docs[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(b, v)
+}
+
+func (m *memStore) Set(key string, v interface{}) error {
+	if key == m.fail {
+		return errors.New("out of memory")
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs[key] = b
+	m.sets++
+	return nil
+}
+
+func (m *memStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.docs, key)
+	return nil
+}
+
+func (m *memStore) Keys(fn func(string) error) error {
+	for k := range m.docs {
+		if err := fn(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func load(t *testing.T, m *memStore, dump string) wikiparse.LoadStats {
+	p, err := wikiparse.NewParser(strings.NewReader(dump))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	st, err := wikiparse.Load(p, &sink{m}, wikiparse.LoadOptions{Upsert: true, DeleteMissing: true})
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	return st
+}
+
+const dump = `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Halifax</title><id>1</id><revision><id>10</id><timestamp>2020-01-01T00:00:00Z</timestamp><text>[[Dartmouth]] {{coord|44.65|-63.57}}</text></revision></page>
+<page><title>Dartmouth</title><id>2</id><revision><id>20</id><text>Across the harbour.</text></revision></page>
+</mediawiki>`
+
+func TestSink(t *testing.T) {
+	m := newMemStore()
+	if st := load(t, m, dump); st.Written != 2 {
+		t.Fatalf("Expected 2 written, got %+v", st)
+	}
+	var doc struct {
+		RevInfo struct {
+			ID   uint64 `json:"id"`
+			SHA1 string `json:"sha1"`
+		} `json:"revinfo"`
+		Geo struct {
+			Geometry struct {
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"geo"`
+		Links []string `json:"links"`
+	}
+	if err := json.Unmarshal(m.docs["1"], &doc); err != nil {
+		t.Fatalf("Error decoding %s: %v", m.docs["1"], err)
+	}
+	if doc.RevInfo.ID != 10 || doc.RevInfo.SHA1 == "" ||
+		len(doc.Geo.Geometry.Coordinates) != 2 || doc.Links[0] != "Dartmouth" {
+		t.Errorf("Unexpected document: %s", m.docs["1"])
+	}
+}
+
+func TestSinkUpsert(t *testing.T) {
+	m := newMemStore()
+	load(t, m, dump)
+	st := load(t, m, `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Halifax</title><id>1</id><revision><id>10</id><timestamp>2020-01-01T00:00:00Z</timestamp><text>[[Dartmouth]] {{coord|44.65|-63.57}}</text></revision></page>
+</mediawiki>`)
+	if st.Written != 0 || st.Unchanged != 1 || st.Deleted != 1 || m.sets != 2 {
+		t.Errorf("Unexpected stats %+v after %v sets", st, m.sets)
+	}
+	if len(m.docs) != 1 || m.docs["2"] != nil {
+		t.Errorf("Expected Dartmouth deleted, got %v", m.docs)
+	}
+}
+
+func TestSinkMigrate(t *testing.T) {
+	m := newMemStore()
+	m.docs["Halifax"] = []byte(`{"revinfo":{"id":5},"text":"City"}`)
+	m.docs["1984"] = []byte(`{"revinfo":{"id":99},"text":"The year"}`)
+	m.docs["2001"] = []byte(`{"revinfo":{"id":98},"text":"Another year"}`)
+	m.docs["settings"] = []byte(`{"owner":"someone else"}`)
+
+	// Page 1984 isn't stale for the year article stored under its
+	// key, which it replaces, and the other old documents aren't
+	// pages to delete as missing.
+	st := load(t, m, `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Nineteen Eighty-Four</title><id>1984</id><revision><id>10</id><text>A novel.</text></revision></page>
+</mediawiki>`)
+	if st.Written != 1 || st.Stale != 0 || st.Deleted != 0 || len(m.docs) != 4 {
+		t.Errorf("Unexpected stats %+v with %v", st, m.docs)
+	}
+
+	n, err := (&sink{m}).migrate()
+	if err != nil || n != 2 {
+		t.Errorf("Expected 2 documents deleted, got %v (%v)", n, err)
+	}
+	if len(m.docs) != 2 || m.docs["1984"] == nil || m.docs["settings"] == nil {
+		t.Errorf("Expected page 1984 and settings kept, got %v", m.docs)
+	}
+}
+
+func TestSinkError(t *testing.T) {
+	m := newMemStore()
+	m.fail = "2"
+	err := (&sink{m}).Write([]*wikiparse.Document{{ID: "1"}, {ID: "2"}})
+	if err == nil || !strings.Contains(err.Error(), "setting 2") {
+		t.Errorf("Expected an error setting 2, got %v", err)
+	}
+}
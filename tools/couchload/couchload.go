@@ -2,109 +2,189 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"runtime"
-	"strings"
-	"sync"
+	"strconv"
 	"time"
 
 	"github.com/dustin/go-couch"
-	"github.com/dustin/go-humanize"
 	"github.com/dustin/go-wikiparse"
 	"github.com/dustin/httputil"
 )
 
-var wg sync.WaitGroup
-
-type geo struct {
-	Geometry struct {
-		Type        string    `json:"type"`
-		Coordinates []float64 `json:"coordinates"`
-	} `json:"geometry"`
-	Type string `json:"type"`
+// A store is the part of a couch database the sink uses.
+type store interface {
+	Insert(d interface{}) (string, string, error)
+	Retrieve(id string, d interface{}) error
+	EditWith(d interface{}, id, rev string) (string, error)
+	Delete(id, rev string) error
+	Query(view string, options map[string]interface{}, results interface{}) error
 }
 
 type article struct {
-	ID      string `json:"_id"`
-	Rev     string `json:"_rev"`
-	RevInfo struct {
-		ID            uint64 `json:"id"`
-		Timestamp     string `json:"timestamp"`
-		Contributor   string `json:"contributor"`
-		ContributorID uint64 `json:"contributorid"`
-		Comment       string `json:"comment"`
-	} `json:"revinfo"`
-	Text  string   `json:"text"`
-	Geo   *geo     `json:"geo,omitempty"`
-	Files []string `json:"files,omitempty"`
-	Links []string `json:"links,omitempty"`
+	ID  string `json:"_id"`
+	Rev string `json:"_rev,omitempty"`
+	*wikiparse.Document
 }
 
-func escapeTitle(in string) string {
-	return strings.Replace(strings.Replace(in, "/", "%2f", -1),
-		"+", "%2b", -1)
+// sink writes documents to couchdb keyed by page id, replacing
+// what's there.
+type sink struct {
+	db store
 }
 
-func resolveConflict(db *couch.Database, a *article) {
-	log.Printf("Resolving conflict on %s", a.ID)
+// rev gets the current revision of a document, or "" if there isn't
+// one.  The store doesn't escape ids, and older versions' ids are
+// titles, which may hold anything.
+func (s *sink) rev(id string) (string, error) {
 	var prev article
-	err := db.Retrieve(escapeTitle(a.ID), &prev)
-	if err != nil {
-		log.Printf("  Error retrieving existing %v: %v", a.ID, err)
-		return
-	}
-	if prev.Rev == "" {
-		log.Printf("Got no rev from %v", a.ID)
-		return
+	err := s.db.Retrieve(url.PathEscape(id), &prev)
+	if httputil.IsHTTPStatus(err, 404) {
+		return "", nil
 	}
-	if a.RevInfo.Timestamp > prev.RevInfo.Timestamp {
-		log.Printf("  This one is newer...replacing %s.", prev.Rev)
-		_, err = db.EditWith(a, a.ID, prev.Rev)
+	return prev.Rev, err
+}
+
+func (s *sink) Write(docs []*wikiparse.Document) error {
+	for _, d := range docs {
+		a := &article{ID: d.ID, Document: d}
+		_, _, err := s.db.Insert(a)
+		if httputil.IsHTTPStatus(err, 409) {
+			var rev string
+			if rev, err = s.rev(a.ID); err == nil {
+				_, err = s.db.EditWith(a, a.ID, rev)
+			}
+		}
 		if err != nil {
-			log.Printf("  Error updating %v: %v", prev.ID, err)
+			return fmt.Errorf("storing %v: %w", a.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *sink) Stored(ids []uint64) (map[uint64]*wikiparse.Document, error) {
+	rv := map[uint64]*wikiparse.Document{}
+	for _, id := range ids {
+		var a article
+		err := s.db.Retrieve(strconv.FormatUint(id, 10), &a)
+		switch {
+		case httputil.IsHTTPStatus(err, 404):
+		case err != nil:
+			return nil, err
+		// A title such as "1984" may still hold an old document.
+		case a.Document != nil && a.PageID == id:
+			rv[id] = a.Document
 		}
 	}
+	return rv, nil
 }
 
-func doPage(db *couch.Database, p *wikiparse.Page) {
-	defer wg.Done()
-	a := article{}
-	gl, err := wikiparse.ParseCoords(p.Revisions[0].Text)
-	if err == nil {
-		a.Geo = &geo{Type: "Feature"}
-		a.Geo.Geometry.Type = "Point"
-		a.Geo.Geometry.Coordinates = []float64{gl.Lon, gl.Lat}
+func (s *sink) Delete(ids []uint64) error {
+	for _, id := range ids {
+		if err := s.delete(strconv.FormatUint(id, 10)); err != nil {
+			return err
+		}
 	}
-	a.RevInfo.ID = p.Revisions[0].ID
-	a.RevInfo.Timestamp = p.Revisions[0].Timestamp
-	a.RevInfo.Contributor = p.Revisions[0].Contributor.Username
-	a.RevInfo.ContributorID = p.Revisions[0].Contributor.ID
-	a.RevInfo.Comment = p.Revisions[0].Comment
-	a.Text = p.Revisions[0].Text
-	a.ID = escapeTitle(p.Title)
-	a.Files = wikiparse.FindFiles(a.Text)
-	a.Links = wikiparse.FindLinks(a.Text)
-
-	_, _, err = db.Insert(&a)
-	switch {
-	case err == nil:
-		// yay
-	case httputil.IsHTTPStatus(err, 409):
-		resolveConflict(db, &a)
-	default:
-		log.Printf("Error inserting %#v: %v", a, err)
+	return nil
+}
+
+func (s *sink) delete(key string) error {
+	rev, err := s.rev(key)
+	if err == nil && rev != "" {
+		err = s.db.Delete(url.PathEscape(key), rev)
 	}
+	if err != nil && !httputil.IsHTTPStatus(err, 404) {
+		return fmt.Errorf("deleting %v: %w", key, err)
+	}
+	return nil
+}
+
+// A header is enough of a stored document to tell what it is.  Pages
+// have a pageid.  Older versions stored pages by title, with only
+// their revinfo, text and what was found in it.
+type header struct {
+	PageID  *uint64         `json:"pageid"`
+	RevInfo json.RawMessage `json:"revinfo"`
 }
 
-func pageHandler(db couch.Database, ch <-chan *wikiparse.Page) {
-	for p := range ch {
-		doPage(&db, p)
+// headers calls fn with the id and header of every document.
+func (s *sink) headers(fn func(id string, h header) error) error {
+	const pageSize = 1000
+	params := map[string]interface{}{"limit": pageSize, "include_docs": true}
+	for {
+		var res struct {
+			Rows []struct {
+				ID  string `json:"id"`
+				Doc header `json:"doc"`
+			} `json:"rows"`
+		}
+		if err := s.db.Query("_all_docs", params, &res); err != nil {
+			return err
+		}
+		for _, row := range res.Rows {
+			if err := fn(row.ID, row.Doc); err != nil {
+				return err
+			}
+		}
+		if len(res.Rows) < pageSize {
+			return nil
+		}
+		params["startkey"] = res.Rows[len(res.Rows)-1].ID
+		params["skip"] = 1
 	}
 }
 
+// PageIDs lists the documents that are pages, by their pageid.
+func (s *sink) PageIDs(fn func(uint64) error) error {
+	return s.headers(func(id string, h header) error {
+		if h.PageID == nil || id != strconv.FormatUint(*h.PageID, 10) {
+			return nil
+		}
+		return fn(*h.PageID)
+	})
+}
+
+// migrate deletes the documents older versions stored by title.
+// They're all listed first, as deleting while listing would move the
+// pages.
+func (s *sink) migrate() (int, error) {
+	var legacy []string
+	err := s.headers(func(id string, h header) error {
+		if h.PageID == nil && h.RevInfo != nil {
+			legacy = append(legacy, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range legacy {
+		if err := s.delete(id); err != nil {
+			return i, err
+		}
+	}
+	return len(legacy), nil
+}
+
+func (s *sink) Flush() error { return nil }
+
+func (s *sink) Close() error { return nil }
+
 func main() {
-	dburl, idx, file := os.Args[1], os.Args[2], os.Args[3]
+	deleteMissing := flag.Bool("delete", false, "Delete pages that aren't in the dump")
+	migrate := flag.Bool("migrate", false,
+		"Delete the documents older versions stored by title before loading")
+	flag.Parse()
+	if flag.NArg() != 3 {
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s [-delete] [-migrate] dburl index dump\n", os.Args[0])
+		os.Exit(1)
+	}
+	dburl, idx, file := flag.Arg(0), flag.Arg(1), flag.Arg(2)
 
 	db, err := couch.Connect(dburl)
 	if err != nil {
@@ -118,36 +198,23 @@ func main() {
 
 	log.Printf("Got site info:  %+v", p.SiteInfo())
 
-	ch := make(chan *wikiparse.Page, 1000)
-
-	for i := 0; i < 20; i++ {
-		go pageHandler(db, ch)
-	}
-
-	pages := int64(0)
-	start := time.Now()
-	prev := start
-	reportfreq := int64(1000)
-	for err == nil {
-		var page *wikiparse.Page
-		page, err = p.Next()
-		if err == nil {
-			wg.Add(1)
-			ch <- page
-		}
-
-		pages++
-		if pages%reportfreq == 0 {
-			now := time.Now()
-			d := now.Sub(prev)
-			log.Printf("Processed %s pages total (%.2f/s)",
-				humanize.Comma(pages), float64(reportfreq)/d.Seconds())
-			prev = now
+	s := &sink{db}
+	if *migrate {
+		n, err := s.migrate()
+		if err != nil {
+			log.Fatalf("Error deleting documents stored by title: %v", err)
 		}
+		log.Printf("Deleted %d documents stored by title", n)
 	}
-	wg.Wait()
-	close(ch)
-	log.Printf("Ended with err after %v:  %v after %s pages",
-		time.Now().Sub(start), err, humanize.Comma(pages))
 
+	st, err := wikiparse.Load(p, s, wikiparse.LoadOptions{
+		Workers:         20,
+		Retry:           wikiparse.RetryPolicy{Attempts: 3, Backoff: time.Second},
+		ContinueOnError: true,
+		Upsert:          true,
+		DeleteMissing:   *deleteMissing,
+		Progress:        wikiparse.LogProgress(log.Printf),
+		Logf:            log.Printf,
+	})
+	log.Printf("Ended with err after %v:  %v after %v", st.Elapsed, err, st)
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dustin/go-wikiparse"
+	"github.com/dustin/httputil"
+)
+
+// memDB is an in-memory couch database.
+type memDB struct {
+	mu   sync.Mutex
+	docs map[string]json.RawMessage
+	revs map[string]int
+}
+
+func newMemDB() *memDB {
+	return &memDB{docs: map[string]json.RawMessage{}, revs: map[string]int{}}
+}
+
+func httpError(code int) error {
+	return httputil.HTTPErrorf(&http.Response{
+		StatusCode: code, Body: io.NopCloser(strings.NewReader("")),
+	}, "HTTP Error %S")
+}
+
+func (m *memDB) put(d interface{}, id string) (string, error) {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	m.revs[id]++
+	rev := strconv.Itoa(m.revs[id])
+	var doc map[string]interface{}
+	json.Unmarshal(b, &doc)
+	doc["_rev"] = rev
+	m.docs[id], _ = json.Marshal(doc)
+	return rev, nil
+}
+
+func (m *memDB) Insert(d interface{}) (string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := d.(*article).ID
+	if _, ok := m.docs[id]; ok {
+		return "", "", httpError(409)
+	}
+	rev, err := m.put(d, id)
+	return id, rev, err
+}
+
+// unescape gets the id from its place in a URL path, as couchdb would.
+func unescape(id string) (string, error) {
+	id, err := url.PathUnescape(id)
+	if err != nil {
+		return "", httpError(400)
+	}
+	return id, nil
+}
+
+func (m *memDB) Retrieve(id string, d interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, err := unescape(id)
+	if err != nil {
+		return err
+	}
+	b, ok := m.docs[id]
+	if !ok {
+		return httpError(404)
+	}
+	return json.Unmarshal(b, d)
+}
+
+func (m *memDB) EditWith(d interface{}, id, rev string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if strconv.Itoa(m.revs[id]) != rev {
+		return "", httpError(409)
+	}
+	return m.put(d, id)
+}
+
+func (m *memDB) Delete(id, rev string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, err := unescape(id)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.docs[id]; !ok {
+		return httpError(404)
+	}
+	if strconv.Itoa(m.revs[id]) != rev {
+		return httpError(409)
+	}
+	delete(m.docs, id)
+	return nil
+}
+
+// Query supports _all_docs, with limit, startkey, skip and
+// include_docs.
+func (m *memDB) Query(view string, options map[string]interface{}, results interface{}) error {
+	if view != "_all_docs" {
+		return httpError(404)
+	}
+	var ids []string
+	for id := range m.docs {
+		start, _ := options["startkey"].(string)
+		if id >= start {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if skip, ok := options["skip"].(int); ok {
+		ids = ids[skip:]
+	}
+	if limit := options["limit"].(int); len(ids) > limit {
+		ids = ids[:limit]
+	}
+	var res struct {
+		Rows []map[string]interface{} `json:"rows"`
+	}
+	for _, id := range ids {
+		row := map[string]interface{}{"id": id}
+		if options["include_docs"] == true {
+			row["doc"] = m.docs[id]
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	b, _ := json.Marshal(res)
+	return json.Unmarshal(b, results)
+}
+
+func (m *memDB) revInfo(t *testing.T, id string) wikiparse.RevInfo {
+	var a article
+	if err := m.Retrieve(id, &a); err != nil {
+		t.Fatalf("Error retrieving %v: %v", id, err)
+	}
+	return a.RevInfo
+}
+
+func load(t *testing.T, db *memDB, dump string) wikiparse.LoadStats {
+	p, err := wikiparse.NewParser(strings.NewReader(dump))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	st, err := wikiparse.Load(p, &sink{db}, wikiparse.LoadOptions{Upsert: true, DeleteMissing: true})
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	return st
+}
+
+func TestSink(t *testing.T) {
+	db := newMemDB()
+	db.docs["_design/wiki"] = json.RawMessage(`{}`)
+	load(t, db, `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>AC/DC</title><id>1</id><revision><id>10</id><timestamp>2020-01-01T00:00:00Z</timestamp><text>Rock</text></revision></page>
+<page><title>Halifax</title><id>2</id><revision><id>20</id><timestamp>2020-01-01T00:00:00Z</timestamp><text>City</text></revision></page>
+<page><title>Bedford</title><id>3</id><revision><id>30</id><timestamp>2020-01-01T00:00:00Z</timestamp><text>Town</text></revision></page>
+</mediawiki>`)
+	st := load(t, db, `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>AC/DC</title><id>1</id><revision><id>11</id><timestamp>2021-01-01T00:00:00Z</timestamp><text>Rock band</text></revision></page>
+<page><title>Halifax</title><id>2</id><revision><id>19</id><timestamp>2019-01-01T00:00:00Z</timestamp><text>Town</text></revision></page>
+</mediawiki>`)
+
+	if st.Written != 1 || st.Stale != 1 || st.Deleted != 1 {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+	if len(db.docs) != 3 || db.docs["3"] != nil {
+		t.Errorf("Expected Bedford deleted, got %v", db.docs)
+	}
+	if r := db.revInfo(t, "1"); r.ID != 11 {
+		t.Errorf("Expected the newer revision 11, got %+v", r)
+	}
+	if r := db.revInfo(t, "2"); r.ID != 20 {
+		t.Errorf("Expected to keep revision 20, got %+v", r)
+	}
+}
+
+func TestSinkPageIDs(t *testing.T) {
+	db := newMemDB()
+	for i := 1; i <= 2500; i++ {
+		db.docs[strconv.Itoa(i)] = json.RawMessage(`{"pageid":` + strconv.Itoa(i) + `}`)
+	}
+	db.docs["_design/wiki"] = json.RawMessage(`{}`)
+	n := 0
+	if err := (&sink{db}).PageIDs(func(uint64) error { n++; return nil }); err != nil || n != 2500 {
+		t.Errorf("Expected 2500 page ids, got %v (%v)", n, err)
+	}
+}
+
+func TestSinkMigrate(t *testing.T) {
+	db := newMemDB()
+	db.docs["_design/wiki"] = json.RawMessage(`{}`)
+	db.docs["settings"] = json.RawMessage(`{"theme":"dark"}`)
+	for _, id := range []string{"AC%2fDC", "100% Pure?", "1984", "2001"} {
+		db.put(map[string]interface{}{"revinfo": map[string]interface{}{"id": 1}, "text": "Old"}, id)
+	}
+
+	// Page 1984 replaces the year article stored under its key, and
+	// the other old documents aren't pages to delete as missing.
+	st := load(t, db, `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Nineteen Eighty-Four</title><id>1984</id><revision><id>10</id><text>A novel.</text></revision></page>
+</mediawiki>`)
+	if st.Written != 1 || st.Stale != 0 || st.Deleted != 0 || len(db.docs) != 6 {
+		t.Errorf("Unexpected stats %+v with %v", st, db.docs)
+	}
+
+	n, err := (&sink{db}).migrate()
+	if err != nil || n != 3 {
+		t.Errorf("Expected 3 documents deleted, got %v (%v)", n, err)
+	}
+	if len(db.docs) != 3 || db.docs["1984"] == nil || db.docs["settings"] == nil {
+		t.Errorf("Expected page 1984, settings and the design doc kept, got %v", db.docs)
+	}
+}
@@ -2,47 +2,249 @@
 package main
 
 import (
+	"bytes"
 	"compress/bzip2"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/dustin/go-elasticsearch"
-	"github.com/dustin/go-humanize"
 	"github.com/dustin/go-wikiparse"
 )
 
-var wg = sync.WaitGroup{}
+const (
+	indexName = "wikipediax"
+	docType   = "article"
+)
+
+// A source is a stored document's body.  Older versions stored pages
+// by title with only their author, text and timestamp, so those have
+// no pageid or title.
+type source struct {
+	Title     string `json:"title"`
+	PageID    uint64 `json:"pageid"`
+	RevID     uint64 `json:"revid"`
+	SHA1      string `json:"sha1"`
+	Author    string `json:"author"`
+	Timestamp string `json:"timestamp"`
+}
+
+// An index reads what's in elasticsearch.  Writes go through the
+// bulk API.
+type index interface {
+	// Get gets the documents with the given ids that exist.
+	Get(ids []string) (map[string]source, error)
+	// Headers calls fn with the id of every document and its
+	// pageid, title and timestamp.
+	Headers(fn func(id string, src source) error) error
+}
 
-func pageHandler(u string, ch chan *wikiparse.Page) {
-	counter := 0
-	es := elasticsearch.ElasticSearch{URL: u}
-	bulkLoader := es.Bulk()
+// httpIndex is an index read over the REST API.
+type httpIndex struct {
+	url string
+}
 
-	for p := range ch {
-		counter++
-		if counter > 1000 {
-			bulkLoader.SendBatch()
-			counter = 0
+func (x httpIndex) post(path string, body, res interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(x.url+path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New("HTTP error:  " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+func (x httpIndex) Get(ids []string) (map[string]source, error) {
+	var res struct {
+		Docs []struct {
+			ID     string `json:"_id"`
+			Found  bool   `json:"found"`
+			Source source `json:"_source"`
+		} `json:"docs"`
+	}
+	err := x.post("/"+indexName+"/"+docType+"/_mget", map[string]interface{}{"ids": ids}, &res)
+	if err != nil {
+		return nil, err
+	}
+	rv := map[string]source{}
+	for _, d := range res.Docs {
+		if d.Found {
+			rv[d.ID] = d.Source
 		}
-		ui := elasticsearch.UpdateInstruction{
-			Id:    p.Title,
-			Index: "wikipediax",
-			Type:  "article",
+	}
+	return rv, nil
+}
+
+func (x httpIndex) Headers(fn func(string, source) error) error {
+	type page struct {
+		ScrollID string `json:"_scroll_id"`
+		Hits     struct {
+			Hits []struct {
+				ID     string `json:"_id"`
+				Source source `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	var res page
+	err := x.post("/"+indexName+"/"+docType+"/_search?scroll=1m",
+		map[string]interface{}{"size": 1000, "_source": []string{"pageid", "title", "timestamp"}}, &res)
+	for err == nil && len(res.Hits.Hits) > 0 {
+		for _, h := range res.Hits.Hits {
+			if err := fn(h.ID, h.Source); err != nil {
+				return err
+			}
+		}
+		scrollID := res.ScrollID
+		res = page{}
+		err = x.post("/_search/scroll",
+			map[string]interface{}{"scroll": "1m", "scroll_id": scrollID}, &res)
+	}
+	return err
+}
+
+// sink writes documents to elasticsearch with the bulk API keyed by
+// page id, one request per batch.
+type sink struct {
+	// The bulk updater batches everything it's given, so batches
+	// are written one at a time.
+	mu    sync.Mutex
+	bulk  elasticsearch.BulkUpdater
+	index index
+}
+
+func (s *sink) Write(docs []*wikiparse.Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range docs {
+		s.bulk.Update(&elasticsearch.UpdateInstruction{
+			Id:    d.ID,
+			Index: indexName,
+			Type:  docType,
 			Body: map[string]interface{}{
-				"author":    p.Revisions[0].Contributor.Username,
-				"text":      p.Revisions[0].Text,
-				"timestamp": p.Revisions[0].Timestamp,
+				"title":     d.Title,
+				"pageid":    d.PageID,
+				"revid":     d.RevInfo.ID,
+				"sha1":      d.RevInfo.SHA1,
+				"author":    d.RevInfo.Contributor,
+				"text":      d.Text,
+				"timestamp": d.RevInfo.Timestamp,
 			},
+		})
+	}
+	return s.bulk.SendBatch()
+}
+
+func (s *sink) Stored(ids []uint64) (map[uint64]*wikiparse.Document, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = strconv.FormatUint(id, 10)
+	}
+	found, err := s.index.Get(keys)
+	if err != nil {
+		return nil, err
+	}
+	rv := map[uint64]*wikiparse.Document{}
+	for i, id := range ids {
+		// A title such as "1984" may still hold an old document.
+		if src, ok := found[keys[i]]; ok && src.PageID == id {
+			rv[id] = &wikiparse.Document{
+				ID:     keys[i],
+				Title:  src.Title,
+				PageID: id,
+				RevInfo: wikiparse.RevInfo{
+					ID:          src.RevID,
+					SHA1:        src.SHA1,
+					Contributor: src.Author,
+					Timestamp:   src.Timestamp,
+				},
+			}
 		}
-		bulkLoader.Update(&ui)
 	}
-	bulkLoader.Quit()
+	return rv, nil
+}
+
+func (s *sink) Delete(ids []uint64) error {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = strconv.FormatUint(id, 10)
+	}
+	return s.delete(keys)
+}
+
+func (s *sink) delete(keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		s.bulk.Update(&elasticsearch.DeleteInstruction{
+			Id:    key,
+			Index: indexName,
+			Type:  docType,
+		})
+	}
+	return s.bulk.SendBatch()
+}
+
+// PageIDs lists the documents that are pages, by their pageid.
+func (s *sink) PageIDs(fn func(uint64) error) error {
+	return s.index.Headers(func(key string, src source) error {
+		if src.PageID == 0 || key != strconv.FormatUint(src.PageID, 10) {
+			return nil
+		}
+		return fn(src.PageID)
+	})
+}
+
+// migrate deletes the documents older versions stored by title.
+func (s *sink) migrate() (int, error) {
+	var legacy []string
+	err := s.index.Headers(func(key string, src source) error {
+		if src.PageID == 0 && src.Title == "" && src.Timestamp != "" {
+			legacy = append(legacy, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	const batchSize = 1000
+	for i := 0; i < len(legacy); i += batchSize {
+		if err := s.delete(legacy[i:min(i+batchSize, len(legacy))]); err != nil {
+			return i, err
+		}
+	}
+	return len(legacy), nil
+}
+
+func (s *sink) Flush() error { return nil }
+
+func (s *sink) Close() error {
+	s.bulk.Quit()
+	return nil
 }
 
 func main() {
-	filename, esurl := os.Args[1], os.Args[2]
+	deleteMissing := flag.Bool("delete", false, "Delete pages that aren't in the dump")
+	migrate := flag.Bool("migrate", false,
+		"Delete the documents older versions stored by title before loading")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s [-delete] [-migrate] dump.xml.bz2 esurl\n", os.Args[0])
+		os.Exit(1)
+	}
+	filename, esurl := flag.Arg(0), flag.Arg(1)
 
 	f, err := os.Open(filename)
 	if err != nil {
@@ -59,36 +261,25 @@ func main() {
 
 	log.Printf("Got site info:  %+v", p.SiteInfo())
 
-	ch := make(chan *wikiparse.Page, 1000)
-
-	for i := 0; i < 4; i++ {
-		go pageHandler(esurl, ch)
-	}
-
-	pages := int64(0)
-	start := time.Now()
-	prev := start
-	reportfreq := int64(1000)
-	for err == nil {
-		var page *wikiparse.Page
-		page, err = p.Next()
-		if err == nil {
-			wg.Add(1)
-			ch <- page
-		}
-
-		pages++
-		if pages%reportfreq == 0 {
-			now := time.Now()
-			d := now.Sub(prev)
-			log.Printf("Processed %s pages total (%.2f/s)",
-				humanize.Comma(pages), float64(reportfreq)/d.Seconds())
-			prev = now
+	es := elasticsearch.ElasticSearch{URL: esurl}
+	s := &sink{bulk: es.Bulk(), index: httpIndex{esurl}}
+	defer s.Close()
+	if *migrate {
+		n, err := s.migrate()
+		if err != nil {
+			log.Fatalf("Error deleting documents stored by title: %v", err)
 		}
+		log.Printf("Deleted %d documents stored by title", n)
 	}
-	wg.Wait()
-	close(ch)
-	log.Printf("Ended with err after %v:  %v after %s pages",
-		time.Now().Sub(start), err, humanize.Comma(pages))
-
+	st, err := wikiparse.Load(p, s, wikiparse.LoadOptions{
+		Workers:         4,
+		BatchSize:       1000,
+		Retry:           wikiparse.RetryPolicy{Attempts: 3, Backoff: time.Second},
+		ContinueOnError: true,
+		Upsert:          true,
+		DeleteMissing:   *deleteMissing,
+		Progress:        wikiparse.LogProgress(log.Printf),
+		Logf:            log.Printf,
+	})
+	log.Printf("Ended with err after %v:  %v after %v", st.Elapsed, err, st)
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dustin/go-elasticsearch"
+	"github.com/dustin/go-wikiparse"
+)
+
+// memBulk is an in-memory bulk updater and index.
+type memBulk struct {
+	pending []elasticsearch.Instruction
+	docs    map[string]map[string]interface{}
+	batches int
+	fail    int
+	quit    bool
+}
+
+func newMemBulk() *memBulk {
+	return &memBulk{docs: map[string]map[string]interface{}{}}
+}
+
+func (m *memBulk) Update(i elasticsearch.Instruction) {
+	m.pending = append(m.pending, i)
+}
+
+func (m *memBulk) SendBatch() error {
+	m.batches++
+	pending := m.pending
+	m.pending = nil
+	if m.fail > 0 {
+		m.fail--
+		return errors.New("HTTP error:  503 Service Unavailable")
+	}
+	for _, i := range pending {
+		switch i := i.(type) {
+		case *elasticsearch.UpdateInstruction:
+			m.docs[i.Id] = i.Body
+		case *elasticsearch.DeleteInstruction:
+			delete(m.docs, i.Id)
+		}
+	}
+	return nil
+}
+
+func (m *memBulk) Quit() { m.quit = true }
+
+func (m *memBulk) Get(ids []string) (map[string]source, error) {
+	rv := map[string]source{}
+	for _, id := range ids {
+		if body, ok := m.docs[id]; ok {
+			var src source
+			b, _ := json.Marshal(body)
+			json.Unmarshal(b, &src)
+			rv[id] = src
+		}
+	}
+	return rv, nil
+}
+
+func (m *memBulk) Headers(fn func(string, source) error) error {
+	got, _ := m.Get(m.ids())
+	for id, src := range got {
+		if err := fn(id, src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memBulk) ids() []string {
+	var rv []string
+	for id := range m.docs {
+		rv = append(rv, id)
+	}
+	return rv
+}
+
+func load(t *testing.T, s *sink, dump string, opts wikiparse.LoadOptions) wikiparse.LoadStats {
+	p, err := wikiparse.NewParser(strings.NewReader(dump))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	st, err := wikiparse.Load(p, s, opts)
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	return st
+}
+
+const dump = `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Halifax</title><id>1</id><revision><id>10</id><timestamp>2020-01-01T00:00:00Z</timestamp><contributor><username>Ed</username></contributor><text>City</text></revision></page>
+<page><title>Dartmouth</title><id>2</id><revision><id>20</id><text>Town</text></revision></page>
+<page><title>Bedford</title><id>3</id><revision><id>30</id><text>Town</text></revision></page>
+</mediawiki>`
+
+func TestSink(t *testing.T) {
+	m := newMemBulk()
+	m.fail = 1
+	s := &sink{bulk: m, index: m}
+	st := load(t, s, dump, wikiparse.LoadOptions{
+		Workers:   2,
+		BatchSize: 2,
+		Retry:     wikiparse.RetryPolicy{Attempts: 2},
+	})
+	if st.Written != 3 {
+		t.Fatalf("Expected 3 written, got %+v", st)
+	}
+	s.Close()
+
+	if m.batches != 3 || !m.quit {
+		t.Errorf("Expected 3 batches and quit, got %v, %v", m.batches, m.quit)
+	}
+	if len(m.docs) != 3 {
+		t.Errorf("Expected 3 documents, got %v", m.docs)
+	}
+	doc := m.docs["1"]
+	if doc["author"] != "Ed" || doc["text"] != "City" || doc["title"] != "Halifax" ||
+		doc["timestamp"] != "2020-01-01T00:00:00Z" {
+		t.Errorf("Unexpected document: %v", doc)
+	}
+}
+
+func TestSinkUpsert(t *testing.T) {
+	m := newMemBulk()
+	s := &sink{bulk: m, index: m}
+	opts := wikiparse.LoadOptions{Upsert: true, DeleteMissing: true}
+	load(t, s, dump, opts)
+	st := load(t, s, `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Halifax</title><id>1</id><revision><id>10</id><text>City</text></revision></page>
+<page><title>Dartmouth</title><id>2</id><revision><id>21</id><text>City</text></revision></page>
+</mediawiki>`, opts)
+	if st.Written != 1 || st.Unchanged != 1 || st.Deleted != 1 {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+	if len(m.docs) != 2 || m.docs["2"]["text"] != "City" {
+		t.Errorf("Unexpected documents: %v", m.docs)
+	}
+}
+
+func TestSinkMigrate(t *testing.T) {
+	m := newMemBulk()
+	s := &sink{bulk: m, index: m}
+	m.docs["Halifax"] = map[string]interface{}{"author": "Ed", "text": "City", "timestamp": "2010-01-01T00:00:00Z"}
+	m.docs["1984"] = map[string]interface{}{"author": "Ed", "text": "The year", "timestamp": "2010-01-01T00:00:00Z"}
+	m.docs["2001"] = map[string]interface{}{"author": "Ed", "text": "Another year", "timestamp": "2010-01-01T00:00:00Z"}
+	m.docs["notes"] = map[string]interface{}{"text": "Not ours"}
+
+	// Page 1984 replaces the year article stored under its key, and
+	// the other old documents aren't pages to delete as missing.
+	st := load(t, s, `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Nineteen Eighty-Four</title><id>1984</id><revision><id>10</id><text>A novel.</text></revision></page>
+</mediawiki>`, wikiparse.LoadOptions{Upsert: true, DeleteMissing: true})
+	if st.Written != 1 || st.Deleted != 0 || len(m.docs) != 4 {
+		t.Errorf("Unexpected stats %+v with %v", st, m.docs)
+	}
+
+	n, err := s.migrate()
+	if err != nil || n != 2 {
+		t.Errorf("Expected 2 documents deleted, got %v (%v)", n, err)
+	}
+	if len(m.docs) != 2 || m.docs["1984"]["pageid"] == nil || m.docs["notes"] == nil {
+		t.Errorf("Expected page 1984 and notes kept, got %v", m.docs)
+	}
+}
+
+func TestHTTPIndex(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/wikipediax/article/_mget":
+			w.Write([]byte(`{"docs":[{"_id":"1","found":true,"_source":{"title":"Halifax","revid":10}},
+				{"_id":"2","found":false}]}`))
+		case "/wikipediax/article/_search":
+			w.Write([]byte(`{"_scroll_id":"a","hits":{"hits":[{"_id":"1","_source":{"pageid":1}},{"_id":"2"}]}}`))
+		case "/_search/scroll":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["scroll_id"] == "a" {
+				w.Write([]byte(`{"_scroll_id":"b","hits":{"hits":[{"_id":"3"}]}}`))
+			} else {
+				w.Write([]byte(`{"_scroll_id":"b","hits":{"hits":[]}}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	x := httpIndex{ts.URL}
+
+	got, err := x.Get([]string{"1", "2"})
+	if err != nil || len(got) != 1 || got["1"].Title != "Halifax" || got["1"].RevID != 10 {
+		t.Errorf("Unexpected documents %+v (%v)", got, err)
+	}
+	var ids []string
+	err = x.Headers(func(id string, src source) error {
+		ids = append(ids, fmt.Sprintf("%v:%v", id, src.PageID))
+		return nil
+	})
+	if err != nil || strings.Join(ids, ",") != "1:1,2:0,3:0" {
+		t.Errorf("Expected ids 1:1,2:0,3:0, got %v (%v)", ids, err)
+	}
+}
@@ -0,0 +1,149 @@
+// Export the geotagged pages of a wikipedia dump as GeoJSON or KML.
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/dustin/go-wikiparse"
+)
+
+var (
+	format  = flag.String("format", "geojsonseq", "Output format: geojsonseq or kml")
+	outFile = flag.String("o", "-", "Output file (- for stdout)")
+	all     = flag.Bool("all", false, "Export every coordinate, not just each page's primary one")
+)
+
+func init() {
+	flag.Usage = usage
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"Usage:\n  %s [opts] wikipedia.xml[.bz2]\n  %s [opts] wikipedia.index.bz2 wikipedia.xml.bz2\n",
+		os.Args[0], os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+// primary gets the coordinate that best represents a page: the one
+// shown by the title, or else the first.
+func primary(coords []wikiparse.PageCoord) wikiparse.PageCoord {
+	for _, c := range coords {
+		if c.IsTitle() {
+			return c
+		}
+	}
+	return coords[0]
+}
+
+func openParser() (wikiparse.Parser, func() error) {
+	switch flag.NArg() {
+	case 1:
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Error opening file: %v", err)
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(flag.Arg(0), ".bz2") {
+			r = bzip2.NewReader(f)
+		}
+		p, err := wikiparse.NewParser(r)
+		if err != nil {
+			log.Fatalf("Error setting up new page parser:  %v", err)
+		}
+		return p, f.Close
+	case 2:
+		p, err := wikiparse.NewIndexedParser(flag.Arg(0), flag.Arg(1),
+			runtime.GOMAXPROCS(0))
+		if err != nil {
+			log.Fatalf("Error initializing multistream parser: %v", err)
+		}
+		return p, func() error { return nil }
+	}
+	usage()
+	return nil, nil
+}
+
+func main() {
+	flag.Parse()
+
+	p, closer := openParser()
+	defer closer()
+
+	var out io.Writer = os.Stdout
+	if *outFile != "-" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			log.Fatalf("Error creating output: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+
+	var e wikiparse.GeoEncoder
+	switch *format {
+	case "geojsonseq":
+		e = wikiparse.NewGeoJSONSeqEncoder(w)
+	case "kml":
+		e = wikiparse.NewKMLEncoder(w)
+	default:
+		log.Fatalf("Unknown format %q", *format)
+	}
+
+	si := p.SiteInfo()
+	pages, found := int64(0), int64(0)
+	start := time.Now()
+	var err error
+	for err == nil {
+		var page *wikiparse.Page
+		page, err = p.Next()
+		if err != nil || page.Ns != 0 || page.Redir.Title != "" || len(page.Revisions) == 0 {
+			continue
+		}
+		pages++
+
+		coords := wikiparse.FindLocations(page.Revisions[0].Text)
+		var earth []wikiparse.PageCoord
+		for _, c := range coords {
+			if c.Params.OnEarth() {
+				earth = append(earth, c)
+			}
+		}
+		if len(earth) == 0 {
+			continue
+		}
+		if !*all {
+			earth = []wikiparse.PageCoord{primary(earth)}
+		}
+		for _, c := range earth {
+			if err := e.Encode(wikiparse.NewGeoFeature(page, c, si)); err != nil {
+				log.Fatalf("Error writing %q: %v", page.Title, err)
+			}
+			found++
+		}
+	}
+	if err != io.EOF {
+		log.Printf("Stopped reading: %v", err)
+	}
+
+	if err := e.Close(); err != nil {
+		log.Fatalf("Error finishing output: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+	log.Printf("Exported %s coordinates from %s pages in %v",
+		humanize.Comma(found), humanize.Comma(pages), time.Since(start))
+}
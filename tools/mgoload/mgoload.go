@@ -3,39 +3,68 @@ package main
 import (
 	"compress/bzip2"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"runtime"
-	"sync"
 	"time"
 
-	"github.com/dustin/go-humanize"
 	"github.com/dustin/go-wikiparse"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 var proc = flag.Int("proc", 8, "How many processes to run.")
 var file = flag.String("file", "", "The bz2 dump file.")
 var cpus = flag.Int("cpus", runtime.NumCPU(), "Number of CPUs to use.")
 var dburl = flag.String("dburl", "localhost", "The dburl(s). I.e. localhost.")
-var verbose = flag.Bool("v", false, "Verbose logging?")
+var deleteMissing = flag.Bool("delete", false, "Delete pages that aren't in the dump.")
+var migrate = flag.Bool("migrate", false,
+	"Delete the articles older versions stored before loading.")
 var collection = flag.String("collection", "articles", "The collection to store dumped articles in.")
 var dbname = flag.String("dbname", "wp", "The database name to use.")
 
-var wg sync.WaitGroup
-
-// We want unique titles and htey should be since the title is the URL path
-// in wikimedia My Title => My_Title
+// Pages are stored by page id.  Titles change when pages are moved,
+// so the title index isn't unique.
 var titleIndex = mgo.Index{
 	Key:        []string{"title"},
-	Unique:     true,
-	DropDups:   true,
 	Background: true,
 	Sparse:     true,
 }
 
+// An indexer manages a collection's indexes.
+type indexer interface {
+	Indexes() ([]mgo.Index, error)
+	DropIndex(key ...string) error
+	EnsureIndex(index mgo.Index) error
+}
+
+// ensureTitleIndex creates the title index.  Older versions made it
+// unique, and an index can't be ensured over one on the same key with
+// different options, so that one's dropped first.
+func ensureTitleIndex(c indexer) error {
+	indexes, err := c.Indexes()
+	if qe, ok := err.(*mgo.QueryError); ok && qe.Code == 26 {
+		// There's no collection yet.
+		indexes, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, i := range indexes {
+		if reflect.DeepEqual(i.Key, titleIndex.Key) &&
+			(i.Unique != titleIndex.Unique || i.Sparse != titleIndex.Sparse) {
+			if err := c.DropIndex(titleIndex.Key...); err != nil {
+				return fmt.Errorf("dropping the old title index: %w", err)
+			}
+		}
+	}
+	return c.EnsureIndex(titleIndex)
+}
+
 type article struct {
-	ID      string `bson:"_id,omitempty"`
+	ID      uint64 `bson:"_id"`
 	Title   string `bson:",omitempty"`
 	Rev     string `bson:",omitempty"`
 	RevInfo struct {
@@ -44,76 +73,175 @@ type article struct {
 		Contributor   string `bson:",omitempty"`
 		ContributorID uint64 `bson:",omitempty"`
 		Comment       string `bson:",omitempty"`
+		SHA1          string `bson:",omitempty"`
 	}
 	Text  string   `bson:",omitempty"`
 	Files []string `bson:",omitempty"`
 	Links []string `bson:",omitempty"`
 }
 
-func pageHandler(db *mgo.Database, ch <-chan *wikiparse.Page) {
-	for p := range ch {
-		makeArticle(db, p)
+func newArticle(d *wikiparse.Document) *article {
+	a := &article{ID: d.PageID}
+	a.RevInfo.ID = d.RevInfo.ID
+	a.RevInfo.Timestamp = d.RevInfo.Timestamp
+	a.RevInfo.Contributor = d.RevInfo.Contributor
+	a.RevInfo.ContributorID = d.RevInfo.ContributorID
+	a.RevInfo.Comment = d.RevInfo.Comment
+	a.RevInfo.SHA1 = d.RevInfo.SHA1
+
+	a.Title = d.Title
+	a.Text = d.Text
+	a.Links = d.Links
+	a.Files = d.Files
+	return a
+}
+
+// A store is where articles are kept, by page id.
+type store interface {
+	// Upsert inserts or replaces an article.
+	Upsert(a *article) error
+	// Find gets the title and revision info of the articles with
+	// the given ids that exist.
+	Find(ids []uint64) ([]article, error)
+	Remove(id uint64) error
+	// IDs calls fn with the id of every article.
+	IDs(fn func(uint64) error) error
+	// RemoveLegacy removes the articles older versions stored,
+	// reporting how many there were.
+	RemoveLegacy() (int, error)
+}
+
+type collectionStore struct {
+	c *mgo.Collection
+}
+
+func (s collectionStore) Upsert(a *article) error {
+	_, err := s.c.UpsertId(a.ID, a)
+	return err
+}
+
+func (s collectionStore) Find(ids []uint64) ([]article, error) {
+	var rv []article
+	err := s.c.Find(bson.M{"_id": bson.M{"$in": ids}}).
+		Select(bson.M{"title": 1, "revinfo": 1}).All(&rv)
+	return rv, err
+}
+
+func (s collectionStore) Remove(id uint64) error {
+	err := s.c.RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return nil
 	}
+	return err
 }
 
-func makeArticle(db *mgo.Database, p *wikiparse.Page) {
-	a := article{}
-	a.RevInfo.ID = p.Revisions[0].ID
-	a.RevInfo.Timestamp = p.Revisions[0].Timestamp
-	a.RevInfo.Contributor = p.Revisions[0].Contributor.Username
-	a.RevInfo.ContributorID = p.Revisions[0].Contributor.ID
-	a.RevInfo.Comment = p.Revisions[0].Comment
+func (s collectionStore) IDs(fn func(uint64) error) error {
+	var doc struct {
+		ID interface{} `bson:"_id"`
+	}
+	it := s.c.Find(nil).Select(bson.M{"_id": 1}).Iter()
+	for it.Next(&doc) {
+		// Skip anything not keyed by page id.
+		if id, ok := doc.ID.(int64); ok {
+			if err := fn(uint64(id)); err != nil {
+				it.Close()
+				return err
+			}
+		}
+	}
+	return it.Close()
+}
 
-	a.Title = p.Title
-	a.Text = p.Revisions[0].Text
-	a.Links = wikiparse.FindLinks(a.Text)
-	a.Files = wikiparse.FindFiles(a.Text)
-	err := db.C(*collection).Insert(&a)
+// Older versions didn't set _id, so theirs are generated ObjectIds.
+func (s collectionStore) RemoveLegacy() (int, error) {
+	info, err := s.c.RemoveAll(bson.M{"_id": bson.M{"$type": "objectId"}})
 	if err != nil {
-		if mgo.IsDup(err) {
-			if *verbose == true {
-				log.Printf("Duplicate Key Error inserting %s", a.Title)
-			}
-		} else {
-			log.Printf("Error inserting %s: %s", a.Title, err)
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+// sink upserts documents into a collection by page id.
+type sink struct {
+	c store
+}
+
+func (s *sink) Write(docs []*wikiparse.Document) error {
+	for _, d := range docs {
+		if err := s.c.Upsert(newArticle(d)); err != nil {
+			return fmt.Errorf("upserting %s: %w", d.Title, err)
 		}
 	}
-	wg.Done()
+	return nil
 }
 
-func processDump(p wikiparse.Parser, db *mgo.Database) {
-	ch := make(chan *wikiparse.Page, 1000)
-	for i := 0; i < *proc; i++ {
-		go pageHandler(db, ch)
-	}
-
-	pages := int64(0)
-	start := time.Now()
-	prev := start
-	reportfreq := int64(10000)
-	var err error
-	for err == nil {
-		var page *wikiparse.Page
-		page, err = p.Next()
-		if err == nil {
-			wg.Add(1)
-			ch <- page
+func (s *sink) Stored(ids []uint64) (map[uint64]*wikiparse.Document, error) {
+	found, err := s.c.Find(ids)
+	if err != nil {
+		return nil, err
+	}
+	rv := map[uint64]*wikiparse.Document{}
+	for _, a := range found {
+		rv[a.ID] = &wikiparse.Document{
+			Title:  a.Title,
+			PageID: a.ID,
+			RevInfo: wikiparse.RevInfo{
+				ID:            a.RevInfo.ID,
+				Timestamp:     a.RevInfo.Timestamp,
+				Contributor:   a.RevInfo.Contributor,
+				ContributorID: a.RevInfo.ContributorID,
+				Comment:       a.RevInfo.Comment,
+				SHA1:          a.RevInfo.SHA1,
+			},
 		}
-		pages++
-		if pages%reportfreq == 0 {
-			now := time.Now()
-			d := now.Sub(prev)
-			log.Printf("Processed %s pages total (%.2f/s)\n",
-				humanize.Comma(pages), float64(reportfreq)/d.Seconds())
-			prev = now
+	}
+	return rv, nil
+}
+
+func (s *sink) Delete(ids []uint64) error {
+	for _, id := range ids {
+		if err := s.c.Remove(id); err != nil {
+			return fmt.Errorf("removing %v: %w", id, err)
 		}
 	}
-	wg.Wait()
-	close(ch)
+	return nil
+}
+
+// PageIDs lists the articles stored by page id.
+func (s *sink) PageIDs(fn func(uint64) error) error {
+	return s.c.IDs(fn)
+}
+
+// migrate deletes the articles older versions stored.
+func (s *sink) migrate() (int, error) {
+	return s.c.RemoveLegacy()
+}
+
+func (s *sink) Flush() error { return nil }
+
+func (s *sink) Close() error { return nil }
 
-	d := time.Since(start)
-	log.Printf("Ended with err after %v:  %v after %s pages (%.2f p/s)",
-		d, err, humanize.Comma(pages), float64(pages)/d.Seconds())
+func processDump(p wikiparse.Parser, db *mgo.Database) {
+	s := &sink{collectionStore{db.C(*collection)}}
+	if *migrate {
+		n, err := s.migrate()
+		if err != nil {
+			log.Fatalf("Error deleting articles stored by older versions: %v", err)
+		}
+		log.Printf("Deleted %d articles stored by older versions", n)
+	}
+	st, err := wikiparse.Load(p, s, wikiparse.LoadOptions{
+		Workers:         *proc,
+		Retry:           wikiparse.RetryPolicy{Attempts: 3, Backoff: time.Second},
+		ContinueOnError: true,
+		Upsert:          true,
+		DeleteMissing:   *deleteMissing,
+		Progress:        wikiparse.LogProgress(log.Printf),
+		ProgressEvery:   10000,
+		Logf:            log.Printf,
+	})
+	log.Printf("Ended with err after %v:  %v after %v (%.2f p/s)",
+		st.Elapsed, err, st, st.Rate())
 }
 
 func main() {
@@ -139,7 +267,7 @@ func main() {
 		log.Fatalf("Error setting up new page parser:  %v", err)
 	}
 
-	err = session.DB(*dbname).C(*collection).EnsureIndex(titleIndex)
+	err = ensureTitleIndex(session.DB(*dbname).C(*collection))
 	if err != nil {
 		log.Fatal("Error creating title index", err)
 	}
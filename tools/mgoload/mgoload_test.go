@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dustin/go-wikiparse"
+	"gopkg.in/mgo.v2"
+)
+
+// memCollection is an in-memory collection.  It only counts the
+// articles older versions stored.
+type memCollection struct {
+	mu     sync.Mutex
+	docs   map[uint64]article
+	legacy int
+	down   bool
+}
+
+func newMemCollection() *memCollection {
+	return &memCollection{docs: map[uint64]article{}}
+}
+
+func (m *memCollection) Upsert(a *article) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return errors.New("no reachable servers")
+	}
+	m.docs[a.ID] = *a
+	return nil
+}
+
+func (m *memCollection) Find(ids []uint64) ([]article, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rv []article
+	for _, id := range ids {
+		if a, ok := m.docs[id]; ok {
+			rv = append(rv, article{ID: a.ID, Title: a.Title, RevInfo: a.RevInfo})
+		}
+	}
+	return rv, nil
+}
+
+func (m *memCollection) Remove(id uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.docs, id)
+	return nil
+}
+
+func (m *memCollection) IDs(fn func(uint64) error) error {
+	for id := range m.docs {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memCollection) RemoveLegacy() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.legacy
+	m.legacy = 0
+	return n, nil
+}
+
+func load(t *testing.T, m *memCollection, dump string) wikiparse.LoadStats {
+	p, err := wikiparse.NewParser(strings.NewReader(dump))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	st, err := wikiparse.Load(p, &sink{m}, wikiparse.LoadOptions{Upsert: true, DeleteMissing: true})
+	if err != nil {
+		t.Fatalf("Error loading: %v", err)
+	}
+	return st
+}
+
+func TestSink(t *testing.T) {
+	m := newMemCollection()
+	load(t, m, `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Halifax</title><id>1</id><revision><id>10</id><contributor><username>Ed</username><id>4</id></contributor><text>[[Dartmouth]] [[File:Harbour.jpg]]</text></revision></page>
+<page><title>Bedford</title><id>2</id><revision><id>20</id><text>Town</text></revision></page>
+</mediawiki>`)
+	a := m.docs[1]
+	if a.Title != "Halifax" || a.RevInfo.ID != 10 || a.RevInfo.Contributor != "Ed" ||
+		a.RevInfo.SHA1 == "" || a.Links[0] != "Dartmouth" || a.Files[0] != "Harbour.jpg" {
+		t.Errorf("Unexpected article: %+v", a)
+	}
+
+	// Halifax is moved, keeping its text, and Bedford is gone.
+	m.legacy = 1
+	st := load(t, m, `<mediawiki><siteinfo><sitename>x</sitename></siteinfo>
+<page><title>Halifax, Nova Scotia</title><id>1</id><revision><id>11</id><text>[[Dartmouth]] [[File:Harbour.jpg]]</text></revision></page>
+</mediawiki>`)
+	if st.Written != 1 || st.Deleted != 1 {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+	if len(m.docs) != 1 || m.legacy != 1 || m.docs[1].Title != "Halifax, Nova Scotia" || m.docs[1].RevInfo.ID != 11 {
+		t.Errorf("Unexpected articles: %+v", m.docs)
+	}
+}
+
+func TestSinkError(t *testing.T) {
+	m := newMemCollection()
+	m.down = true
+	err := (&sink{m}).Write([]*wikiparse.Document{{Title: "Halifax"}})
+	if err == nil || !strings.Contains(err.Error(), "no reachable servers") {
+		t.Errorf("Expected an upsert error, got %v", err)
+	}
+}
+
+func TestSinkMigrate(t *testing.T) {
+	m := newMemCollection()
+	m.legacy = 3
+	n, err := (&sink{m}).migrate()
+	if err != nil || n != 3 || m.legacy != 0 {
+		t.Errorf("Expected 3 articles removed, got %v (%v), %v left", n, err, m.legacy)
+	}
+}
+
+// memIndexes is an in-memory set of indexes.
+type memIndexes struct {
+	indexes []mgo.Index
+	err     error
+	dropped int
+}
+
+func (m *memIndexes) Indexes() ([]mgo.Index, error) {
+	return m.indexes, m.err
+}
+
+func (m *memIndexes) DropIndex(key ...string) error {
+	for i, x := range m.indexes {
+		if reflect.DeepEqual(x.Key, key) {
+			m.indexes = append(m.indexes[:i], m.indexes[i+1:]...)
+			m.dropped++
+			return nil
+		}
+	}
+	return errors.New("index not found")
+}
+
+func (m *memIndexes) EnsureIndex(index mgo.Index) error {
+	for _, x := range m.indexes {
+		if reflect.DeepEqual(x.Key, index.Key) {
+			if x.Unique != index.Unique || x.Sparse != index.Sparse {
+				return errors.New("Index with name: title_1 already exists with different options")
+			}
+			return nil
+		}
+	}
+	m.indexes = append(m.indexes, index)
+	return nil
+}
+
+func TestEnsureTitleIndex(t *testing.T) {
+	t.Parallel()
+	id := mgo.Index{Key: []string{"_id"}}
+	tests := []struct {
+		name    string
+		m       *memIndexes
+		dropped int
+	}{
+		{"new collection", &memIndexes{err: &mgo.QueryError{Code: 26, Message: "ns not found"}}, 0},
+		{"no title index", &memIndexes{indexes: []mgo.Index{id}}, 0},
+		{"current", &memIndexes{indexes: []mgo.Index{id, titleIndex}}, 0},
+		{"old unique", &memIndexes{indexes: []mgo.Index{id, {
+			Key: []string{"title"}, Unique: true, DropDups: true, Background: true, Sparse: true,
+		}}}, 1},
+	}
+
+	for _, test := range tests {
+		if err := ensureTitleIndex(test.m); err != nil {
+			t.Errorf("%v: Error ensuring the title index: %v", test.name, err)
+			continue
+		}
+		if test.m.dropped != test.dropped {
+			t.Errorf("%v: Expected %v dropped, got %v", test.name, test.dropped, test.m.dropped)
+		}
+		if last := test.m.indexes[len(test.m.indexes)-1]; !reflect.DeepEqual(last, titleIndex) {
+			t.Errorf("%v: Expected %+v, got %+v", test.name, titleIndex, last)
+		}
+	}
+
+	m := &memIndexes{err: errors.New("no reachable servers")}
+	if err := ensureTitleIndex(m); err != m.err {
+		t.Errorf("Expected %v, got %v", m.err, err)
+	}
+}
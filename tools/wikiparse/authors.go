@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+type authorRecord struct {
+	Name      string  `json:"name"`
+	ID        uint64  `json:"userid,omitempty"`
+	Tokens    int     `json:"tokens"`
+	Share     float64 `json:"share"`
+	Added     int     `json:"added"`
+	Revisions int     `json:"revisions"`
+}
+
+// authorsRecord is a line of authors output: one page.
+type authorsRecord struct {
+	ID         uint64           `json:"id"`
+	Title      string           `json:"title"`
+	RevisionID uint64           `json:"revid"`
+	Authors    []authorRecord   `json:"authors"`
+	Tokens     [][2]interface{} `json:"tokens,omitempty"`
+}
+
+func runAuthors(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	outFile := fs.String("o", "-", "Output file (- for stdout)")
+	tokens := fs.Bool("tokens", false, "Include each token of the latest revision with the revision that added it")
+	fs.Parse(args)
+
+	p, done, err := in.open(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	out, err := createOutput(*outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+
+	err = in.eachPage(p, func(page *wikiparse.Page) error {
+		if len(page.Revisions) == 0 {
+			return nil
+		}
+		a := wikiparse.PageAuthorship(page)
+		rec := authorsRecord{
+			ID:         page.ID,
+			Title:      page.Title,
+			RevisionID: page.Revisions[len(page.Revisions)-1].ID,
+			Authors:    []authorRecord{},
+		}
+		for _, s := range a.Stats() {
+			rec.Authors = append(rec.Authors, authorRecord{
+				Name:      s.Contributor.Name(),
+				ID:        s.Contributor.ID,
+				Tokens:    s.Tokens,
+				Share:     s.Share,
+				Added:     s.Added,
+				Revisions: s.Revisions,
+			})
+		}
+		if *tokens {
+			for _, t := range a.Tokens() {
+				rec.Tokens = append(rec.Tokens, [2]interface{}{t.Text, t.RevisionID})
+			}
+		}
+		return e.Encode(rec)
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
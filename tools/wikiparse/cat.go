@@ -0,0 +1,133 @@
+package main
+
+import (
+	"compress/bzip2"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+func runCat(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	plain := fs.Bool("plain", false, "Print plain text rather than wikitext")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+	}
+
+	wanted := map[string]bool{}
+	for _, t := range fs.Args()[1:] {
+		wanted[wikiparse.CanonicalTitle(t)] = true
+	}
+	show := func(page *wikiparse.Page) {
+		if !wanted[wikiparse.CanonicalTitle(page.Title)] || (in.ns >= 0 && page.Ns != uint64(in.ns)) {
+			return
+		}
+		delete(wanted, wikiparse.CanonicalTitle(page.Title))
+		t := text(page)
+		if *plain {
+			t = wikiparse.PlainText(t)
+		}
+		fmt.Printf("%s\n", t)
+	}
+
+	// Incremental dumps may have newer versions of the pages, so
+	// only look them up directly when there aren't any.
+	datafn := fs.Arg(0)
+	if idx := in.findIndex(datafn); idx != "" && len(in.incr) == 0 {
+		if err := catIndexed(idx, datafn, wanted, show); err != nil {
+			return err
+		}
+	} else {
+		p, done, err := in.open(fs.Args()[:1])
+		if err != nil {
+			return err
+		}
+		defer done()
+		err = in.eachPage(p, func(page *wikiparse.Page) error {
+			show(page)
+			if len(wanted) == 0 {
+				return errStop
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for t := range wanted {
+		fmt.Fprintf(os.Stderr, "wikiparse: %q not found\n", t)
+	}
+	return nil
+}
+
+// catIndexed finds the wanted pages in a multistream dump by looking
+// them up in the index and decoding only their streams.
+func catIndexed(idxfn, datafn string, wanted map[string]bool, show func(*wikiparse.Page)) error {
+	f, err := os.Open(idxfn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Stream offset -> number of pages in the stream.
+	streams := map[int64]int{}
+	var cur int64 = -1
+	count, found := 0, false
+	endRun := func() {
+		if found {
+			streams[cur] = count
+		}
+	}
+	ir := wikiparse.NewIndexReader(bzip2.NewReader(f))
+	for {
+		e, err := ir.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if e.StreamOffset != cur {
+			endRun()
+			cur, count, found = e.StreamOffset, 0, false
+		}
+		count++
+		found = found || wanted[wikiparse.CanonicalTitle(e.ArticleName)]
+	}
+	endRun()
+
+	data, err := os.Open(datafn)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	offsets := make([]int64, 0, len(streams))
+	for offset := range streams {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	for _, offset := range offsets {
+		count := streams[offset]
+		if _, err := data.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		// bzip2 would carry on into the following streams, so only
+		// read this one's pages.
+		d := xml.NewDecoder(bzip2.NewReader(data))
+		for i := 0; i < count; i++ {
+			page := &wikiparse.Page{}
+			if err := d.Decode(page); err != nil {
+				return err
+			}
+			show(page)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+// changeRecord is a line of the change stream.
+type changeRecord struct {
+	Change       string `json:"change"`
+	ID           uint64 `json:"id"`
+	Title        string `json:"title"`
+	Ns           uint64 `json:"ns"`
+	RevisionID   uint64 `json:"revid"`
+	Timestamp    string `json:"timestamp"`
+	PreviousID   uint64 `json:"previous_revid,omitempty"`
+	PreviousTime string `json:"previous_timestamp,omitempty"`
+}
+
+func runChanges(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	outFile := fs.String("o", "-", "Output file (- for stdout)")
+	fs.Parse(args)
+
+	if len(in.incr) == 0 {
+		return errors.New("need at least one incremental dump (-incr)")
+	}
+	inc, err := in.incremental()
+	if err != nil {
+		return err
+	}
+	p, done, err := in.openBase(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	out, err := createOutput(*outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+
+	err = inc.Changes(p, func(c wikiparse.Change) error {
+		if in.ns >= 0 && c.Page.Ns != uint64(in.ns) {
+			return nil
+		}
+		r := c.Page.Revisions[len(c.Page.Revisions)-1]
+		rec := changeRecord{
+			Change:     c.Kind.String(),
+			ID:         c.Page.ID,
+			Title:      c.Page.Title,
+			Ns:         c.Page.Ns,
+			RevisionID: r.ID,
+			Timestamp:  r.Timestamp,
+		}
+		if c.Previous != nil {
+			rec.PreviousID, rec.PreviousTime = c.Previous.ID, c.Previous.Timestamp
+		}
+		return e.Encode(rec)
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
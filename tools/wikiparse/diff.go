@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+// diffRecord is a line of diff output: one edit.
+type diffRecord struct {
+	ID                uint64      `json:"id"`
+	Title             string      `json:"title"`
+	From              uint64      `json:"from"`
+	To                uint64      `json:"to"`
+	Timestamp         string      `json:"timestamp"`
+	Contributor       string      `json:"contributor"`
+	WordsAdded        int         `json:"words_added"`
+	WordsRemoved      int         `json:"words_removed"`
+	LinksAdded        []string    `json:"links_added,omitempty"`
+	LinksRemoved      []string    `json:"links_removed,omitempty"`
+	CategoriesAdded   []string    `json:"categories_added,omitempty"`
+	CategoriesRemoved []string    `json:"categories_removed,omitempty"`
+	TemplatesAdded    []string    `json:"templates_added,omitempty"`
+	TemplatesRemoved  []string    `json:"templates_removed,omitempty"`
+	Words             [][2]string `json:"words,omitempty"`
+}
+
+func runDiff(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	outFile := fs.String("o", "-", "Output file (- for stdout)")
+	words := fs.Bool("words", false, "Include the inserted and deleted words of each edit")
+	fs.Parse(args)
+
+	p, done, err := in.open(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	out, err := createOutput(*outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+
+	err = in.eachPage(p, func(page *wikiparse.Page) error {
+		i := 0
+		return wikiparse.DiffPage(page, func(d wikiparse.RevisionDiff) error {
+			r := page.Revisions[i]
+			i++
+			rec := diffRecord{
+				ID:                page.ID,
+				Title:             page.Title,
+				From:              d.From,
+				To:                d.To,
+				Timestamp:         r.Timestamp,
+				Contributor:       r.Contributor.Username,
+				WordsAdded:        d.WordsAdded,
+				WordsRemoved:      d.WordsRemoved,
+				LinksAdded:        d.LinksAdded,
+				LinksRemoved:      d.LinksRemoved,
+				CategoriesAdded:   d.CategoriesAdded,
+				CategoriesRemoved: d.CategoriesRemoved,
+				TemplatesAdded:    d.TemplatesAdded,
+				TemplatesRemoved:  d.TemplatesRemoved,
+			}
+			if *words {
+				for _, c := range d.Words {
+					if c.Op != wikiparse.DiffEqual {
+						rec.Words = append(rec.Words, [2]string{c.Op.String(), c.Text})
+					}
+				}
+			}
+			return e.Encode(rec)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
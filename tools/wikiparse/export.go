@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dustin/go-wikiparse"
+	"github.com/dustin/go-wikiparse/parquetexport"
+	"github.com/dustin/go-wikiparse/sqliteexport"
+)
+
+// createOutput opens the named file for writing, or stdout for "-".
+func createOutput(fn string) (io.WriteCloser, error) {
+	if fn == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(fn)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// primary gets the coordinate that best represents a page: the one
+// shown by the title, or else the first.
+func primary(coords []wikiparse.PageCoord) wikiparse.PageCoord {
+	for _, c := range coords {
+		if c.IsTitle() {
+			return c
+		}
+	}
+	return coords[0]
+}
+
+func runExport(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	format := fs.String("format", "jsonl", "Output format: jsonl, geojsonseq, kml, parquet or sqlite")
+	outFile := fs.String("o", "-", "Output file (- for stdout), or directory for parquet")
+	fields := fs.String("fields", "metadata",
+		"jsonl fields: all, metadata, or any of text,plaintext,links,categories,coords,templates\n"+
+			"(parquet uses text, links and categories; sqlite uses text)")
+	rowGroup := fs.Int64("rowgroup", parquetexport.DefaultRowGroupSize, "Rows per parquet row group")
+	all := fs.Bool("all", false, "Export every coordinate, not just each page's primary one")
+	fs.Parse(args)
+
+	exportFields, err := wikiparse.ParseExportFields(*fields)
+	if err != nil {
+		return err
+	}
+
+	p, done, err := in.open(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	inNs := func(page *wikiparse.Page) bool {
+		return in.ns < 0 || page.Ns == uint64(in.ns)
+	}
+	switch *format {
+	case "parquet":
+		if *outFile == "-" {
+			return fmt.Errorf("parquet export needs an output directory (-o)")
+		}
+		st, err := parquetexport.Export(p, parquetexport.Options{
+			Dir:          *outFile,
+			Fields:       exportFields,
+			RowGroupSize: *rowGroup,
+			Workers:      in.workers,
+			Filter:       inNs,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d pages, %d revisions, %d links, %d categories to %s\n",
+			st.Pages, st.Revisions, st.Links, st.Categories, *outFile)
+		return nil
+	case "sqlite":
+		if *outFile == "-" {
+			return fmt.Errorf("sqlite export needs an output file (-o)")
+		}
+		st, err := sqliteexport.Export(p, *outFile, sqliteexport.Options{
+			Fields:  exportFields,
+			Workers: in.workers,
+			Filter:  inNs,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d pages, %d revisions, %d redirects, %d links, %d categories to %s\n",
+			st.Pages, st.Revisions, st.Redirects, st.Links, st.Categories, *outFile)
+		return nil
+	}
+
+	out, err := createOutput(*outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+
+	si := p.SiteInfo()
+	var e wikiparse.GeoEncoder
+	switch *format {
+	case "jsonl":
+		je := wikiparse.NewJSONLEncoder(w, exportFields, si)
+		if err := in.eachPage(p, je.Encode); err != nil {
+			return err
+		}
+		return w.Flush()
+	case "geojsonseq":
+		e = wikiparse.NewGeoJSONSeqEncoder(w)
+	case "kml":
+		e = wikiparse.NewKMLEncoder(w)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	err = in.eachPage(p, func(page *wikiparse.Page) error {
+		if page.Redir.Title != "" {
+			return nil
+		}
+		var earth []wikiparse.PageCoord
+		for _, c := range wikiparse.FindLocations(text(page)) {
+			if c.Params.OnEarth() {
+				earth = append(earth, c)
+			}
+		}
+		if len(earth) == 0 {
+			return nil
+		}
+		if !*all {
+			earth = []wikiparse.PageCoord{primary(earth)}
+		}
+		for _, c := range earth {
+			if err := e.Encode(wikiparse.NewGeoFeature(page, c, si)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := e.Close(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
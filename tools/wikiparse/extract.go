@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+// extractors get the values of a page to print, each as a tab
+// separated string.
+var extractors = map[string]func(si wikiparse.SiteInfo, text string) []string{
+	"links": func(si wikiparse.SiteInfo, text string) []string {
+		return wikiparse.FindLinks(text)
+	},
+	"files": func(si wikiparse.SiteInfo, text string) []string {
+		var rv []string
+		for _, f := range wikiparse.FindFileDetails(text, si) {
+			rv = append(rv, f.Name)
+		}
+		return rv
+	},
+	"categories": func(si wikiparse.SiteInfo, text string) []string {
+		return wikiparse.FindCategories(text)
+	},
+	"coords": func(si wikiparse.SiteInfo, text string) []string {
+		var rv []string
+		for _, c := range wikiparse.FindLocations(text) {
+			if c.Params.OnEarth() {
+				rv = append(rv, strconv.FormatFloat(c.Lat, 'f', -1, 64)+"\t"+
+					strconv.FormatFloat(c.Lon, 'f', -1, 64)+"\t"+c.Source.String())
+			}
+		}
+		return rv
+	},
+}
+
+func runExtract(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	redirects := fs.Bool("redirects", false, "Include redirect pages")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+	}
+	extract, ok := extractors[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("can't extract %q", fs.Arg(0))
+	}
+
+	p, done, err := in.open(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	si := p.SiteInfo()
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	return in.eachPage(p, func(page *wikiparse.Page) error {
+		if page.Redir.Title != "" && !*redirects {
+			return nil
+		}
+		for _, v := range extract(si, text(page)) {
+			fmt.Fprintf(w, "%s\t%s\n", page.Title, v)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+func runGrep(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	ignoreCase := fs.Bool("i", false, "Ignore case")
+	titlesOnly := fs.Bool("l", false, "Only print the titles of matching pages")
+	inTitles := fs.Bool("title", false, "Match page titles rather than text")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+	}
+
+	pattern := fs.Arg(0)
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	p, done, err := in.open(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	return in.eachPage(p, func(page *wikiparse.Page) error {
+		if *inTitles {
+			if re.MatchString(page.Title) {
+				fmt.Fprintln(w, page.Title)
+			}
+			return nil
+		}
+		t := text(page)
+		if !re.MatchString(t) {
+			return nil
+		}
+		if *titlesOnly {
+			fmt.Fprintln(w, page.Title)
+			return nil
+		}
+		for i, line := range strings.Split(t, "\n") {
+			if re.MatchString(line) {
+				fmt.Fprintf(w, "%s:%d:%s\n", page.Title, i+1, line)
+			}
+		}
+		return nil
+	})
+}
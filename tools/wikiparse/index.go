@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+func runIndex(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	outFile := fs.String("o", "",
+		"Output file (default geo.idx, links.graph with links.titles, or redirects.tsv)")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+	}
+
+	p, done, err := in.open(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	pages := in.filter(p)
+
+	switch fs.Arg(0) {
+	case "geo":
+		g, err := wikiparse.BuildGeoIndex(pages, 0)
+		if err != nil {
+			return err
+		}
+		return writeFile(*outFile, "geo.idx", func(w *bufio.Writer) error {
+			_, err := g.WriteTo(w)
+			return err
+		})
+	case "links":
+		g, err := wikiparse.BuildLinkGraph(pages)
+		if err != nil {
+			return err
+		}
+		base := *outFile
+		if base == "" {
+			base = "links"
+		}
+		err = writeFile(base+".graph", "", func(w *bufio.Writer) error {
+			_, err := g.WriteTo(w)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return writeFile(base+".titles", "", func(w *bufio.Writer) error {
+			return g.WriteTitles(w)
+		})
+	case "redirects":
+		r, err := wikiparse.BuildRedirects(pages)
+		if err != nil {
+			return err
+		}
+		m := r.Map()
+		froms := make([]string, 0, len(m))
+		for from := range m {
+			froms = append(froms, from)
+		}
+		sort.Strings(froms)
+		return writeFile(*outFile, "redirects.tsv", func(w *bufio.Writer) error {
+			for _, from := range froms {
+				t := m[from]
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%v\n", from, t.Title, t.Section, t.Hops, t.Loop)
+			}
+			return nil
+		})
+	}
+	return fmt.Errorf("can't build a %q index", fs.Arg(0))
+}
+
+// writeFile writes an output file through a buffer.
+func writeFile(name, def string, write func(w *bufio.Writer) error) error {
+	if name == "" {
+		name = def
+	}
+	f, err := createOutput(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
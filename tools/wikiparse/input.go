@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+// inputFlags are the options every command has for reading a dump.
+type inputFlags struct {
+	index   string
+	workers int
+	ns      int
+	incr    []string
+}
+
+func addInputFlags(fs *flag.FlagSet) *inputFlags {
+	in := &inputFlags{}
+	fs.StringVar(&in.index, "index", "",
+		"Multistream index for the dump (found automatically next to *-multistream.xml.bz2)")
+	fs.IntVar(&in.workers, "workers", runtime.GOMAXPROCS(0),
+		"Number of multistream parsing workers")
+	fs.IntVar(&in.ns, "ns", -1, "Only process pages in this namespace (-1 for all)")
+	fs.Func("incr", "Incremental (adds-changes) dump to apply to the dump (may be repeated)",
+		func(fn string) error {
+			in.incr = append(in.incr, fn)
+			return nil
+		})
+	return in
+}
+
+// findIndex gets the index file for a multistream dump, if there is
+// one, e.g. enwiki-20240101-pages-articles-multistream-index.txt.bz2
+// for enwiki-20240101-pages-articles-multistream.xml.bz2.
+func (in *inputFlags) findIndex(datafn string) string {
+	if in.index != "" {
+		return in.index
+	}
+	const suffix = "-multistream.xml.bz2"
+	if !strings.HasSuffix(datafn, suffix) {
+		return ""
+	}
+	idx := strings.TrimSuffix(datafn, suffix) + "-multistream-index.txt.bz2"
+	if _, err := os.Stat(idx); err != nil {
+		return ""
+	}
+	return idx
+}
+
+// decompress detects bzip2 and gzip streams by their magic numbers.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	magic, err := br.Peek(3)
+	switch {
+	case err != nil && err != io.EOF:
+		return nil, err
+	case len(magic) == 3 && string(magic) == "BZh":
+		return bzip2.NewReader(br), nil
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// open gets a parser for the dump named by the command's arguments
+// with any incremental dumps applied.  The returned function releases
+// the input.
+func (in *inputFlags) open(args []string) (wikiparse.Parser, func() error, error) {
+	if len(in.incr) == 0 {
+		return in.openBase(args)
+	}
+	inc, err := in.incremental()
+	if err != nil {
+		return nil, nil, err
+	}
+	p, done, err := in.openBase(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return inc.Merge(p), done, nil
+}
+
+// incremental reads the incremental dumps given with -incr.
+func (in *inputFlags) incremental() (*wikiparse.Incremental, error) {
+	inc := wikiparse.NewIncremental()
+	for _, fn := range in.incr {
+		p, done, err := openFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		err = inc.Add(p)
+		if cerr := done(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+	return inc, nil
+}
+
+// openBase gets a parser for the dump named by the command's
+// arguments: a file (plain, bzip2 or gzip), "-" for stdin, or a
+// multistream index and data file.
+func (in *inputFlags) openBase(args []string) (wikiparse.Parser, func() error, error) {
+	var datafn string
+	switch len(args) {
+	case 1:
+		datafn = args[0]
+	case 2:
+		in.index, datafn = args[0], args[1]
+	default:
+		return nil, nil, errors.New("need a dump file, or an index and multistream dump")
+	}
+
+	if idx := in.findIndex(datafn); idx != "" {
+		p, err := wikiparse.NewIndexedParser(idx, datafn, in.workers)
+		return p, func() error { return nil }, err
+	}
+	return openFile(datafn)
+}
+
+// openFile gets a parser for a single dump file, or stdin for "-".
+func openFile(datafn string) (wikiparse.Parser, func() error, error) {
+	f := os.Stdin
+	if datafn != "-" {
+		var err error
+		if f, err = os.Open(datafn); err != nil {
+			return nil, nil, err
+		}
+	}
+	r, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	p, err := wikiparse.NewParser(r)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return p, f.Close, nil
+}
+
+// filteredParser is a Parser that skips pages outside the namespace
+// chosen with -ns.
+type filteredParser struct {
+	wikiparse.Parser
+	ns int
+}
+
+func (f *filteredParser) Next() (*wikiparse.Page, error) {
+	for {
+		p, err := f.Parser.Next()
+		if err != nil || f.ns < 0 || p.Ns == uint64(f.ns) {
+			return p, err
+		}
+	}
+}
+
+// filter applies the namespace filter to a parser.
+func (in *inputFlags) filter(p wikiparse.Parser) wikiparse.Parser {
+	return &filteredParser{p, in.ns}
+}
+
+// eachPage calls fn for every page in the requested namespace until
+// the dump ends or fn returns an error.  Use errStop to stop early
+// without an error.
+func (in *inputFlags) eachPage(p wikiparse.Parser, fn func(*wikiparse.Page) error) error {
+	p = in.filter(p)
+	for {
+		page, err := p.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			if err == errStop {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+var errStop = errors.New("stop")
+
+// text gets the latest revision's text of a page.
+func text(p *wikiparse.Page) string {
+	if len(p.Revisions) == 0 {
+		return ""
+	}
+	return p.Revisions[len(p.Revisions)-1].Text
+}
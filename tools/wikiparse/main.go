@@ -0,0 +1,86 @@
+// Command wikiparse works with mediawiki XML dumps.
+//
+// Every command reads a dump given as a file (plain, bzip2 or gzip
+// compressed), "-" for stdin, or a multistream dump with its index.
+// Incremental (adds-changes) dumps given with -incr are applied to it.
+// Run "wikiparse help <command>" for a command's options.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+type command struct {
+	name, args, help string
+	run              func(name string, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"stats", "dump", "count pages, redirects and revisions by namespace", runStats},
+		{"cat", "dump title...", "print the text of pages by title", runCat},
+		{"grep", "pattern dump", "print lines of page text matching a regular expression", runGrep},
+		{"extract", "links|files|coords|categories dump",
+			"print a page's links, files, coordinates or categories as title<TAB>value lines", runExtract},
+		{"export", "dump", "export pages as JSON Lines, Parquet or SQLite, or geotagged pages as GeoJSON or KML", runExport},
+		{"index", "geo|links|redirects dump", "build a geo index, link graph or redirect table", runIndex},
+		{"diff", "dump", "list what each revision in a history dump changed", runDiff},
+		{"authors", "dump", "attribute the latest text of each page in a history dump to its contributors", runAuthors},
+		{"reverts", "dump", "label each revision in a history dump as a revert, reverted or normal", runReverts},
+		{"changes", "-incr incremental... dump", "list the pages incremental dumps add to or update in a dump", runChanges},
+	}
+	flag.Usage = usage
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n  %s command [opts] args\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.help)
+	}
+	os.Exit(1)
+}
+
+// newFlagSet gets the flags for a command, with usage showing its
+// arguments.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(os.Stderr, "Usage:\n  %s %s [opts] %s\n\n%s\n\nOptions:\n",
+					os.Args[0], name, c.args, c.help)
+			}
+		}
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	return fs
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wikiparse: ")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+
+	name, args := flag.Arg(0), flag.Args()[1:]
+	if name == "help" && len(args) == 1 {
+		name, args = args[0], []string{"-h"}
+	}
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(name, args); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	usage()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+
+	"github.com/dustin/go-wikiparse"
+)
+
+// revertRecord is a line of reverts output: one revision.
+type revertRecord struct {
+	ID         uint64 `json:"id"`
+	Title      string `json:"title"`
+	RevisionID uint64 `json:"revid"`
+	Label      string `json:"label"`
+	Target     uint64 `json:"target,omitempty"`
+	Undo       bool   `json:"undo,omitempty"`
+}
+
+func runReverts(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	outFile := fs.String("o", "-", "Output file (- for stdout)")
+	radius := fs.Int("radius", wikiparse.DefaultRevertRadius, "Number of revisions back to look for a revert")
+	onlyReverts := fs.Bool("reverts", false, "Only list reverts and reverted revisions")
+	fs.Parse(args)
+
+	p, done, err := in.open(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	out, err := createOutput(*outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+
+	err = in.eachPage(p, func(page *wikiparse.Page) error {
+		for _, l := range wikiparse.PageReverts(page, *radius) {
+			if *onlyReverts && l.Kind == wikiparse.RevisionNormal {
+				continue
+			}
+			err := e.Encode(revertRecord{
+				ID:         page.ID,
+				Title:      page.Title,
+				RevisionID: l.RevisionID,
+				Label:      l.Kind.String(),
+				Target:     l.Target,
+				Undo:       l.Undo,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+	"github.com/dustin/go-wikiparse"
+)
+
+type nsStats struct {
+	pages, redirects, revisions, bytes int64
+}
+
+func runStats(name string, args []string) error {
+	fs := newFlagSet(name)
+	in := addInputFlags(fs)
+	fs.Parse(args)
+
+	p, done, err := in.open(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	byNs := map[uint64]*nsStats{}
+	var total nsStats
+	err = in.eachPage(p, func(page *wikiparse.Page) error {
+		s := byNs[page.Ns]
+		if s == nil {
+			s = &nsStats{}
+			byNs[page.Ns] = s
+		}
+		for _, st := range []*nsStats{s, &total} {
+			st.pages++
+			if page.Redir.Title != "" {
+				st.redirects++
+			}
+			st.revisions += int64(len(page.Revisions))
+			st.bytes += int64(len(text(page)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	names := map[uint64]string{}
+	for _, ns := range p.SiteInfo().Namespaces {
+		var k uint64
+		if _, err := fmt.Sscan(ns.Key, &k); err == nil {
+			names[k] = ns.Value
+		}
+	}
+	keys := make([]uint64, 0, len(byNs))
+	for k := range byNs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	row := func(label string, s *nsStats) {
+		fmt.Printf("%-20s %12s %12s %12s %10s\n", label, humanize.Comma(s.pages),
+			humanize.Comma(s.redirects), humanize.Comma(s.revisions),
+			humanize.Bytes(uint64(s.bytes)))
+	}
+	fmt.Printf("%-20s %12s %12s %12s %10s\n", "namespace", "pages", "redirects", "revisions", "text")
+	for _, k := range keys {
+		label := fmt.Sprintf("%d", k)
+		if names[k] != "" {
+			label += " " + names[k]
+		}
+		row(label, byNs[k])
+	}
+	row("total", &total)
+	return nil
+}
@@ -0,0 +1,950 @@
+package wikiparse
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A NodeKind identifies what a Node in parsed wikitext represents.
+type NodeKind int
+
+// The kinds of nodes produced by ParseWikitext.
+const (
+	// TextNode is literal text.
+	TextNode NodeKind = iota
+	// ParagraphNode is a run of text lines.
+	ParagraphNode
+	// HeadingNode is a section heading (== like this ==).
+	HeadingNode
+	// ListItemNode is a line beginning with *, #, : or ;.
+	ListItemNode
+	// HRNode is a horizontal rule (----).
+	HRNode
+	// TableNode is a {| ... |} table.
+	TableNode
+	// TableCaptionNode is a |+ table caption.
+	TableCaptionNode
+	// TableRowNode is a |- table row.
+	TableRowNode
+	// TableHeaderNode is a ! table header cell.
+	TableHeaderNode
+	// TableCellNode is a | table data cell.
+	TableCellNode
+	// TemplateNode is a {{template}} transclusion.
+	TemplateNode
+	// ArgumentNode is a {{{template argument}}}.
+	ArgumentNode
+	// LinkNode is an [[internal link]].
+	LinkNode
+	// ExtLinkNode is a bracketed [http://external link].
+	ExtLinkNode
+	// TagNode is an extension tag such as <ref> or <nowiki>.
+	TagNode
+	// HTMLNode is an individual opening or closing HTML tag.
+	HTMLNode
+	// CommentNode is an <!-- HTML comment -->.
+	CommentNode
+	// BoldNode is '''bold''' text.
+	BoldNode
+	// ItalicNode is ''italic'' text.
+	ItalicNode
+)
+
+// formatMarker is a run of apostrophes before nestFormatting
+// resolves it into bold and italic nodes.
+const formatMarker NodeKind = -1
+
+var nodeKindNames = []string{"text", "paragraph", "heading", "listitem",
+	"hr", "table", "caption", "row", "header", "cell", "template",
+	"argument", "link", "extlink", "tag", "html", "comment", "bold",
+	"italic"}
+
+func (k NodeKind) String() string {
+	if k >= 0 && int(k) < len(nodeKindNames) {
+		return nodeKindNames[k]
+	}
+	return "NodeKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A Node is an element of parsed wikitext.
+type Node struct {
+	Kind NodeKind
+	// Text is the literal text of a TextNode, the raw content of a
+	// TagNode or CommentNode, or the raw markup of an HTMLNode.
+	Text string
+	// Name is the template or argument name, link target, URL or
+	// lowercased tag name.
+	Name string
+	// Level is the level of a HeadingNode.
+	Level int
+	// Prefix is the list markup of a ListItemNode (e.g. "*#").
+	Prefix string
+	// Closing is true for HTMLNodes that are closing tags.
+	Closing bool
+	// Attrs holds attributes of tags and table elements.
+	Attrs map[string]string
+	// Params are template parameters, or the pipe-separated parts
+	// of a link after its target.
+	Params []Param
+	// Children are nested nodes.  For links, these are the label.
+	Children []*Node
+	// Pos and End are the byte offsets of the node in the source.
+	Pos, End int
+}
+
+// A Param is a template parameter or a link option.
+type Param struct {
+	// Name is the parameter name, or its position ("1", "2", ...)
+	// for positional parameters.
+	Name  string
+	Raw   string
+	Value []*Node
+	// Pos and End are the byte offsets of the value in the source.
+	Pos, End int
+}
+
+// Param finds the named parameter of a template or link.
+func (n *Node) Param(name string) (Param, bool) {
+	for _, p := range n.Params {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Param{}, false
+}
+
+// ParamText gets the trimmed raw value of the named parameter with
+// comments removed, or "" if it's not present.
+func (n *Node) ParamText(name string) string {
+	p, ok := n.Param(name)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(commentRE.ReplaceAllString(p.Raw, ""))
+}
+
+// TemplateName gets the canonical name of a template node, e.g.
+// "{{ template:cite_web |...}}" has the name "Cite web".
+func (n *Node) TemplateName() string {
+	name := commentRE.ReplaceAllString(n.Name, "")
+	if i := strings.IndexByte(name, ':'); i >= 0 &&
+		strings.EqualFold(strings.TrimSpace(name[:i]), "template") {
+		name = name[i+1:]
+	}
+	return canonicalTitle(name)
+}
+
+// FindTemplates finds the names of the templates used in an article,
+// in the order they first appear, including templates nested in
+// others' parameters.  Parser functions such as {{#if:...}} and magic
+// words such as {{DEFAULTSORT:...}} or {{PAGENAME}} aren't included.
+func FindTemplates(text string) []string {
+	var rv []string
+	seen := map[string]bool{}
+	Walk(ParseWikitext(text), func(n *Node) bool {
+		if n.Kind == CommentNode {
+			return false
+		}
+		if n.Kind != TemplateNode {
+			return true
+		}
+		name := n.TemplateName()
+		if name != "" && !seen[name] && !strings.HasPrefix(name, "#") &&
+			!strings.Contains(name, ":") && !magicVariables[name] {
+			seen[name] = true
+			rv = append(rv, name)
+		}
+		return true
+	})
+	return rv
+}
+
+// Magic words used as {{NAME}}, which look like templates.
+var magicVariables = map[string]bool{
+	"!": true, "=": true,
+	"PAGENAME": true, "PAGENAMEE": true, "FULLPAGENAME": true,
+	"FULLPAGENAMEE": true, "BASEPAGENAME": true, "SUBPAGENAME": true,
+	"ROOTPAGENAME": true, "TALKPAGENAME": true, "NAMESPACE": true,
+	"NAMESPACENUMBER": true, "PAGEID": true, "SITENAME": true,
+	"SERVER": true, "SERVERNAME": true, "SCRIPTPATH": true,
+	"CURRENTYEAR": true, "CURRENTMONTH": true, "CURRENTMONTHNAME": true,
+	"CURRENTDAY": true, "CURRENTDAY2": true, "CURRENTDAYNAME": true,
+	"CURRENTTIME": true, "CURRENTTIMESTAMP": true,
+	"LOCALYEAR": true, "LOCALMONTH": true, "LOCALDAY": true,
+	"LOCALTIME": true, "LOCALTIMESTAMP": true,
+	"REVISIONID": true, "REVISIONYEAR": true, "REVISIONTIMESTAMP": true,
+	"REVISIONUSER": true, "NUMBEROFARTICLES": true, "NUMBEROFPAGES": true,
+	"NUMBEROFUSERS": true, "NUMBEROFFILES": true, "NUMBEROFEDITS": true,
+	"CONTENTLANGUAGE": true, "DIRECTIONMARK": true,
+}
+
+// Walk visits nodes depth first, including template parameter and
+// link option values.  If fn returns false, the node's descendants
+// are skipped.
+func Walk(nodes []*Node, fn func(*Node) bool) {
+	for _, n := range nodes {
+		if !fn(n) {
+			continue
+		}
+		for _, p := range n.Params {
+			Walk(p.Value, fn)
+		}
+		Walk(n.Children, fn)
+	}
+}
+
+// CanonicalTitle normalizes a page title the way MediaWiki does, so
+// link targets can be compared with the titles in a dump.
+func CanonicalTitle(s string) string {
+	return canonicalTitle(s)
+}
+
+// canonicalTitle normalizes a page title the way MediaWiki does:
+// underscores become spaces, runs of whitespace collapse, and the
+// first letter is capitalized.
+func canonicalTitle(s string) string {
+	s = strings.Join(strings.Fields(strings.Replace(s, "_", " ", -1)), " ")
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+var tagRE, attrRE, extLinkRE, magicWordRE *regexp.Regexp
+
+func init() {
+	tagRE = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9]*)(\s[^<>]*?)?\s*(/?)>`)
+	attrRE = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+	extLinkRE = regexp.MustCompile(`^\[((?i:(?:https?|ftps?|irc|ircs|news|nntp|gopher|git|svn|sftp|ssh|telnet|mms|worldwind)://|//|mailto:|urn:)[^\s\]<>"\[{}|]+)`)
+	magicWordRE = regexp.MustCompile(`^__[A-Z]+__`)
+}
+
+// Extension tags whose content is not wikitext.
+var rawTags = map[string]bool{
+	"nowiki": true, "pre": true, "math": true, "gallery": true,
+	"source": true, "syntaxhighlight": true, "timeline": true,
+	"score": true, "hiero": true, "chem": true, "ce": true,
+	"templatedata": true, "graph": true, "imagemap": true,
+	"inputbox": true, "categorytree": true, "mapframe": true,
+	"maplink": true, "templatestyles": true,
+}
+
+// Extension tags whose content is parsed as wikitext.
+var parsedTags = map[string]bool{
+	"ref": true, "references": true, "poem": true,
+	"includeonly": true, "noinclude": true, "onlyinclude": true,
+}
+
+func isExtTag(name string) bool {
+	return rawTags[name] || parsedTags[name]
+}
+
+func parseAttrs(s string) map[string]string {
+	matches := attrRE.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	rv := make(map[string]string, len(matches))
+	for _, m := range matches {
+		rv[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+	}
+	return rv
+}
+
+// asciiLower lowercases ASCII letters only so byte offsets are
+// preserved.
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+type tagInfo struct {
+	name                 string
+	attrs                map[string]string
+	closing, selfClosing bool
+	end                  int
+}
+
+type wikitextParser struct {
+	src, lower string
+}
+
+// ParseWikitext parses wikitext markup into a tree of block level
+// Nodes (paragraphs, headings, list items, rules and tables).
+//
+// This is not a full MediaWiki parser -- templates are not expanded
+// and HTML is not validated -- but it understands enough structure
+// to reliably pull text, links and templates out of articles.
+func ParseWikitext(text string) []*Node {
+	p := &wikitextParser{src: text, lower: asciiLower(text)}
+	return p.blocks(0, len(text))
+}
+
+func (p *wikitextParser) has(i, end int, prefix string) bool {
+	return end-i >= len(prefix) && p.src[i:i+len(prefix)] == prefix
+}
+
+func (p *wikitextParser) run(i, end int, c byte) int {
+	n := 0
+	for i+n < end && p.src[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func (p *wikitextParser) tagAt(i, end int) *tagInfo {
+	m := tagRE.FindStringSubmatchIndex(p.src[i:end])
+	if m == nil {
+		return nil
+	}
+	t := &tagInfo{
+		name:        p.lower[i+m[4] : i+m[5]],
+		closing:     m[3] > m[2],
+		selfClosing: m[9] > m[8],
+		end:         i + m[1],
+	}
+	if m[6] >= 0 {
+		t.attrs = parseAttrs(p.src[i+m[6] : i+m[7]])
+	}
+	return t
+}
+
+// extTagClose finds the closing tag for an extension tag, returning
+// the end of its content and the end of the closing tag.
+func (p *wikitextParser) extTagClose(t *tagInfo, end int) (int, int) {
+	needle := "</" + t.name
+	for i := t.end; i < end; {
+		j := strings.Index(p.lower[i:end], needle)
+		if j < 0 {
+			return -1, -1
+		}
+		j += i
+		k := j + len(needle)
+		for k < end && (p.src[k] == ' ' || p.src[k] == '\t') {
+			k++
+		}
+		if k < end && p.src[k] == '>' {
+			return j, k + 1
+		}
+		i = k
+	}
+	return -1, -1
+}
+
+func (p *wikitextParser) commentEnd(i, end int) int {
+	j := strings.Index(p.src[i+4:end], "-->")
+	if j < 0 {
+		return end
+	}
+	return i + 4 + j + 3
+}
+
+// skipOpaque returns the end of a comment or extension tag starting
+// at i, or -1.
+func (p *wikitextParser) skipOpaque(i, end int) int {
+	if p.src[i] != '<' {
+		return -1
+	}
+	if p.has(i, end, "<!--") {
+		return p.commentEnd(i, end)
+	}
+	t := p.tagAt(i, end)
+	if t == nil || t.closing || !isExtTag(t.name) {
+		return -1
+	}
+	if t.selfClosing {
+		return t.end
+	}
+	_, e := p.extTagClose(t, end)
+	return e
+}
+
+// skip returns the end of a balanced construct starting at i, or -1
+// if there is none.
+func (p *wikitextParser) skip(i, end int) int {
+	switch p.src[i] {
+	case '<':
+		return p.skipOpaque(i, end)
+	case '{':
+		if p.has(i, end, "{{") {
+			return p.braceEnd(i, end)
+		}
+	case '[':
+		if p.has(i, end, "[[") {
+			return p.linkEnd(i, end)
+		}
+	}
+	return -1
+}
+
+// braceEnd finds the end of the template or argument starting at i.
+func (p *wikitextParser) braceEnd(i, end int) int {
+	var stack []int
+	for i < end {
+		if j := p.skipOpaque(i, end); j > 0 {
+			i = j
+			continue
+		}
+		switch {
+		case p.has(i, end, "{{"):
+			n := p.run(i, end, '{')
+			i += n
+			for ; n > 3 || n == 2; n -= 2 {
+				stack = append(stack, 2)
+			}
+			if n == 3 {
+				stack = append(stack, 3)
+			}
+		case p.has(i, end, "}}") && len(stack) > 0:
+			n := p.run(i, end, '}')
+			for n >= 2 && len(stack) > 0 {
+				w := stack[len(stack)-1]
+				if w > n {
+					w = n
+				}
+				n -= w
+				i += w
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 {
+				return i
+			}
+			i += n
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// linkEnd finds the end of the internal link starting at i.
+func (p *wikitextParser) linkEnd(i, end int) int {
+	depth := 0
+	for i < end {
+		if j := p.skipOpaque(i, end); j > 0 {
+			i = j
+			continue
+		}
+		switch {
+		case p.has(i, end, "{{"):
+			if j := p.braceEnd(i, end); j > 0 {
+				i = j
+			} else {
+				i += 2
+			}
+		case p.has(i, end, "[["):
+			depth++
+			i += 2
+		case p.has(i, end, "]]"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		case p.has(i, end, "\n\n"):
+			return -1
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// lineEnd finds the end of the logical line starting at i; newlines
+// within templates, links, comments and tags don't end a line.
+func (p *wikitextParser) lineEnd(i, end int) int {
+	for i < end {
+		if p.src[i] == '\n' {
+			return i
+		}
+		if j := p.skip(i, end); j > 0 {
+			i = j
+			continue
+		}
+		i++
+	}
+	return end
+}
+
+// splitTop splits the given range on any of the separators where they
+// don't appear within a nested construct.
+func (p *wikitextParser) splitTop(start, end int, seps ...string) [][2]int {
+	var rv [][2]int
+	i, from := start, start
+outer:
+	for i < end {
+		if j := p.skip(i, end); j > 0 {
+			i = j
+			continue
+		}
+		for _, sep := range seps {
+			if p.has(i, end, sep) {
+				rv = append(rv, [2]int{from, i})
+				i += len(sep)
+				from = i
+				continue outer
+			}
+		}
+		i++
+	}
+	return append(rv, [2]int{from, end})
+}
+
+func (p *wikitextParser) trimRange(start, end int) (int, int) {
+	for start < end && isSpace(p.src[start]) {
+		start++
+	}
+	for end > start && isSpace(p.src[end-1]) {
+		end--
+	}
+	return start, end
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func (p *wikitextParser) blocks(start, end int) []*Node {
+	var rv []*Node
+	var para *Node
+	endPara := func() {
+		if para != nil {
+			rv = append(rv, para)
+			para = nil
+		}
+	}
+	for i := start; i < end; {
+		le := p.lineEnd(i, end)
+		next := le + 1
+		line := p.src[i:le]
+		trimmed := strings.TrimLeft(line, " \t")
+		var h *Node
+		if strings.HasPrefix(line, "=") {
+			h = p.heading(i, le)
+		}
+		switch {
+		case strings.TrimSpace(line) == "":
+			endPara()
+		case h != nil:
+			endPara()
+			rv = append(rv, h)
+		case strings.HasPrefix(line, "----"):
+			endPara()
+			rv = append(rv, &Node{Kind: HRNode, Pos: i, End: le})
+		case strings.HasPrefix(trimmed, "{|"):
+			endPara()
+			var t *Node
+			t, next = p.table(i, end)
+			rv = append(rv, t)
+		case strings.IndexByte("*#:;", line[0]) >= 0:
+			endPara()
+			n := len(line) - len(strings.TrimLeft(line, "*#:;"))
+			rv = append(rv, &Node{Kind: ListItemNode, Prefix: line[:n],
+				Children: p.inline(i+n, le), Pos: i, End: le})
+		default:
+			if para == nil {
+				para = &Node{Kind: ParagraphNode, Pos: i}
+			} else {
+				para.Children = append(para.Children,
+					&Node{Kind: TextNode, Text: "\n", Pos: i - 1, End: i})
+			}
+			para.Children = append(para.Children, p.inline(i, le)...)
+			para.End = le
+		}
+		i = next
+	}
+	endPara()
+	return rv
+}
+
+func (p *wikitextParser) heading(start, end int) *Node {
+	e := end
+	for {
+		for e > start && isSpace(p.src[e-1]) {
+			e--
+		}
+		if !strings.HasSuffix(p.src[start:e], "-->") {
+			break
+		}
+		c := strings.LastIndex(p.src[start:e], "<!--")
+		if c < 0 {
+			break
+		}
+		e = start + c
+	}
+	lead := p.run(start, e, '=')
+	trail := 0
+	for trail < e-start && p.src[e-1-trail] == '=' {
+		trail++
+	}
+	n := lead
+	if trail < n {
+		n = trail
+	}
+	if n > 6 {
+		n = 6
+	}
+	if n == 0 || start+n >= e-n {
+		return nil
+	}
+	return &Node{Kind: HeadingNode, Level: n,
+		Children: p.inline(start+n, e-n), Pos: start, End: end}
+}
+
+func (p *wikitextParser) table(start, end int) (*Node, int) {
+	le := p.lineEnd(start, end)
+	open := start + strings.Index(p.src[start:le], "{|")
+	tbl := &Node{Kind: TableNode, Attrs: parseAttrs(p.src[open+2 : le]), Pos: start}
+
+	var row, cell *Node
+	contentStart, prevEnd := 0, le
+	closeCell := func(end int) {
+		if cell == nil {
+			return
+		}
+		if end > contentStart {
+			cell.Children = p.blocks(contentStart, end)
+		}
+		cell.End = end
+		cell = nil
+	}
+
+	depth := 0
+	for i := le + 1; i < end; i = le + 1 {
+		le = p.lineEnd(i, end)
+		line := strings.TrimLeft(p.src[i:le], " \t")
+		off := le - len(line)
+		switch {
+		case depth > 0:
+			// Lines of a nested table belong to the current cell.
+			if strings.HasPrefix(line, "{|") {
+				depth++
+			} else if strings.HasPrefix(line, "|}") {
+				depth--
+			}
+		case strings.HasPrefix(line, "{|"):
+			depth++
+		case strings.HasPrefix(line, "|}"):
+			closeCell(prevEnd)
+			tbl.End = off + 2
+			return tbl, le + 1
+		case strings.HasPrefix(line, "|+"):
+			closeCell(prevEnd)
+			tbl.Children = append(tbl.Children, &Node{Kind: TableCaptionNode,
+				Children: p.inline(off+2, le), Pos: off, End: le})
+		case strings.HasPrefix(line, "|-"):
+			closeCell(prevEnd)
+			row = &Node{Kind: TableRowNode, Attrs: parseAttrs(line[2:]), Pos: off, End: le}
+			tbl.Children = append(tbl.Children, row)
+		case line != "" && (line[0] == '|' || line[0] == '!'):
+			closeCell(prevEnd)
+			if row == nil {
+				row = &Node{Kind: TableRowNode, Pos: off}
+				tbl.Children = append(tbl.Children, row)
+			}
+			kind, seps := TableCellNode, []string{"||"}
+			if line[0] == '!' {
+				kind, seps = TableHeaderNode, []string{"||", "!!"}
+			}
+			for _, r := range p.splitTop(off+1, le, seps...) {
+				closeCell(prevEnd)
+				cell = &Node{Kind: kind, Pos: r[0]}
+				contentStart = r[0]
+				if a := p.splitTop(r[0], r[1], "|"); len(a) > 1 {
+					cell.Attrs = parseAttrs(p.src[a[0][0]:a[0][1]])
+					contentStart = a[1][0]
+				}
+				row.Children = append(row.Children, cell)
+				prevEnd = r[1]
+			}
+		}
+		if row != nil && le > row.End {
+			row.End = le
+		}
+		prevEnd = le
+	}
+	closeCell(end)
+	tbl.End = end
+	return tbl, end
+}
+
+func (p *wikitextParser) inline(start, end int) []*Node {
+	var rv []*Node
+	text := start
+	for i := start; i < end; {
+		var n *Node
+		next := -1
+		switch p.src[i] {
+		case '<':
+			n, next = p.angle(i, end)
+		case '{':
+			if p.has(i, end, "{{") {
+				n, next = p.template(i, end)
+			}
+		case '[':
+			if p.has(i, end, "[[") {
+				n, next = p.link(i, end)
+			} else {
+				n, next = p.extLink(i, end)
+			}
+		case '\'':
+			if p.has(i, end, "''") {
+				n, next = p.apostrophes(i, end)
+			}
+		case '_':
+			if m := magicWordRE.FindString(p.src[i:end]); m != "" {
+				next = i + len(m)
+			}
+		}
+		if next < 0 {
+			i++
+			continue
+		}
+		if i > text {
+			rv = append(rv, &Node{Kind: TextNode, Text: p.src[text:i], Pos: text, End: i})
+		}
+		if n != nil {
+			rv = append(rv, n)
+		}
+		i, text = next, next
+	}
+	if end > text {
+		rv = append(rv, &Node{Kind: TextNode, Text: p.src[text:end], Pos: text, End: end})
+	}
+	return nestFormatting(rv)
+}
+
+func (p *wikitextParser) angle(i, end int) (*Node, int) {
+	if p.has(i, end, "<!--") {
+		e := p.commentEnd(i, end)
+		c := strings.TrimSuffix(p.src[i+4:e], "-->")
+		return &Node{Kind: CommentNode, Text: c, Pos: i, End: e}, e
+	}
+	t := p.tagAt(i, end)
+	if t == nil {
+		return nil, -1
+	}
+	if isExtTag(t.name) && !t.closing {
+		n := &Node{Kind: TagNode, Name: t.name, Attrs: t.attrs, Pos: i, End: t.end}
+		if t.selfClosing {
+			return n, t.end
+		}
+		if ce, e := p.extTagClose(t, end); e > 0 {
+			n.Text = p.src[t.end:ce]
+			if parsedTags[t.name] {
+				n.Children = p.blocksOrInline(t.end, ce)
+			}
+			n.End = e
+			return n, e
+		}
+	}
+	return &Node{Kind: HTMLNode, Name: t.name, Attrs: t.attrs, Closing: t.closing,
+		Text: p.src[i:t.end], Pos: i, End: t.end}, t.end
+}
+
+// blocksOrInline parses tag content as blocks if it spans several
+// lines, otherwise as inline text.
+func (p *wikitextParser) blocksOrInline(start, end int) []*Node {
+	if strings.Contains(strings.TrimSpace(p.src[start:end]), "\n") {
+		return p.blocks(start, end)
+	}
+	return p.inline(start, end)
+}
+
+func (p *wikitextParser) template(i, end int) (*Node, int) {
+	e := p.braceEnd(i, end)
+	if e < 0 {
+		return nil, -1
+	}
+	kind, w := TemplateNode, 2
+	if p.run(i, end, '{') == 3 && p.has(e-3, e, "}}}") {
+		kind, w = ArgumentNode, 3
+	}
+	parts := p.splitTop(i+w, e-w, "|")
+	n := &Node{Kind: kind, Pos: i, End: e,
+		Name: strings.TrimSpace(p.src[parts[0][0]:parts[0][1]])}
+	positional := 0
+	for _, r := range parts[1:] {
+		var param Param
+		if eq := p.splitTop(r[0], r[1], "="); len(eq) > 1 && kind == TemplateNode {
+			param.Name = strings.TrimSpace(p.src[r[0]:eq[0][1]])
+			param.Pos, param.End = p.trimRange(eq[1][0], r[1])
+		} else {
+			positional++
+			param.Name = strconv.Itoa(positional)
+			param.Pos, param.End = r[0], r[1]
+		}
+		param.Raw = p.src[param.Pos:param.End]
+		param.Value = p.inline(param.Pos, param.End)
+		n.Params = append(n.Params, param)
+	}
+	return n, e
+}
+
+func (p *wikitextParser) link(i, end int) (*Node, int) {
+	e := p.linkEnd(i, end)
+	if e < 0 {
+		return nil, -1
+	}
+	parts := p.splitTop(i+2, e-2, "|")
+	ts, te := p.trimRange(parts[0][0], parts[0][1])
+	if ts == te || strings.ContainsAny(p.src[ts:te], "\n[]{}<>") {
+		return nil, -1
+	}
+	n := &Node{Kind: LinkNode, Name: p.src[ts:te], Pos: i}
+	for k, r := range parts[1:] {
+		n.Params = append(n.Params, Param{Name: strconv.Itoa(k + 1),
+			Raw: p.src[r[0]:r[1]], Value: p.inline(r[0], r[1]),
+			Pos: r[0], End: r[1]})
+	}
+	if len(parts) > 1 {
+		n.Children = p.inline(parts[1][0], e-2)
+	} else {
+		n.Children = []*Node{{Kind: TextNode, Text: n.Name, Pos: ts, End: te}}
+	}
+	// Letters directly after a link are part of its label.
+	t := e
+	for t < end && ('a' <= p.src[t] && p.src[t] <= 'z') {
+		t++
+	}
+	if t > e {
+		n.Children = append(n.Children, &Node{Kind: TextNode, Text: p.src[e:t], Pos: e, End: t})
+	}
+	n.End = t
+	return n, t
+}
+
+func (p *wikitextParser) extLink(i, end int) (*Node, int) {
+	m := extLinkRE.FindStringSubmatchIndex(p.src[i:end])
+	if m == nil {
+		return nil, -1
+	}
+	n := &Node{Kind: ExtLinkNode, Name: p.src[i+m[2] : i+m[3]], Pos: i}
+	for j := i + m[1]; j < end && p.src[j] != '\n'; {
+		if k := p.skip(j, end); k > 0 {
+			j = k
+			continue
+		}
+		if p.src[j] == ']' {
+			if ls, le := p.trimRange(i+m[1], j); le > ls {
+				n.Children = p.inline(ls, le)
+			}
+			n.End = j + 1
+			return n, n.End
+		}
+		j++
+	}
+	return nil, -1
+}
+
+func (p *wikitextParser) apostrophes(i, end int) (*Node, int) {
+	n := p.run(i, end, '\'')
+	m := &Node{Kind: formatMarker, Pos: i, End: i + n}
+	switch {
+	case n == 4:
+		m.Text, m.Level = "'", 3
+	case n > 5:
+		m.Text, m.Level = p.src[i:i+n-5], 5
+	default:
+		m.Level = n
+	}
+	return m, i + n
+}
+
+// nestFormatting turns the apostrophe markers in a line of inline
+// nodes into nested bold and italic nodes.
+func nestFormatting(nodes []*Node) []*Node {
+	hasMarker := false
+	for _, n := range nodes {
+		if n.Kind == formatMarker {
+			hasMarker = true
+			break
+		}
+	}
+	if !hasMarker {
+		return nodes
+	}
+
+	root := &Node{}
+	stack := []*Node{root}
+	add := func(n *Node) {
+		top := stack[len(stack)-1]
+		top.Children = append(top.Children, n)
+		for _, s := range stack[1:] {
+			s.End = n.End
+		}
+	}
+	open := func(k NodeKind, pos int) {
+		n := &Node{Kind: k, Pos: pos, End: pos}
+		add(n)
+		stack = append(stack, n)
+	}
+	isOpen := func(k NodeKind) bool {
+		for _, s := range stack[1:] {
+			if s.Kind == k {
+				return true
+			}
+		}
+		return false
+	}
+	toggle := func(k NodeKind, m *Node) {
+		for j := len(stack) - 1; j > 0; j-- {
+			if stack[j].Kind != k {
+				continue
+			}
+			// Close it, reopening anything that was inside it.
+			inner := stack[j+1:]
+			for _, s := range stack[j:] {
+				s.End = m.End
+			}
+			stack = stack[:j]
+			for _, s := range inner {
+				open(s.Kind, m.End)
+			}
+			return
+		}
+		open(k, m.Pos)
+	}
+
+	for _, n := range nodes {
+		if n.Kind != formatMarker {
+			add(n)
+			continue
+		}
+		if n.Text != "" {
+			add(&Node{Kind: TextNode, Text: n.Text, Pos: n.Pos, End: n.Pos + len(n.Text)})
+		}
+		switch n.Level {
+		case 2:
+			toggle(ItalicNode, n)
+		case 3:
+			toggle(BoldNode, n)
+		case 5:
+			b, it := isOpen(BoldNode), isOpen(ItalicNode)
+			switch {
+			case b && it:
+				toggle(stack[len(stack)-1].Kind, n)
+				toggle(stack[len(stack)-1].Kind, n)
+			case b:
+				toggle(BoldNode, n)
+				toggle(ItalicNode, n)
+			case it:
+				toggle(ItalicNode, n)
+				toggle(BoldNode, n)
+			default:
+				toggle(BoldNode, n)
+				toggle(ItalicNode, n)
+			}
+		}
+	}
+	return root.Children
+}
@@ -0,0 +1,300 @@
+package wikiparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func kinds(nodes []*Node) []NodeKind {
+	rv := []NodeKind{}
+	for _, n := range nodes {
+		rv = append(rv, n.Kind)
+	}
+	return rv
+}
+
+func TestWikitextBlocks(t *testing.T) {
+	t.Parallel()
+	nodes := ParseWikitext(`== Heading ==
+Some text
+continued.
+
+* one
+*# two
+----
+{| class="wikitable"
+|}
+{{Template
+|spans=lines}} more`)
+	exp := []NodeKind{HeadingNode, ParagraphNode, ListItemNode,
+		ListItemNode, HRNode, TableNode, ParagraphNode}
+	if got := kinds(nodes); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+	if nodes[0].Level != 2 {
+		t.Errorf("Expected level 2 heading, got %v", nodes[0].Level)
+	}
+	if nodes[3].Prefix != "*#" {
+		t.Errorf("Expected *# prefix, got %q", nodes[3].Prefix)
+	}
+	if nodes[5].Attrs["class"] != "wikitable" {
+		t.Errorf("Expected table class, got %v", nodes[5].Attrs)
+	}
+}
+
+func TestWikitextHeadings(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in    string
+		level int
+		text  string
+	}{
+		{"== Foo ==", 2, "Foo"},
+		{"===Foo===", 3, "Foo"},
+		{"==Foo===", 2, "Foo="},
+		{"== Foo == <!-- comment -->", 2, "Foo"},
+		{"==''Foo'' [[bar]]==  ", 2, "Foo bar"},
+		{"========Deep========", 6, "==Deep=="},
+		{"==", 0, ""},
+		{"= x", 0, ""},
+	}
+
+	for _, test := range tests {
+		nodes := ParseWikitext(test.in)
+		if test.level == 0 {
+			if len(nodes) > 0 && nodes[0].Kind == HeadingNode {
+				t.Errorf("Expected no heading from %q, got %v", test.in, nodes[0])
+			}
+			continue
+		}
+		if len(nodes) != 1 || nodes[0].Kind != HeadingNode {
+			t.Errorf("Expected a heading from %q, got %v", test.in, kinds(nodes))
+			continue
+		}
+		if nodes[0].Level != test.level {
+			t.Errorf("Expected level %v for %q, got %v", test.level, test.in, nodes[0].Level)
+		}
+		if got := inlineText(nodes[0].Children); got != test.text {
+			t.Errorf("Expected %q for %q, got %q", test.text, test.in, got)
+		}
+	}
+}
+
+func TestWikitextTemplate(t *testing.T) {
+	t.Parallel()
+	src := "x {{ template:cite_web |url=http://x/?a=b | title = A [[b|c]] |{{nested|1}}|pos}} y"
+	nodes := ParseWikitext(src)
+	var tmpl *Node
+	Walk(nodes, func(n *Node) bool {
+		if n.Kind == TemplateNode && tmpl == nil {
+			tmpl = n
+		}
+		return true
+	})
+	if tmpl == nil {
+		t.Fatalf("Found no template in %v", kinds(nodes[0].Children))
+	}
+	if tmpl.TemplateName() != "Cite web" {
+		t.Errorf("Expected Cite web, got %q", tmpl.TemplateName())
+	}
+	if src[tmpl.Pos:tmpl.End] != src[2:len(src)-2] {
+		t.Errorf("Wrong template range: %q", src[tmpl.Pos:tmpl.End])
+	}
+	params := map[string]string{}
+	for _, p := range tmpl.Params {
+		params[p.Name] = p.Raw
+	}
+	exp := map[string]string{
+		"url":   "http://x/?a=b",
+		"title": "A [[b|c]]",
+		"1":     "{{nested|1}}",
+		"2":     "pos",
+	}
+	if !reflect.DeepEqual(exp, params) {
+		t.Errorf("Expected %v, got %v", exp, params)
+	}
+	if tmpl.ParamText("title") != "A [[b|c]]" {
+		t.Errorf("Wrong title: %q", tmpl.ParamText("title"))
+	}
+	if tmpl.ParamText("missing") != "" {
+		t.Errorf("Expected nothing for missing param")
+	}
+}
+
+func TestWikitextBraces(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		exp  []NodeKind
+		name string
+	}{
+		{"{{a|{{{1}}}}}", []NodeKind{TemplateNode}, "a"},
+		{"{{{1|{{b}}}}}", []NodeKind{ArgumentNode}, "1"},
+		{"{{{{a}}|b}}", []NodeKind{TemplateNode}, "{{a}}"},
+		{"{{a|<nowiki>}}</nowiki>}}", []NodeKind{TemplateNode}, "a"},
+		{"{{a|<!-- }} -->}}", []NodeKind{TemplateNode}, "a"},
+		{"{{unclosed", []NodeKind{TextNode}, ""},
+	}
+	for _, test := range tests {
+		nodes := ParseWikitext(test.in)
+		got := kinds(nodes[0].Children)
+		if !reflect.DeepEqual(test.exp, got) {
+			t.Errorf("Expected %v for %q, got %v", test.exp, test.in, got)
+			continue
+		}
+		if n := nodes[0].Children[0]; n.Name != test.name {
+			t.Errorf("Expected name %q for %q, got %q", test.name, test.in, n.Name)
+		}
+	}
+}
+
+func TestWikitextLinks(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, target, label string
+	}{
+		{"[[Foo]]", "Foo", "Foo"},
+		{"[[Foo|bar]]", "Foo", "bar"},
+		{"[[animal]]s", "animal", "animals"},
+		{"[[Foo|bar]]s", "Foo", "bars"},
+		{"[[File:X.jpg|thumb|A [[b]] c]]", "File:X.jpg", "thumb|A b c"},
+		{"[http://example.com/ Example]", "http://example.com/", "Example"},
+		{"[//example.com/ Example [[x|y]]]", "//example.com/", "Example y"},
+		{"[https://example.com]", "https://example.com", ""},
+	}
+	for _, test := range tests {
+		nodes := ParseWikitext(test.in)
+		n := nodes[0].Children[0]
+		if n.Kind != LinkNode && n.Kind != ExtLinkNode {
+			t.Errorf("Expected link from %q, got %v", test.in, n.Kind)
+			continue
+		}
+		if n.Name != test.target {
+			t.Errorf("Expected target %q from %q, got %q", test.target, test.in, n.Name)
+		}
+		if got := inlineText(n.Children); got != test.label {
+			t.Errorf("Expected label %q from %q, got %q", test.label, test.in, got)
+		}
+		if n.End != len(test.in) {
+			t.Errorf("Expected link to end at %v in %q, got %v", len(test.in), test.in, n.End)
+		}
+	}
+}
+
+func TestWikitextFormatting(t *testing.T) {
+	t.Parallel()
+	nodes := ParseWikitext("a '''b ''c''' d'' e")
+	exp := []NodeKind{TextNode, BoldNode, ItalicNode, TextNode}
+	if got := kinds(nodes[0].Children); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+	bold := nodes[0].Children[1]
+	if got := kinds(bold.Children); !reflect.DeepEqual([]NodeKind{TextNode, ItalicNode}, got) {
+		t.Errorf("Expected text and italic in bold, got %v", got)
+	}
+
+	nodes = ParseWikitext("'''''both''''' ''''four''''")
+	exp = []NodeKind{BoldNode, TextNode, TextNode, BoldNode}
+	if got := kinds(nodes[0].Children); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestWikitextTags(t *testing.T) {
+	t.Parallel()
+	nodes := ParseWikitext(`a<ref name="x" group=n>{{cite|b}}</ref> <ref name=y /><br/><nowiki>[[no]]</nowiki></span>`)
+	exp := []NodeKind{TextNode, TagNode, TextNode, TagNode, HTMLNode, TagNode, HTMLNode}
+	c := nodes[0].Children
+	if got := kinds(c); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+	if !reflect.DeepEqual(map[string]string{"name": "x", "group": "n"}, c[1].Attrs) {
+		t.Errorf("Wrong ref attributes: %v", c[1].Attrs)
+	}
+	if got := kinds(c[1].Children); !reflect.DeepEqual([]NodeKind{TemplateNode}, got) {
+		t.Errorf("Expected parsed ref content, got %v", got)
+	}
+	if c[3].Attrs["name"] != "y" || c[3].Text != "" {
+		t.Errorf("Wrong self-closed ref: %#v", c[3])
+	}
+	if c[5].Text != "[[no]]" || len(c[5].Children) != 0 {
+		t.Errorf("Wrong nowiki: %#v", c[5])
+	}
+	if !c[6].Closing || c[6].Name != "span" {
+		t.Errorf("Wrong closing tag: %#v", c[6])
+	}
+}
+
+func TestWikitextTable(t *testing.T) {
+	t.Parallel()
+	nodes := ParseWikitext(`{| class="wikitable"
+|+ Caption
+! H1 !! H2
+|-
+| a || style="color:red" | [[b|c]]
+| d
+* more d
+{|
+| nested
+|}
+|}
+after`)
+	exp := []NodeKind{TableNode, ParagraphNode}
+	if got := kinds(nodes); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+	tbl := nodes[0]
+	exp = []NodeKind{TableCaptionNode, TableRowNode, TableRowNode}
+	if got := kinds(tbl.Children); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+	exp = []NodeKind{TableHeaderNode, TableHeaderNode}
+	if got := kinds(tbl.Children[1].Children); !reflect.DeepEqual(exp, got) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+	cells := tbl.Children[2].Children
+	exp = []NodeKind{TableCellNode, TableCellNode, TableCellNode}
+	if got := kinds(cells); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected %v, got %v", exp, got)
+	}
+	if cells[1].Attrs["style"] != "color:red" {
+		t.Errorf("Expected cell style, got %v", cells[1].Attrs)
+	}
+	exp = []NodeKind{ParagraphNode, ListItemNode, TableNode}
+	if got := kinds(cells[2].Children); !reflect.DeepEqual(exp, got) {
+		t.Errorf("Expected %v in multiline cell, got %v", exp, got)
+	}
+}
+
+func TestWikitextSponge(t *testing.T) {
+	t.Parallel()
+	var links []string
+	Walk(ParseWikitext(sponge), func(n *Node) bool {
+		if n.Kind == LinkNode {
+			links = append(links, n.Name)
+		}
+		return n.Kind != CommentNode
+	})
+	// FindLinks also sees links within captions and extension tags.
+	if len(links) < 150 {
+		t.Errorf("Expected more links, got %v", len(links))
+	}
+}
+
+func TestFindTemplates(t *testing.T) {
+	t.Parallel()
+	text := `{{Infobox lighthouse|coordinates={{coord|44|29|N|63|55|W}}}}
+{{#if:{{{x|}}}|{{Yes}}}} {{DEFAULTSORT:Peggys Point}} {{PAGENAME}} {{CURRENTYEAR}} {{!}}
+<!-- {{Hidden}} --> {{template:cite_web|url=x}} {{coord|1|2}} {{Reflist}}`
+	exp := []string{"Infobox lighthouse", "Coord", "Yes", "Cite web", "Reflist"}
+	if got := FindTemplates(text); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %q, got %q", exp, got)
+	}
+}
+
+func BenchmarkParseWikitext(b *testing.B) {
+	b.SetBytes(int64(len(sponge)))
+	for i := 0; i < b.N; i++ {
+		ParseWikitext(sponge)
+	}
+}